@@ -0,0 +1,132 @@
+/*
+Copyright © 2021 PeerBridge
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/peerbridge/peerbridge/pkg/blockchain"
+	"github.com/peerbridge/peerbridge/pkg/color"
+	"github.com/spf13/cobra"
+)
+
+// The node which is managed by admin commands.
+var adminHost string
+
+// The admin token of the managed node.
+var adminToken string
+
+// Run admin commands directly on the database.
+var offline bool
+
+// Add the flags which are used to reach the admin
+// routes of a node to the given command.
+func addAdminFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&adminHost, "host", "http://localhost:8080", "blockchain node to manage")
+	cmd.Flags().StringVar(&adminToken, "token", os.Getenv("ADMIN_TOKEN"), "admin token of the node (default is $ADMIN_TOKEN)")
+	cmd.Flags().BoolVar(&offline, "offline", false, "operate directly on the database while the node is not running")
+}
+
+// Send a request to the admin routes of a node.
+// The request and response objects are JSON serialized.
+func postAdminRequest(path string, request interface{}, response interface{}) (err error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return
+	}
+
+	url := fmt.Sprintf("%s%s", adminHost, path)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(data))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", adminToken))
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("The node responded with %d %s", res.StatusCode, http.StatusText(res.StatusCode))
+	}
+
+	if response == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(response)
+}
+
+// Run a rollback operation either on a running node, or
+// directly on the database if the --offline flag is set.
+func runRollback(
+	path string,
+	request interface{},
+	local func() (*blockchain.RollbackResult, error),
+) (result *blockchain.RollbackResult, err error) {
+	if !offline {
+		var response blockchain.RollbackResponse
+		err = postAdminRequest(path, request, &response)
+		if err != nil {
+			return
+		}
+		return response.Result, nil
+	}
+
+	blockchain.InitRepo()
+	defer blockchain.Repo.DB.Close()
+	blockchain.InitChain(nil)
+
+	blockchain.Instance.ThreadSafe(func() {
+		result, err = local()
+	})
+	return
+}
+
+// Print the result of a rollback operation.
+func printRollbackResult(result *blockchain.RollbackResult) {
+	msg := fmt.Sprintf(
+		"Removed %s block(s). The new main chain head is %s at height %s.",
+		color.Sprintf(fmt.Sprint(result.RemovedBlocks), color.Warning),
+		color.Sprintf(result.HeadID, color.Notice),
+		color.Sprintf(fmt.Sprint(result.HeadHeight), color.Info),
+	)
+	fmt.Println(msg)
+
+	if offline {
+		msg = "Transactions of removed blocks were not requeued, since the node is offline. They have to be resubmitted."
+		fmt.Println(color.Sprintf(msg, color.Warning))
+		return
+	}
+
+	msg = fmt.Sprintf(
+		"Requeued %s transaction(s) of removed blocks.",
+		color.Sprintf(fmt.Sprint(result.RequeuedTransactions), color.Success),
+	)
+	fmt.Println(msg)
+}
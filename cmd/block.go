@@ -0,0 +1,75 @@
+/*
+Copyright © 2021 PeerBridge
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/peerbridge/peerbridge/pkg/blockchain"
+	"github.com/peerbridge/peerbridge/pkg/color"
+	"github.com/spf13/cobra"
+)
+
+var invalidationReason string
+
+// blockCmd represents the block command
+var blockCmd = &cobra.Command{
+	Use:   "block",
+	Short: "Manage blocks of a blockchain node",
+	Long:  "Manage the blocks that are stored by a PeerBridge blockchain node.",
+}
+
+var invalidateBlockCmd = &cobra.Command{
+	Use:   "invalidate <id>",
+	Short: "Invalidate a block and its descendants",
+	Long: `Invalidate a block, so that it is never added to the chain again.
+The block is removed together with all of its descendants, and their
+transactions are put back into the pending transactions of the node.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		id := args[0]
+
+		msg := fmt.Sprintf("Invalidating block %s.", color.Sprintf(id, color.Notice))
+		fmt.Println(msg)
+
+		result, err := runRollback(
+			"/blockchain/admin/blocks/invalidate",
+			blockchain.InvalidateBlockRequest{ID: &id, Reason: invalidationReason},
+			func() (*blockchain.RollbackResult, error) {
+				return blockchain.Instance.InvalidateBlock(id, invalidationReason)
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("Failed to invalidate block. %s", err.Error())
+		}
+
+		printRollbackResult(result)
+		return
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(blockCmd)
+	blockCmd.AddCommand(invalidateBlockCmd)
+	addAdminFlags(invalidateBlockCmd)
+	invalidateBlockCmd.Flags().StringVar(&invalidationReason, "reason", "", "reason for the invalidation")
+}
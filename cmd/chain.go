@@ -0,0 +1,215 @@
+/*
+Copyright © 2021 PeerBridge
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/peerbridge/peerbridge/pkg/blockchain"
+	"github.com/peerbridge/peerbridge/pkg/color"
+	"github.com/spf13/cobra"
+)
+
+var exportFrom int64
+var exportTo int64
+var exportOutput string
+
+// chainCmd represents the chain command
+var chainCmd = &cobra.Command{
+	Use:   "chain",
+	Short: "Manage the locally stored blockchain",
+	Long: `Manage the blockchain that is stored in the database of a PeerBridge node.
+The database is configured with the DATABASE_URL environment variable.`,
+}
+
+var exportChainCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export main chain blocks into a bootstrap file",
+	Long: `Export a range of main chain blocks into a compressed bootstrap file,
+which can be imported by other nodes with "peerbridge chain import".`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		// Only connect to the database, since the export
+		// must not migrate or repair the stored chain
+		repo, err := connectReadOnlyRepo()
+		if err != nil {
+			return
+		}
+		defer repo.DB.Close()
+
+		endpoint, err := repo.GetMainChainEndpoint()
+		if err != nil {
+			return
+		}
+
+		to := uint64(exportTo)
+		if exportTo < 0 || to > endpoint.Height {
+			to = endpoint.Height
+		}
+		if exportFrom < 0 || uint64(exportFrom) > to {
+			return errors.New("Invalid block range!")
+		}
+		from := uint64(exportFrom)
+
+		msg := fmt.Sprintf(
+			"Exporting main chain blocks from height %s to %s into %s.",
+			color.Sprintf(fmt.Sprint(from), color.Notice),
+			color.Sprintf(fmt.Sprint(to), color.Notice),
+			color.Sprintf(exportOutput, color.Info),
+		)
+		fmt.Println(msg)
+
+		// Write into a temporary file first, so that
+		// no partial bootstrap files are left behind
+		tmp := exportOutput + ".tmp"
+		file, err := os.Create(tmp)
+		if err != nil {
+			return
+		}
+		defer os.Remove(tmp)
+
+		n, err := repo.ExportMainChain(file, from, to)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("Failed to export blocks. %s", err.Error())
+		}
+		if err = file.Close(); err != nil {
+			return
+		}
+		if err = os.Rename(tmp, exportOutput); err != nil {
+			return
+		}
+
+		msg = fmt.Sprintf("Successfully exported %s block(s).", color.Sprintf(fmt.Sprint(n), color.Success))
+		fmt.Println(msg)
+		return
+	},
+}
+
+var verifyChainCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify the locally stored main chain",
+	Long: `Walk the stored main chain from genesis to its head and re-verify every block.
+The result is printed as JSON. If an inconsistency is found, the command
+exits with a non-zero exit code. The database is not modified.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		// Only connect to the database, since the verification
+		// must not migrate or repair the stored chain
+		blockchain.Repo, err = connectReadOnlyRepo()
+		if err != nil {
+			return
+		}
+		defer blockchain.Repo.DB.Close()
+		blockchain.InitChain(nil)
+
+		return verifyChain()
+	},
+}
+
+// Connect to the database without migrating or repairing it,
+// for commands which only read the stored chain. Returns an
+// error if the database schema is not up to date.
+func connectReadOnlyRepo() (*blockchain.BlockRepo, error) {
+	repo := blockchain.ConnectRepo()
+	version, err := repo.SchemaVersion()
+	if err != nil {
+		repo.DB.Close()
+		return nil, err
+	}
+	if version != blockchain.LatestSchemaVersion() {
+		repo.DB.Close()
+		return nil, errors.New("The database schema is outdated, run \"peerbridge db migrate\" first!")
+	}
+	return repo, nil
+}
+
+// Verify the main chain and print the report as JSON.
+// Returns an error if the chain is inconsistent.
+func verifyChain() error {
+	report, err := blockchain.Instance.VerifyChain()
+	if err != nil {
+		return fmt.Errorf("Failed to verify the chain. %s", err.Error())
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+
+	if !report.OK {
+		return blockchain.ErrChainVerificationFailed
+	}
+	return nil
+}
+
+var importChainCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Import blocks from a bootstrap file",
+	Long: `Import blocks from a bootstrap file that was created with "peerbridge chain export".
+Every block is validated before it is added to the chain.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return
+		}
+		defer file.Close()
+
+		blockchain.InitRepo()
+		defer blockchain.Repo.DB.Close()
+
+		// The chain is only used to validate and
+		// store blocks, so no key pair is needed
+		blockchain.InitChain(nil)
+
+		msg := fmt.Sprintf("Importing blocks from %s.", color.Sprintf(args[0], color.Info))
+		fmt.Println(msg)
+
+		result, err := blockchain.Instance.ImportChain(file)
+		if result != nil {
+			msg = fmt.Sprintf(
+				"Imported %s block(s), skipped %s block(s) which were already in the chain.",
+				color.Sprintf(fmt.Sprint(result.Imported), color.Success),
+				color.Sprintf(fmt.Sprint(result.Skipped), color.Notice),
+			)
+			fmt.Println(msg)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to import blocks. %s", err.Error())
+		}
+		return
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(chainCmd)
+	chainCmd.AddCommand(exportChainCmd)
+	chainCmd.AddCommand(importChainCmd)
+	chainCmd.AddCommand(verifyChainCmd)
+	exportChainCmd.Flags().Int64Var(&exportFrom, "from", 0, "height of the first block to export")
+	exportChainCmd.Flags().Int64Var(&exportTo, "to", -1, "height of the last block to export (default is the main chain head)")
+	exportChainCmd.Flags().StringVarP(&exportOutput, "output", "o", "peerbridge.bootstrap", "path of the bootstrap file to write")
+}
@@ -0,0 +1,148 @@
+/*
+Copyright © 2021 PeerBridge
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/peerbridge/peerbridge/pkg/blockchain"
+	"github.com/peerbridge/peerbridge/pkg/color"
+	"github.com/spf13/cobra"
+)
+
+var migrationTarget int
+var rollbackHeight int64
+
+// dbCmd represents the db command
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Manage the blockchain database",
+	Long: `Manage the PostgreSQL database of a PeerBridge blockchain node.
+The database is configured with the DATABASE_URL environment variable.`,
+}
+
+var migrateDBCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Migrate the database schema",
+	Long: `Migrate the database schema to the latest version, or to the version
+given with --to. Migrations are reverted if the target version is
+lower than the current version of the database. The initial
+migration, which creates the blocks and transactions, cannot be reverted.`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		repo := blockchain.ConnectRepo()
+		defer repo.DB.Close()
+
+		current, err := repo.SchemaVersion()
+		if err != nil {
+			return
+		}
+
+		target := migrationTarget
+		if target < 0 {
+			target = blockchain.LatestSchemaVersion()
+		}
+
+		msg := fmt.Sprintf(
+			"Migrating database schema from version %s to version %s.",
+			color.Sprintf(fmt.Sprint(current), color.Notice),
+			color.Sprintf(fmt.Sprint(target), color.Notice),
+		)
+		fmt.Println(msg)
+
+		err = repo.Migrate(target)
+		if err != nil {
+			return fmt.Errorf("Failed to migrate the database. %s", err.Error())
+		}
+
+		msg = fmt.Sprintf("Successfully migrated database schema to version %s.", color.Sprintf(fmt.Sprint(target), color.Success))
+		fmt.Println(msg)
+		return
+	},
+}
+
+var repairDBCmd = &cobra.Command{
+	Use:   "repair",
+	Short: "Remove partially written blocks",
+	Long: `Remove blocks whose signature cannot be verified with the transactions
+that are stored for them, together with all of their descendants, so that
+they can be obtained again from other peers. The server repairs the database
+when it starts as well, this command repairs it without starting a node.`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		repo := blockchain.ConnectRepo()
+		defer repo.DB.Close()
+
+		err = repo.MigrateToLatest()
+		if err != nil {
+			return fmt.Errorf("Failed to migrate the database. %s", err.Error())
+		}
+
+		err = repo.RepairPartialBlocks()
+		if err != nil {
+			return fmt.Errorf("Failed to repair the database. %s", err.Error())
+		}
+		return
+	},
+}
+
+var rollbackDBCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Remove all blocks above a height",
+	Long: `Remove all blocks above the given height, on all forks, and recompute the
+main chain head. Transactions of removed blocks are put back into the pending
+transactions of the node. Note that removed blocks may be synced again from
+other peers, use "peerbridge block invalidate" to prevent this.`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		if rollbackHeight < 0 {
+			return errors.New("The height must not be negative!")
+		}
+		height := uint64(rollbackHeight)
+
+		msg := fmt.Sprintf("Rolling back the chain to height %s.", color.Sprintf(fmt.Sprint(height), color.Notice))
+		fmt.Println(msg)
+
+		result, err := runRollback(
+			"/blockchain/admin/rollback",
+			blockchain.RollbackRequest{Height: &height},
+			func() (*blockchain.RollbackResult, error) {
+				return blockchain.Instance.RollbackToHeight(height)
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("Failed to roll back the chain. %s", err.Error())
+		}
+
+		printRollbackResult(result)
+		return
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(migrateDBCmd)
+	dbCmd.AddCommand(repairDBCmd)
+	dbCmd.AddCommand(rollbackDBCmd)
+	addAdminFlags(rollbackDBCmd)
+	rollbackDBCmd.Flags().Int64Var(&rollbackHeight, "height", -1, "height of the last block to keep")
+	rollbackDBCmd.MarkFlagRequired("height")
+	migrateDBCmd.Flags().IntVar(&migrationTarget, "to", -1, "schema version to migrate to (default is the latest version)")
+}
@@ -26,6 +26,7 @@
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/peerbridge/peerbridge/pkg/blockchain"
 	"github.com/peerbridge/peerbridge/pkg/color"
@@ -60,17 +61,41 @@
 	},
 }
 
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "View the sync status of a node",
+	Long:  "Retrieve the sync state, the chain height and the sync progress of a node.",
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		status, err := GetStatus(host)
+		if err != nil {
+			return fmt.Errorf("Failed to request node status. %s", err.Error())
+		}
+
+		fmt.Printf("State: %s\n", color.Sprintf(string(status.State), color.Info))
+		fmt.Printf("Local height: %d\n", status.LocalHeight)
+		fmt.Printf("Best peer height: %d\n", status.BestPeerHeight)
+		fmt.Printf("Blocks per second: %.2f\n", status.BlocksPerSecond)
+		if status.ETASeconds != nil {
+			eta := time.Duration(*status.ETASeconds * float64(time.Second))
+			fmt.Printf("ETA: %s\n", eta.Round(time.Second))
+		}
+
+		return
+	},
+}
+
 func init() {
 	rootCmd.AddCommand(nodeCmd)
 	nodeCmd.AddCommand(balanceCmd)
+	nodeCmd.AddCommand(statusCmd)
 
-	nodeCmd.PersistentFlags().StringVar(&key, "key", "", "secp256k1 key of the account")
+	balanceCmd.Flags().StringVar(&key, "key", "", "secp256k1 key of the account")
 	nodeCmd.PersistentFlags().StringVar(&host, "host", "https://peerbridge.herokuapp.com", "blockchain node to connect to")
 
-	viper.BindPFlag("key", nodeCmd.PersistentFlags().Lookup("key"))
+	viper.BindPFlag("key", balanceCmd.Flags().Lookup("key"))
 	viper.BindPFlag("host", nodeCmd.PersistentFlags().Lookup("host"))
 
-	nodeCmd.MarkPersistentFlagRequired("key")
+	balanceCmd.MarkFlagRequired("key")
 }
 
 // TODO: move into pkg
@@ -106,3 +131,27 @@ func GetBalance(host, key string) (b int64, err error) {
 
 	return *p.Balance, nil
 }
+
+// TODO: move into pkg
+func GetStatus(host string) (*blockchain.SyncStatus, error) {
+	res, err := http.Get(fmt.Sprintf("%s/node/status", host))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Node responded with %s", res.Status)
+	}
+
+	var p blockchain.GetNodeStatusResponse
+	err = json.NewDecoder(res.Body).Decode(&p)
+	if err != nil {
+		return nil, err
+	}
+	if p.Status == nil {
+		return nil, fmt.Errorf("Invalid response format")
+	}
+
+	return p.Status, nil
+}
@@ -0,0 +1,308 @@
+/*
+Copyright © 2021 PeerBridge
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/peerbridge/peerbridge/pkg/color"
+	"github.com/peerbridge/peerbridge/pkg/peer"
+	"github.com/spf13/cobra"
+)
+
+var peerCmd = &cobra.Command{
+	Use:   "peer",
+	Short: "View and manage the peers of a node",
+	Long:  "Display details about the peers of a node inside the PeerBridge blockchain.",
+}
+
+var peerScoresCmd = &cobra.Command{
+	Use:   "scores",
+	Short: "View the reputation of the peers of a node",
+	Long:  "Retrieve the scores of the peers of a node. Peers which send invalid data are banned temporarily.",
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		scores, err := GetPeerScores(host)
+		if err != nil {
+			return fmt.Errorf("Failed to request peer scores. %s", err.Error())
+		}
+
+		if len(*scores) == 0 {
+			fmt.Println("No peers were scored yet.")
+			return
+		}
+		for _, score := range *scores {
+			line := fmt.Sprintf("%s score %d", color.Sprintf(score.ID, color.Notice), score.Score)
+			if score.BannedUntil != nil {
+				until := score.BannedUntil.Format(time.RFC3339)
+				line += fmt.Sprintf(" (%s)", color.Sprintf(fmt.Sprintf("banned until %s", until), color.Error))
+			}
+			fmt.Println(line)
+		}
+
+		return
+	},
+}
+
+var peerListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the connected peers of a node",
+	Long:  "Retrieve the connected peers of a node with their addresses, latency, handshake and traffic.",
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		peers, err := GetPeers(host)
+		if err != nil {
+			return fmt.Errorf("Failed to request peers. %s", err.Error())
+		}
+
+		if len(*peers) == 0 {
+			fmt.Println("No peers are connected.")
+			return
+		}
+		for _, p := range *peers {
+			protocol := p.Protocol
+			if protocol == "" {
+				protocol = "not bound"
+			}
+			fmt.Printf("%s (%s, %s)\n", color.Sprintf(p.ID, color.Notice), p.Direction, protocol)
+			fmt.Printf("  Addresses: %s\n", strings.Join(p.Addrs, ", "))
+			fmt.Printf("  Latency: %.2fms\n", p.LatencyMilliseconds)
+			if p.Handshake != nil {
+				fmt.Printf(
+					"  Handshake: network %s, height %d, cumulative difficulty %d\n",
+					p.Handshake.NetworkID, p.Handshake.Height, p.Handshake.CumulativeDifficulty,
+				)
+			}
+			fmt.Printf("  Traffic: %d bytes sent, %d bytes received\n", p.BytesSent, p.BytesReceived)
+		}
+
+		return
+	},
+}
+
+var peerHealthCmd = &cobra.Command{
+	Use:   "health",
+	Short: "Show the connectivity health of a node",
+	Long:  "Retrieve the connectivity health of a node, i.e. its number of bound and reconnecting peers.",
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		health, err := GetPeerHealth(host)
+		if err != nil {
+			return fmt.Errorf("Failed to request the connectivity health. %s", err.Error())
+		}
+
+		c := color.Success
+		switch health.State {
+		case peer.ConnectivityIsolated:
+			c = color.Error
+		case peer.ConnectivityDegraded:
+			c = color.Warning
+		}
+		fmt.Printf("State: %s\n", color.Sprintf(health.State, c))
+		fmt.Printf("Peers: %d of %d\n", health.Peers, health.TargetPeers)
+		fmt.Printf("Reconnecting: %d\n", health.Reconnecting)
+		if health.LastDiscovery != nil {
+			fmt.Printf("Last discovery: %s\n", health.LastDiscovery.Format(time.RFC3339))
+		}
+		return
+	},
+}
+
+var peerConnectCmd = &cobra.Command{
+	Use:   "connect [multiaddress]",
+	Short: "Connect a node to a peer",
+	Long:  "Connect a node to the peer under the given multiaddress, which has to include the peer id.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		adminHost = host
+		var response peer.ManagePeerResponse
+		address := args[0]
+		err = postAdminRequest("/peer/connect", peer.ConnectPeerRequest{Address: &address}, &response)
+		if err != nil {
+			return fmt.Errorf("Failed to connect to the peer. %s", err.Error())
+		}
+
+		fmt.Printf("Connected to %s.\n", color.Sprintf(response.ID, color.Success))
+		return
+	},
+}
+
+var peerDisconnectCmd = &cobra.Command{
+	Use:   "disconnect [peer id]",
+	Short: "Disconnect a node from a peer",
+	Long:  "Disconnect a node from the peer with the given id. The peer may connect again when it is discovered.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		adminHost = host
+		var response peer.ManagePeerResponse
+		id := args[0]
+		err = postAdminRequest("/peer/disconnect", peer.DisconnectPeerRequest{ID: &id}, &response)
+		if err != nil {
+			return fmt.Errorf("Failed to disconnect from the peer. %s", err.Error())
+		}
+
+		fmt.Printf("Disconnected from %s.\n", color.Sprintf(response.ID, color.Warning))
+		return
+	},
+}
+
+var peerIdentityCmd = &cobra.Command{
+	Use:   "identity",
+	Short: "Show the p2p identity of the local node",
+	Long: `Show the peer id of the libp2p key file of the local node.
+The key file is created when the server is started for the first
+time, or with "peerbridge peer identity rotate".`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		identity, err := peer.ReadIdentity(p2pKey)
+		if err == peer.ErrIdentityNotFound {
+			return fmt.Errorf("No p2p key exists in %s yet. Start the server or rotate the identity to create one.", p2pKey)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to load the p2p key from %s. %s", p2pKey, err.Error())
+		}
+		id, err := peer.IdentityID(identity)
+		if err != nil {
+			return
+		}
+
+		fmt.Printf("Peer ID: %s\n", color.Sprintf(id, color.Notice))
+		fmt.Printf("Key file: %s\n", p2pKey)
+		return
+	},
+}
+
+var peerIdentityRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace the p2p identity of the local node",
+	Long: `Replace the libp2p key file of the local node with a new key.
+The node uses the new peer id after it was restarted. Bootstrap and
+static peer lists of other nodes have to be updated with the new id.`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		previous := ""
+		if identity, err := peer.ReadIdentity(p2pKey); err == nil {
+			previous, _ = peer.IdentityID(identity)
+		}
+
+		identity, err := peer.RotateIdentity(p2pKey)
+		if err != nil {
+			return fmt.Errorf("Failed to rotate the p2p key in %s. %s", p2pKey, err.Error())
+		}
+		id, err := peer.IdentityID(identity)
+		if err != nil {
+			return
+		}
+
+		if previous != "" {
+			fmt.Printf("Previous peer ID: %s\n", color.Sprintf(previous, color.Warning))
+		}
+		fmt.Printf("New peer ID: %s\n", color.Sprintf(id, color.Success))
+		fmt.Println("Restart the node to use the new identity.")
+		return
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(peerCmd)
+	peerCmd.AddCommand(peerScoresCmd)
+	peerCmd.AddCommand(peerListCmd)
+	peerCmd.AddCommand(peerHealthCmd)
+	peerCmd.AddCommand(peerConnectCmd)
+	peerCmd.AddCommand(peerDisconnectCmd)
+	peerCmd.AddCommand(peerIdentityCmd)
+	peerIdentityCmd.AddCommand(peerIdentityRotateCmd)
+
+	peerIdentityCmd.PersistentFlags().StringVar(&p2pKey, "p2p-key", peer.GetIdentityPath(), "file in which the libp2p private key of the node is stored (default is $DATA_DIR/p2p.key)")
+
+	for _, cmd := range []*cobra.Command{peerConnectCmd, peerDisconnectCmd} {
+		cmd.Flags().StringVar(&adminToken, "token", os.Getenv("ADMIN_TOKEN"), "admin token of the node (default is $ADMIN_TOKEN)")
+	}
+
+	peerCmd.PersistentFlags().StringVar(&host, "host", "https://peerbridge.herokuapp.com", "blockchain node to connect to")
+}
+
+func GetPeers(host string) (*[]peer.PeerInfo, error) {
+	res, err := http.Get(fmt.Sprintf("%s/peer/list", host))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Node responded with %s", res.Status)
+	}
+
+	var p peer.GetPeerListResponse
+	err = json.NewDecoder(res.Body).Decode(&p)
+	if err != nil {
+		return nil, err
+	}
+	if p.Peers == nil {
+		return nil, fmt.Errorf("Invalid response format")
+	}
+
+	return p.Peers, nil
+}
+
+func GetPeerHealth(host string) (*peer.ConnectivityHealth, error) {
+	res, err := http.Get(fmt.Sprintf("%s/peer/health", host))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Node responded with %s", res.Status)
+	}
+
+	var h peer.ConnectivityHealth
+	err = json.NewDecoder(res.Body).Decode(&h)
+	if err != nil {
+		return nil, err
+	}
+
+	return &h, nil
+}
+
+func GetPeerScores(host string) (*[]peer.PeerScore, error) {
+	res, err := http.Get(fmt.Sprintf("%s/peer/scores", host))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Node responded with %s", res.Status)
+	}
+
+	var p peer.GetPeerScoresResponse
+	err = json.NewDecoder(res.Body).Decode(&p)
+	if err != nil {
+		return nil, err
+	}
+	if p.Scores == nil {
+		return nil, fmt.Errorf("Invalid response format")
+	}
+
+	return p.Scores, nil
+}
@@ -22,15 +22,20 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/peerbridge/peerbridge/pkg/blockchain"
 	"github.com/peerbridge/peerbridge/pkg/color"
 	"github.com/peerbridge/peerbridge/pkg/dashboard"
 	"github.com/peerbridge/peerbridge/pkg/encryption/secp256k1"
 	. "github.com/peerbridge/peerbridge/pkg/http"
+	"github.com/peerbridge/peerbridge/pkg/lifecycle"
 	"github.com/peerbridge/peerbridge/pkg/peer"
 	"github.com/peerbridge/peerbridge/pkg/staticfiles"
 
@@ -39,6 +44,14 @@
 )
 
 var sync bool
+var remotes []string
+var verify bool
+var pruneDepth uint64
+var bootstrapPeers []string
+var staticPeers []string
+var mdns bool
+var p2pKey string
+var targetPeers int
 
 // serverCmd represents the server command
 var serverCmd = &cobra.Command{
@@ -51,32 +64,69 @@
 			return
 		}
 
-		remote := ""
-		if sync {
-			remote = host
+		// Keep the same peer id across restarts
+		identity, err := peer.LoadIdentity(p2pKey)
+		if err != nil {
+			return fmt.Errorf("Failed to load the p2p key from %s. %s", p2pKey, err.Error())
+		}
+		id, err := peer.IdentityID(identity)
+		if err != nil {
+			return
+		}
+		peer.Service.SetIdentity(identity)
+		log.Printf("Using p2p identity %s from %s\n", color.Sprintf(id, color.Notice), p2pKey)
+
+		// Bootstrap from the given multiaddresses, or from
+		// the peer urls of the sync host, if none are given
+		bootstrap := peer.BootstrapConfig{}
+		bootstrap.Peers, err = peer.ParsePeerAddrs(viper.GetStringSlice("bootstrap"))
+		if err != nil {
+			return fmt.Errorf("Invalid bootstrap peer. %s", err.Error())
+		}
+		bootstrap.StaticPeers, err = peer.ParsePeerAddrs(viper.GetStringSlice("static-peers"))
+		if err != nil {
+			return fmt.Errorf("Invalid static peer. %s", err.Error())
+		}
+		if sync && len(bootstrap.Peers) == 0 {
+			bootstrap.Host = host
 		}
+		bootstrap.MDNS = mdns
+		bootstrap.TargetPeers = targetPeers
 
 		// Create a http router and start serving http requests
 		router := NewRouter()
 		router.Use(Header, Logger)
 
-		// Create and run a peer to peer service
-		go peer.Service.Run(remote)
-		// Bind the peer routes to the main http router
-		router.Mount("/peer", peer.Routes())
-
 		// Initiate the blockchain and peer to peer service
 		blockchain.InitRepo()
 		blockchain.InitChain(kpair)
-		blockchain.Instance.Sync(remote)
-		go blockchain.ReactToPeerMessages()
-		go blockchain.Instance.RunContinuousMinting()
+		if err = blockchain.Instance.EnablePruning(pruneDepth); err != nil {
+			return
+		}
+		if verify {
+			log.Println("Verifying the stored main chain...")
+			if err = verifyChain(); err != nil {
+				return
+			}
+		}
+
+		// Sync the chain against the given remotes
+		syncRemotes := []blockchain.Remote{}
+		if sync {
+			syncRemotes = append(syncRemotes, blockchain.NewHTTPRemote(host))
+		}
+		for _, url := range viper.GetStringSlice("remote") {
+			syncRemotes = append(syncRemotes, blockchain.NewHTTPRemote(url))
+		}
+		blockchain.InitSync(syncRemotes...)
+		blockchain.ReactToPeerMessages()
+
+		// Bind the peer routes to the main http router
+		router.Mount("/peer", peer.Routes())
 		// Bind the blockchain routes to the main http router
 		router.Mount("/blockchain", blockchain.Routes())
-
-		// Run the dashboard websocket client hub
-		go dashboard.RunHub()
-		go dashboard.ReactToPeerMessages()
+		// Bind the node routes to the main http router
+		router.Mount("/node", blockchain.NodeRoutes())
 		// Bind the dashboard routes to the main http router
 		router.Mount("/dashboard", dashboard.Routes())
 
@@ -88,9 +138,68 @@
 			http.Redirect(w, r, "/dashboard", 301)
 		})
 
-		// Finish initiation and listen for requests
+		// Start the services in order, they are stopped in reverse order
+		node := lifecycle.New()
+		node.Start(
+			// Run the dashboard websocket client hub, which
+			// receives messages until the peer is stopped
+			lifecycle.Func("dashboard", func(ctx context.Context) {
+				dashboard.ReactToPeerMessages(ctx)
+				dashboard.RunHub(ctx)
+			}),
+			// Create and run a peer to peer service, after the
+			// blockchain is ready to exchange handshakes
+			lifecycle.Func("p2p", func(ctx context.Context) {
+				peer.Service.Run(ctx, bootstrap)
+			}),
+			// Sync in the background, so that the node status
+			// can be requested while the initial sync runs.
+			// Minting stays disabled until the initial sync is done.
+			lifecycle.Func("sync", func(ctx context.Context) {
+				if len(syncRemotes) > 0 {
+					log.Println("Syncing the blockchain...")
+				}
+				n, err := blockchain.Syncer.Sync(ctx)
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil && err != blockchain.ErrNoRemotes {
+					log.Println(color.Sprintf(fmt.Sprintf("Sync failed: %s", err), color.Warning))
+				}
+				if len(syncRemotes) > 0 {
+					log.Printf("Synced %s block(s)\n", color.Sprintf(fmt.Sprintf("%d", n), color.Info))
+				}
+				blockchain.Syncer.RunContinuousSync(ctx)
+			}),
+			lifecycle.Func("chain tips requests", blockchain.RunContinuousChainTipsRequests),
+			lifecycle.Func("minting", blockchain.Instance.RunContinuousMinting),
+			lifecycle.Func("pruning", blockchain.Instance.RunContinuousPruning),
+			lifecycle.Func("orphan expiry", blockchain.Instance.Orphans.RunContinuousExpiry),
+			// Finish initiation and listen for requests,
+			// in-flight requests are drained on shutdown
+			lifecycle.Service{Name: "http server", Run: router.ListenAndServeContext},
+		)
 		log.Println(fmt.Sprintf("Started http server listening on: %s", color.Sprintf(GetServerPort(), color.Info)))
-		log.Fatal(router.ListenAndServe())
+
+		// Stop the node gracefully on SIGINT and SIGTERM,
+		// e.g. when the container is stopped
+		ctx, cancel := context.WithCancel(context.Background())
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			s := <-signals
+			log.Printf("Received %s, shutting down...\n", s)
+			cancel()
+		}()
+
+		err = node.Run(ctx)
+		if err == lifecycle.ErrStopTimeout {
+			// The service which is still running may use the database
+			log.Println(color.Sprintf("Left the database open, since a service is still running.", color.Warning))
+		} else if closeErr := blockchain.Repo.DB.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		log.Println(color.Sprintf("Stopped the node.", color.Info))
 
 		return
 	},
@@ -102,10 +211,22 @@ func init() {
 	serverCmd.PersistentFlags().StringVar(&key, "key", "", "secp256k1 key of the account")
 	serverCmd.PersistentFlags().StringVar(&host, "host", "https://peerbridge.herokuapp.com", "blockchain node to connect to")
 
+	serverCmd.Flags().Uint64Var(&pruneDepth, "prune-depth", 0, "prune transaction data of blocks this deep below the head (default is 0, which keeps all data)")
+	serverCmd.Flags().BoolVar(&verify, "verify", false, "verify the stored main chain before the server is started")
 	serverCmd.Flags().BoolVar(&sync, "sync", false, "sync the server against the specified host (default is https://peerbridge.herokuapp.com)")
+	serverCmd.Flags().StringSliceVar(&remotes, "remote", []string{}, "additional blockchain nodes to sync against (can be given multiple times)")
 
 	viper.BindPFlag("key", serverCmd.PersistentFlags().Lookup("key"))
 	viper.BindPFlag("host", serverCmd.PersistentFlags().Lookup("host"))
+	serverCmd.Flags().StringSliceVar(&bootstrapPeers, "bootstrap", []string{}, "multiaddresses of peers to bootstrap from, e.g. /ip4/1.2.3.4/tcp/9080/p2p/Qm... (can be given multiple times)")
+	serverCmd.Flags().StringSliceVar(&staticPeers, "static-peers", []string{}, "multiaddresses of peers which are always kept connected (can be given multiple times)")
+	serverCmd.Flags().StringVar(&p2pKey, "p2p-key", peer.GetIdentityPath(), "file in which the libp2p private key of the node is stored (default is $DATA_DIR/p2p.key)")
+	serverCmd.Flags().IntVar(&targetPeers, "target-peers", peer.DefaultTargetPeers, "number of peers to keep connected")
+	serverCmd.Flags().BoolVar(&mdns, "mdns", false, "discover peers in the local network via mDNS")
+
+	viper.BindPFlag("remote", serverCmd.Flags().Lookup("remote"))
+	viper.BindPFlag("bootstrap", serverCmd.Flags().Lookup("bootstrap"))
+	viper.BindPFlag("static-peers", serverCmd.Flags().Lookup("static-peers"))
 
 	serverCmd.MarkPersistentFlagRequired("key")
 }
@@ -121,6 +121,7 @@ func createTransaction(host string, amount uint64, kpair *secp256k1.KeyPair, rec
 		TimeUnixNano: time.Now().UnixNano(),
 		Data:         nil,
 		Fee:          0,
+		Version:      blockchain.TransactionVersion,
 		Signature:    nil, // part of signing
 	}
 
@@ -1,8 +1,6 @@
 package blockchain
 
 import (
-	"fmt"
-
 	"github.com/peerbridge/peerbridge/pkg/encryption"
 	"github.com/peerbridge/peerbridge/pkg/encryption/secp256k1"
 )
@@ -57,29 +55,71 @@ func (b *Block) GetSender() secp256k1.PublicKeyHexString {
 	return b.Creator
 }
 
-func (b *Block) GetSignString() string {
-	txn := ""
+// Check that the identifiers, keys and signatures of the block
+// and its transactions are well-formed hex strings. This does
+// not verify the signatures.
+func (b *Block) CheckFormat() error {
+	if !isHexOfLength(b.ID, encryption.SHA256ByteLength) {
+		return ErrMalformedBlock
+	}
+	if b.ParentID != nil && !isHexOfLength(*b.ParentID, encryption.SHA256ByteLength) {
+		return ErrMalformedBlock
+	}
+	if !isHexOfLength(b.Creator, secp256k1.PublicKeyByteLength) {
+		return ErrMalformedBlock
+	}
+	if !isHexOfLength(b.Challenge, encryption.SHA256ByteLength) {
+		return ErrMalformedBlock
+	}
+	if b.Signature == nil || !isHexOfLength(*b.Signature, secp256k1.SignatureByteLength) {
+		return ErrMalformedBlock
+	}
 	for _, t := range b.Transactions {
-		txn += t.GetSignString()
+		if err := t.CheckFormat(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check if the data of any transaction of this block was pruned.
+// The signatures of a pruned block are verified with the data
+// hashes of its transactions, see `VerifySignatures`.
+func (b *Block) IsPruned() bool {
+	for _, t := range b.Transactions {
+		if t.Pruned {
+			return true
+		}
 	}
+	return false
+}
 
-	parent := ""
-	if b.ParentID != nil {
-		parent = *b.ParentID
+// Verify the signatures of the block and its transactions.
+// This works for pruned blocks as well, since only prunable
+// transactions are pruned.
+func (b *Block) VerifySignatures() error {
+	if b.Signature == nil {
+		return ErrMissingSignature
+	}
+	for _, t := range b.Transactions {
+		if err := t.VerifySignature(); err != nil {
+			return err
+		}
 	}
+	return secp256k1.VerifySignature(b, *b.Signature)
+}
+
+func (b *Block) GetSignString() string {
+	h := b.Header()
+	return h.GetSignString()
+}
 
-	str := fmt.Sprintf(
-		"id:%s|parentID:%s|height:%d|timeUnixNano:%d|transactions:%s|creator:%s|target:%d|challenge:%s|cumulativeDifficulty:%d",
-		b.ID,
-		parent,
-		b.Height,
-		b.TimeUnixNano,
-		txn,
-		b.Creator,
-		b.Target,
-		b.Challenge,
-		b.CumulativeDifficulty,
-	)
-
-	return str
+// Get the concatenated sign strings of the block transactions,
+// which are covered by the block signature.
+func (b *Block) transactionsSignString() string {
+	txn := ""
+	for _, t := range b.Transactions {
+		txn += t.GetSignString()
+	}
+	return txn
 }
@@ -0,0 +1,301 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/peerbridge/peerbridge/pkg/color"
+	"github.com/peerbridge/peerbridge/pkg/encryption"
+)
+
+// The bootstrap file format is used to transfer a range of blocks
+// between nodes without the network, e.g. to ship them alongside
+// a release. A bootstrap file is laid out as follows:
+//
+//	magic (4 bytes) | version (1 byte) | gzip compressed frames
+//
+// Every frame consists of a 4 byte big endian length, followed by
+// a JSON encoded payload of that length. The first frame holds the
+// `BootstrapHeader`, followed by one frame per block in ascending
+// height. A frame with length 0 marks the end of the file, so that
+// truncated files can be detected.
+const (
+	// The current version of the bootstrap file format.
+	BootstrapFormatVersion byte = 1
+
+	// The maximum size of a single frame inside a bootstrap file.
+	MaxBootstrapFrameSize = 64 << 20
+
+	// The number of blocks that are loaded at once during an export.
+	bootstrapExportBatchSize = 100
+)
+
+// The magic bytes at the start of every bootstrap file.
+var BootstrapMagic = [4]byte{'P', 'B', 'B', 'S'}
+
+var (
+	ErrInvalidBootstrapMagic    = errors.New("Not a bootstrap file!")
+	ErrInvalidBootstrapVersion  = errors.New("Unsupported bootstrap file version!")
+	ErrBootstrapFrameTooLarge   = errors.New("Bootstrap frame exceeds the maximum size!")
+	ErrBootstrapTruncated       = errors.New("Bootstrap file is truncated!")
+	ErrBootstrapGenesisMismatch = errors.New("Bootstrap file belongs to a different genesis block!")
+)
+
+// The header of a bootstrap file.
+type BootstrapHeader struct {
+	// The id of the genesis block of the exported chain.
+	GenesisID encryption.SHA256HexString `json:"genesisID"`
+
+	// The height of the first exported block.
+	FromHeight uint64 `json:"fromHeight"`
+
+	// The height of the last exported block.
+	ToHeight uint64 `json:"toHeight"`
+
+	// The time of the export.
+	TimeUnixNano int64 `json:"timeUnixNano"`
+}
+
+// A writer for the bootstrap file format.
+type BootstrapWriter struct {
+	gzip   *gzip.Writer
+	buffer *bufio.Writer
+}
+
+// Create a new bootstrap writer and write the file header.
+// Call `Close` after all blocks were written.
+func NewBootstrapWriter(w io.Writer, header BootstrapHeader) (*BootstrapWriter, error) {
+	if _, err := w.Write(BootstrapMagic[:]); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write([]byte{BootstrapFormatVersion}); err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(w)
+	bw := &BootstrapWriter{gzip: gz, buffer: bufio.NewWriter(gz)}
+	if err := bw.writeFrame(header); err != nil {
+		return nil, err
+	}
+	return bw, nil
+}
+
+func (bw *BootstrapWriter) writeFrame(object interface{}) error {
+	payload, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	if len(payload) > MaxBootstrapFrameSize {
+		return ErrBootstrapFrameTooLarge
+	}
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(payload)))
+	if _, err := bw.buffer.Write(length[:]); err != nil {
+		return err
+	}
+	_, err = bw.buffer.Write(payload)
+	return err
+}
+
+// Write a block to the bootstrap file.
+func (bw *BootstrapWriter) WriteBlock(b *Block) error {
+	return bw.writeFrame(b)
+}
+
+// Write the end marker and flush all buffered data.
+// This does not close the underlying writer.
+func (bw *BootstrapWriter) Close() error {
+	if _, err := bw.buffer.Write([]byte{0, 0, 0, 0}); err != nil {
+		return err
+	}
+	if err := bw.buffer.Flush(); err != nil {
+		return err
+	}
+	return bw.gzip.Close()
+}
+
+// A reader for the bootstrap file format.
+type BootstrapReader struct {
+	// The header of the bootstrap file.
+	Header BootstrapHeader
+
+	reader *bufio.Reader
+}
+
+// Create a new bootstrap reader and read the file header.
+func NewBootstrapReader(r io.Reader) (*BootstrapReader, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, ErrInvalidBootstrapMagic
+	}
+	if !bytes.Equal(prefix[:4], BootstrapMagic[:]) {
+		return nil, ErrInvalidBootstrapMagic
+	}
+	if prefix[4] != BootstrapFormatVersion {
+		return nil, ErrInvalidBootstrapVersion
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	br := &BootstrapReader{reader: bufio.NewReader(gz)}
+	payload, err := br.readFrame()
+	if err == io.EOF {
+		return nil, ErrBootstrapTruncated
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(payload, &br.Header); err != nil {
+		return nil, err
+	}
+	return br, nil
+}
+
+// Read the next frame payload. Returns `io.EOF` on the end marker.
+func (br *BootstrapReader) readFrame() ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(br.reader, length[:]); err != nil {
+		return nil, ErrBootstrapTruncated
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n == 0 {
+		return nil, io.EOF
+	}
+	if n > MaxBootstrapFrameSize {
+		return nil, ErrBootstrapFrameTooLarge
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(br.reader, payload); err != nil {
+		return nil, ErrBootstrapTruncated
+	}
+	return payload, nil
+}
+
+// Read the next block from the bootstrap file.
+// Returns `io.EOF` after the last block.
+func (br *BootstrapReader) ReadBlock() (*Block, error) {
+	payload, err := br.readFrame()
+	if err != nil {
+		return nil, err
+	}
+	var b Block
+	if err := json.Unmarshal(payload, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// Export the main chain blocks between the given heights (inclusive)
+// into a bootstrap file. Returns the number of exported blocks.
+func (r *BlockRepo) ExportMainChain(w io.Writer, from, to uint64) (int, error) {
+	bw, err := NewBootstrapWriter(w, BootstrapHeader{
+		GenesisID:    GenesisBlock.ID,
+		FromHeight:   from,
+		ToHeight:     to,
+		TimeUnixNano: time.Now().UnixNano(),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Walk the main chain once and load the blocks in batches
+	ids, err := r.GetMainChainIDsInRange(from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	exported := 0
+	for start := 0; start < len(ids); start += bootstrapExportBatchSize {
+		end := start + bootstrapExportBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		blocks, err := r.GetBlocksByIDs(ids[start:end])
+		if err != nil {
+			return exported, err
+		}
+		for _, b := range *blocks {
+			if b.IsPruned() {
+				return exported, fmt.Errorf("Block %s at height %d: %s", b.ID, b.Height, ErrBlockPruned)
+			}
+			if err := bw.WriteBlock(&b); err != nil {
+				return exported, err
+			}
+			exported++
+		}
+	}
+
+	return exported, bw.Close()
+}
+
+// The result of a bootstrap file import.
+type ImportResult struct {
+	// The number of blocks that were added to the chain.
+	Imported int `json:"imported"`
+
+	// The number of blocks that were already in the chain.
+	Skipped int `json:"skipped"`
+}
+
+// Import all blocks of a bootstrap file into the chain.
+// Every block is validated in the same way as blocks obtained
+// from other peers, see `MigrateBlock`. The import stops at
+// the first block that could not be added.
+func (chain *Blockchain) ImportChain(r io.Reader) (*ImportResult, error) {
+	br, err := NewBootstrapReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if br.Header.GenesisID != GenesisBlock.ID {
+		return nil, ErrBootstrapGenesisMismatch
+	}
+
+	result := &ImportResult{}
+	for {
+		b, err := br.ReadBlock()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+
+		if Repo.ContainsBlockByID(b.ID) {
+			result.Skipped++
+			continue
+		}
+		if b.ParentID == nil {
+			return result, fmt.Errorf("Block %s has no parent!", b.ID)
+		}
+
+		var migrateErr error
+		chain.ThreadSafe(func() {
+			migrateErr = chain.MigrateBlockFrom(b, orphanSourceImport, true)
+		})
+		if migrateErr != nil {
+			return result, fmt.Errorf(
+				"Block %s at height %d could not be imported: %w", b.ID, b.Height, migrateErr,
+			)
+		}
+
+		if !Repo.ContainsBlockByID(b.ID) {
+			// Valid blocks are only kept back if their parent is missing
+			return result, fmt.Errorf(
+				"Block %s at height %d could not be imported, its parent is missing!", b.ID, b.Height,
+			)
+		}
+		result.Imported++
+
+		if result.Imported%1000 == 0 {
+			log.Println(color.Sprintf(fmt.Sprintf("Imported %d block(s)...", result.Imported), color.Info))
+		}
+	}
+}
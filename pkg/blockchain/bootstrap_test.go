@@ -0,0 +1,75 @@
+package blockchain
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestBootstrapRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	header := BootstrapHeader{GenesisID: GenesisBlock.ID, FromHeight: 0, ToHeight: 1}
+
+	bw, err := NewBootstrapWriter(&buf, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bw.WriteBlock(GenesisBlock); err != nil {
+		t.Fatal(err)
+	}
+	if err := bw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	br, err := NewBootstrapReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if br.Header != header {
+		t.Errorf("Expected header %+v, got %+v", header, br.Header)
+	}
+
+	b, err := br.ReadBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.GetSignString() != GenesisBlock.GetSignString() {
+		t.Errorf("Expected the genesis block to be read back")
+	}
+
+	if _, err := br.ReadBlock(); err != io.EOF {
+		t.Errorf("Expected io.EOF after the last block, got %v", err)
+	}
+}
+
+func TestBootstrapTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	bw, err := NewBootstrapWriter(&buf, BootstrapHeader{GenesisID: GenesisBlock.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bw.WriteBlock(GenesisBlock); err != nil {
+		t.Fatal(err)
+	}
+	// Flush without writing the end marker
+	bw.buffer.Flush()
+	bw.gzip.Flush()
+
+	br, err := NewBootstrapReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := br.ReadBlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := br.ReadBlock(); err != ErrBootstrapTruncated {
+		t.Errorf("Expected a truncated file error, got %v", err)
+	}
+}
+
+func TestBootstrapInvalidMagic(t *testing.T) {
+	_, err := NewBootstrapReader(bytes.NewBufferString("NOPE\x01"))
+	if err != ErrInvalidBootstrapMagic {
+		t.Errorf("Expected an invalid magic error, got %v", err)
+	}
+}
@@ -1,21 +1,18 @@
 package blockchain
 
 import (
-	"bytes"
-	"crypto/sha256"
+	"context"
 	"encoding/binary"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"math/big"
-	"net/http"
 	"sort"
 	"sync"
 	"time"
 
+	"github.com/go-pg/pg/v10"
 	"github.com/peerbridge/peerbridge/pkg/color"
 	"github.com/peerbridge/peerbridge/pkg/encryption"
 	"github.com/peerbridge/peerbridge/pkg/encryption/secp256k1"
@@ -23,6 +20,9 @@
 
 const (
 	MaxTransactionsPerBlock = 512
+
+	// The reward that a block creator receives for every block.
+	BlockReward = 100
 )
 
 var (
@@ -32,22 +32,45 @@
 	ErrChildrenNotFound          = errors.New("Children not found!")
 	ErrParentBlockNotFound       = errors.New("Parent block not found!")
 	ErrAccountHasNoStake         = errors.New("Account has no stake!")
+	ErrBlockInvalidated          = errors.New("Block was invalidated!")
+	ErrGenesisBlockImmutable     = errors.New("The genesis block cannot be removed!")
+	ErrTransactionPruned         = errors.New("Transaction data was pruned!")
+	ErrUnknownTransactionVersion = errors.New("Unknown transaction version!")
+	ErrMissingSignature          = errors.New("Signature is missing!")
+	ErrMissingParentID           = errors.New("Parent id is missing!")
+	ErrMalformedBlock            = errors.New("Block is malformed!")
+	ErrMalformedTransaction      = errors.New("Transaction is malformed!")
 )
 
+// An error of the repository, which occurred while a block was
+// validated or inserted. Such errors don't depend on the block,
+// so the peer from which the block was received is not at fault.
+type storageError struct {
+	reason error
+}
+
+func (e storageError) Error() string {
+	return e.reason.Error()
+}
+
 type Blockchain struct {
 	// The currently pending transactions that were
 	// sent to the node (by clients or other nodes)
 	// and not yet included in the blockchain.
 	PendingTransactions *[]Transaction
 
-	// The blocks that were received or generated
-	// but are not yet integrated into the head.
-	PendingBlocks *[]Block
+	// The blocks that were received but could not be
+	// inserted yet, because their parent is unknown.
+	Orphans *OrphanPool
 
 	// The account key pair to access the blockchain.
 	// This key pair is used to sign blocks and transactions.
 	keyPair *secp256k1.KeyPair
 
+	// The depth below the main chain head from which
+	// transaction data is pruned, or 0 if pruning is disabled.
+	pruneDepth uint64
+
 	// A lock to ensure mutual exclusion on critical
 	// operations that cannot be done concurrently
 	// in a safe manner.
@@ -63,7 +86,7 @@ type Blockchain struct {
 func InitChain(keyPair *secp256k1.KeyPair) {
 	Instance = &Blockchain{
 		PendingTransactions: &[]Transaction{},
-		PendingBlocks:       &[]Block{},
+		Orphans:             NewOrphanPool(MaxOrphanBlocks, MaxOrphanBlocksPerSource, OrphanBlockExpiry),
 		keyPair:             keyPair,
 	}
 }
@@ -94,72 +117,14 @@ func (chain *Blockchain) RecommendedTransactionFee() int {
 	return fees[len(fees)-MaxTransactionsPerBlock] // Min(included)
 }
 
-func (chain *Blockchain) Sync(remote string) {
-	// TODO: Make this more robust
-	// - Handle remote failures, maybe use round robin
-	// - Handle chain switches (by fetching all endpoints)
-
-	log.Println("Starting sync...")
-
-	if remote == "" {
-		log.Println("No remote provided - sync finished!")
-		return
+func (chain *Blockchain) ValidateTransaction(t *Transaction) error {
+	if err := t.CheckFormat(); err != nil {
+		return err
 	}
-
-	for {
-		foundMoreChildren := false
-		endpoint, err := Repo.GetMainChainEndpoint()
-		if err != nil {
-			panic(err)
-		}
-		url := fmt.Sprintf("%s/blockchain/blocks/children/get?id=%s", remote, endpoint.ID)
-
-		body := bytes.NewBuffer([]byte{})
-		request, err := http.NewRequest("GET", url, body)
-		if err != nil {
-			panic(err)
-		}
-		request.Header.Set("Content-Type", "application/json")
-
-		client := &http.Client{}
-		response, err := client.Do(request)
-
-		if err != nil {
-			log.Println(color.Sprintf("Waiting for the remote to be reachable until continuing the sync process...", color.Warning))
-			time.Sleep(1 * time.Second)
-			continue
-		}
-
-		if response.StatusCode == 200 {
-			responseBody, err := ioutil.ReadAll(response.Body)
-			if err != nil {
-				panic(err)
-			}
-
-			var response GetChildrenResponse
-			err = json.Unmarshal(responseBody, &response)
-			if err != nil {
-				panic(err)
-			}
-
-			for _, child := range *response.Children {
-				foundMoreChildren = true
-				chain.MigrateBlock(&child, true)
-			}
-		}
-
-		response.Body.Close()
-
-		if !foundMoreChildren {
-			break
-		}
+	if t.Pruned {
+		return ErrTransactionPruned
 	}
-
-	log.Println("Sync finished!")
-}
-
-func (chain *Blockchain) ValidateTransaction(t *Transaction) error {
-	err := secp256k1.VerifySignature(t, *t.Signature)
+	err := t.VerifySignature()
 	if err != nil {
 		return err
 	}
@@ -169,6 +134,13 @@ func (chain *Blockchain) ValidateTransaction(t *Transaction) error {
 
 // Add a given transaction to the pending transactions.
 func (chain *Blockchain) AddPendingTransaction(t *Transaction) error {
+	return chain.AddPendingTransactionFrom(t, "")
+}
+
+// Add a given transaction, which was received from the given
+// peer, to the pending transactions. The transaction is announced
+// to all other peers.
+func (chain *Blockchain) AddPendingTransactionFrom(t *Transaction, source string) error {
 	if chain.ContainsPendingTransactionByID(t.ID) {
 		return ErrTransactionAlreadyPending
 	}
@@ -180,7 +152,7 @@ func (chain *Blockchain) AddPendingTransaction(t *Transaction) error {
 
 	*chain.PendingTransactions = append(*chain.PendingTransactions, *t)
 
-	go BroadcastNewTransaction(t)
+	go AnnounceNewTransaction(t, source)
 	return nil
 }
 
@@ -241,224 +213,253 @@ func (chain *Blockchain) ValidateBlock(b *Block) (*Proof, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = proof.Validate()
+	err = chain.validateBlockWithProof(b, proof)
 	if err != nil {
 		return nil, err
 	}
+	return proof, nil
+}
+
+// Validate a block with its calculated proof.
+func (chain *Blockchain) validateBlockWithProof(b *Block, proof *Proof) error {
+	err := proof.Validate()
+	if err != nil {
+		return err
+	}
+	if b.Signature == nil {
+		return ErrMissingSignature
+	}
 	err = secp256k1.VerifySignature(b, *b.Signature)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if len(b.Transactions) == 0 {
-		return nil, errors.New("No transactions in block!")
+		return errors.New("No transactions in block!")
 	}
 	for _, t := range b.Transactions {
 		err = chain.ValidateTransaction(&t)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 	// TODO: Check other parameters
-	return proof, nil
+	return nil
 }
 
-// Check if the blockchain has a pending block with the given id.
-func (chain *Blockchain) ContainsPendingBlockByID(id encryption.SHA256HexString) bool {
-	for _, pendingBlock := range *chain.PendingBlocks {
-		if pendingBlock.ID == id {
-			return true
-		}
-	}
-	return false
+// Migrate a block into the chain. The block is inserted, if it is
+// valid and its parent is known. Otherwise, it is kept in the orphan
+// pool until its parent arrives. Orphans that become insertable
+// are inserted as well.
+//
+// The block is accounted to this node in the orphan pool.
+//
+// Returns an error if the given block was dropped because it is invalid.
+func (chain *Blockchain) MigrateBlock(b *Block, syncmode bool) error {
+	return chain.MigrateBlockFrom(b, orphanSourceLocal, syncmode)
 }
 
-func (chain *Blockchain) MigrateBlock(b *Block, syncmode bool) {
-	// If the block is already pending, do nothing
-	if chain.ContainsPendingBlockByID(b.ID) {
-		return
+// Migrate a block into the chain, which was received from the given
+// source. The source is used to limit the orphan blocks per source.
+//
+// Returns an error if the given block was dropped because it is invalid.
+func (chain *Blockchain) MigrateBlockFrom(b *Block, source string, syncmode bool) error {
+	// Reject malformed blocks before they are queued
+	if err := b.CheckFormat(); err != nil {
+		log.Printf("Dropped block (reason: %s)\n", err)
+		return err
 	}
 
-	// Insert the block into the pending blocks
-	*chain.PendingBlocks = append([]Block{*b}, *chain.PendingBlocks...)
-
-	// Try to insert pending blocks until none is insertable anymore
-	for {
-		requeuedBlocks := []Block{}
-		invalidBlocks := []Block{}
-		insertedBlocks := []Block{}
-
-		for _, pendingB := range *chain.PendingBlocks {
-			// Skip blocks that are already in the chain
-			if Repo.ContainsBlockByID(pendingB.ID) {
-				log.Printf("Dropped block %s (reason: block already in chain, probably rebroadcasted)\n", pendingB.ID[:6])
-				invalidBlocks = append(invalidBlocks, pendingB)
-				continue
-			}
+	// If the block is already an orphan, do nothing
+	if chain.Orphans.Contains(b.ID) {
+		return nil
+	}
 
-			// Re-queue blocks that need their parent
-			if !Repo.ContainsBlockByID(*pendingB.ParentID) {
-				log.Printf("Requeued block %s (reason: needs parent)\n", pendingB.ID[:6])
-				requeuedBlocks = append(requeuedBlocks, pendingB)
-				continue
-			}
+	// Throw away blocks without parent, only
+	// the genesis block has no parent
+	if b.ParentID == nil {
+		log.Printf("Dropped block %s (reason: %s)\n", b.ID[:6], ErrMissingParentID)
+		return ErrMissingParentID
+	}
 
-			// Throw away invalid blocks
-			proof, err := chain.ValidateBlock(&pendingB)
-			if err != nil {
-				log.Printf("Dropped block %s (reason: %s)\n", pendingB.ID[:6], err)
-				invalidBlocks = append(invalidBlocks, pendingB)
-				continue
-			}
+	// Keep blocks that need their parent in the orphan pool
+	if !Repo.ContainsBlockByID(*b.ParentID) {
+		if Repo.IsBlockInvalidated(b.ID) {
+			log.Printf("Dropped block %s (reason: %s)\n", b.ID[:6], ErrBlockInvalidated)
+			return ErrBlockInvalidated
+		}
+		if err := chain.Orphans.Add(b, source); err != nil {
+			log.Printf("Dropped block %s (reason: %s)\n", b.ID[:6], err)
+			return err
+		}
+		log.Printf("Queued orphan block %s (reason: needs parent)\n", b.ID[:6])
 
-			// TODO: Check for duplicated transactions
+		// Request the parent, unless it was requested recently
+		if !syncmode && chain.Orphans.ShouldRequestParent(*b.ParentID) {
+			go RequestBlock(b.ParentID, source)
+		}
+		return nil
+	}
 
-			err = Repo.AddBlockIfNotExists(&pendingB)
-			if err != nil {
-				log.Printf("Dropped block %s (reason: insertion not possible)\n", pendingB.ID[:6])
-				invalidBlocks = append(invalidBlocks, pendingB)
-				continue
-			}
+	// The reason why the given block was dropped, if it was invalid
+	var blockErr error
 
-			log.Printf(
-				"New %s Block %s (Parent: %s) by %s took %sms staking %s (H %s, %s T, S: %s)\n",
-				color.Sprintf("valid", color.Success),
-				color.Sprintf(fmt.Sprintf("%s", pendingB.ID[:6]), color.Debug),
-				color.Sprintf(fmt.Sprintf("%s", (*pendingB.ParentID)[:6]), color.Debug),
-				color.Sprintf(fmt.Sprintf("%s", pendingB.Creator[:6]), color.Debug),
-				color.Sprintf(fmt.Sprintf("%d", proof.NanoSeconds/1_000_000), color.Debug),
-				color.Sprintf(fmt.Sprintf("%d", proof.Stake), color.Success),
-				color.Sprintf(fmt.Sprintf("%d", pendingB.Height), color.Info),
-				color.Sprintf(fmt.Sprintf("%d", len(pendingB.Transactions)), color.Info),
-				color.Sprintf(fmt.Sprintf("%s", (*pendingB.Signature)[:6]), color.Success),
-			)
-
-			if !syncmode {
-				go BroadcastNewBlock(&pendingB)
-			}
-			insertedBlocks = append(insertedBlocks, pendingB)
+	// Insert the block and all orphans that descend from it
+	queue := []Block{*b}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
 
-			// Remove pending transactions that were included
-			for _, t := range pendingB.Transactions {
-				chain.RemovePendingTransaction(&t)
-			}
+		// Only the given block is known to come from the source
+		origin := ""
+		if next.ID == b.ID {
+			origin = source
 		}
 
-		// Drop all blocks that are pending and
-		// transitive children of invalid blocks
-		for {
-			var indexToDrop *int
-		search:
-			for i, requeuedBlock := range requeuedBlocks {
-				for _, invalidBlock := range invalidBlocks {
-					if invalidBlock.ID == *requeuedBlock.ParentID {
-						indexToDrop = &i
-						break search
-					}
-				}
+		err := chain.insertBlock(&next, origin, syncmode)
+		if err != nil {
+			log.Printf("Dropped block %s (reason: %s)\n", next.ID[:6], err)
+			if next.ID == b.ID {
+				blockErr = err
 			}
-			if indexToDrop == nil {
-				break
+			// Drop all orphans that descend from the invalid block
+			if n := chain.Orphans.RemoveDescendants(next.ID); n > 0 {
+				log.Printf("Dropped %d orphan block(s) (reason: invalid ancestor)\n", n)
 			}
-			// Drop transitive child
-			requeuedBlocks = append(
-				requeuedBlocks[:*indexToDrop],
-				requeuedBlocks[*indexToDrop+1:]...,
-			)
+			continue
 		}
 
-		chain.PendingBlocks = &requeuedBlocks
+		queue = append(queue, chain.Orphans.TakeChildren(next.ID)...)
+	}
 
-		// If we didn't insert any more blocks, stop trying
-		if len(insertedBlocks) == 0 {
-			break
-		}
+	return blockErr
+}
+
+// Validate a block whose parent is known and insert it into the chain.
+// Blocks that are already in the chain are skipped. New blocks are
+// announced to all peers except the origin of the block.
+func (chain *Blockchain) insertBlock(b *Block, origin string, syncmode bool) error {
+	// Skip blocks that are already in the chain
+	if Repo.ContainsBlockByID(b.ID) {
+		log.Printf("Skipped block %s (reason: block already in chain, probably rebroadcasted)\n", b.ID[:6])
+		return nil
+	}
+
+	// Throw away blocks that were invalidated by an operator
+	if Repo.IsBlockInvalidated(b.ID) {
+		return ErrBlockInvalidated
+	}
+
+	// Throw away invalid blocks
+	proof, err := chain.ValidateBlock(b)
+	if err != nil {
+		return err
 	}
 
-	// Request all parents that are currently unknown
+	// TODO: Check for duplicated transactions
+
+	err = Repo.AddBlockIfNotExists(b)
+	if err != nil {
+		return storageError{err}
+	}
+
+	log.Printf(
+		"New %s Block %s (Parent: %s) by %s took %sms staking %s (H %s, %s T, S: %s)\n",
+		color.Sprintf("valid", color.Success),
+		color.Sprintf(fmt.Sprintf("%s", b.ID[:6]), color.Debug),
+		color.Sprintf(fmt.Sprintf("%s", (*b.ParentID)[:6]), color.Debug),
+		color.Sprintf(fmt.Sprintf("%s", b.Creator[:6]), color.Debug),
+		color.Sprintf(fmt.Sprintf("%d", proof.NanoSeconds/1_000_000), color.Debug),
+		color.Sprintf(fmt.Sprintf("%d", proof.Stake), color.Success),
+		color.Sprintf(fmt.Sprintf("%d", b.Height), color.Info),
+		color.Sprintf(fmt.Sprintf("%d", len(b.Transactions)), color.Info),
+		color.Sprintf(fmt.Sprintf("%s", (*b.Signature)[:6]), color.Success),
+	)
+
 	if !syncmode {
-		for _, block := range *chain.PendingBlocks {
-			if !chain.ContainsPendingBlockByID(*block.ParentID) {
-				go BroadcastResolveBlockRequest(block.ParentID)
-			}
-		}
+		go AnnounceNewBlock(b, origin)
 	}
+
+	// Remove pending transactions that were included
+	for _, t := range b.Transactions {
+		chain.RemovePendingTransaction(&t)
+	}
+	return nil
 }
 
 func (chain *Blockchain) CalculateProof(b *Block) (*Proof, error) {
 	previousBlock, err := Repo.GetBlockByID(*b.ParentID)
-	if err != nil {
+	if err == pg.ErrNoRows {
 		return nil, ErrParentBlockNotFound
 	}
+	if err != nil {
+		return nil, storageError{err}
+	}
 
-	challengeHasher := sha256.New()
-	hexCreatorBytes, err := hex.DecodeString(b.Creator)
+	// Get the creator's account balance until the parent block
+	// FIXME: Implement a stake height to disallow shuffling attacks
+	stake, err := Repo.StakeUntilBlockWithID(b.Creator, previousBlock.ID)
 	if err != nil {
-		return nil, err
+		return nil, storageError{err}
 	}
-	previousChallengeBytes, err := hex.DecodeString(previousBlock.Challenge)
+
+	return calculateProof(b, previousBlock, *stake)
+}
+
+// Calculate the proof of a block, given its parent block and
+// the stake of the block creator until the parent block.
+func calculateProof(b *Block, previousBlock *Block, stake int64) (*Proof, error) {
+	challengeBytes, err := calculateChallenge(b.Creator, previousBlock.Challenge)
 	if err != nil {
 		return nil, err
 	}
-	challengeHasher.Write(hexCreatorBytes)
-	challengeHasher.Write(previousChallengeBytes)
-	var challengeBytes [encryption.SHA256ByteLength]byte
-	copy(
-		challengeBytes[:],
-		challengeHasher.Sum(nil)[:encryption.SHA256ByteLength],
-	)
 	// The hit is used to check if this node is eligible to
 	// create a new block (this can be verified by every other node)
 	hit := binary.BigEndian.Uint64(challengeBytes[0:8])
 
-	// Get the creator's account balance until the parent block
-	// FIXME: Implement a stake height to disallow shuffling attacks
-	stake, err := Repo.StakeUntilBlockWithID(b.Creator, previousBlock.ID)
+	if stake <= 0 {
+		return nil, ErrAccountHasNoStake
+	}
+
+	ns := b.TimeUnixNano - previousBlock.TimeUnixNano
+	UB := calculateUpperBound(previousBlock.Target, ns, stake)
+
+	target, cumulativeDifficulty, err := calculateTarget(
+		previousBlock.Target,
+		previousBlock.CumulativeDifficulty,
+		ns,
+	)
 	if err != nil {
 		return nil, err
 	}
-	if *stake <= 0 {
-		return nil, ErrAccountHasNoStake
-	}
 
+	return &Proof{
+		Challenge:            hex.EncodeToString(challengeBytes[:]),
+		Hit:                  hit,
+		UpperBound:           *UB,
+		Target:               target,
+		CumulativeDifficulty: cumulativeDifficulty,
+		Stake:                stake,
+		NanoSeconds:          ns,
+	}, nil
+}
+
+// Calculate the upper bound for the hit of a block, given the
+// target of its parent, the nanoseconds since the parent block
+// and the stake of the block creator.
+func calculateUpperBound(parentTarget uint64, ns int64, stake int64) *big.Int {
 	// Note: we use big integers to avoid possible overflows
 	// when the upper bound gets very high (e.g. when
 	// a node stakes millions in account balance)
-	ns := new(big.Int).SetInt64(
-		b.TimeUnixNano - previousBlock.TimeUnixNano,
-	)
-	Tp := new(big.Int).SetUint64(previousBlock.Target)
-	B := new(big.Int).SetInt64(*stake)
+	Tp := new(big.Int).SetUint64(parentTarget)
+	NS := new(big.Int).SetInt64(ns)
+	B := new(big.Int).SetInt64(stake)
 
 	// Upper Bound = (Tp * ns * B) / (1 * 10^9)
 	UB := new(big.Int)
-	UB = UB.Mul(Tp, ns)
+	UB = UB.Mul(Tp, NS)
 	UB = UB.Mul(UB, B)
 	UB = UB.Div(UB, new(big.Int).SetInt64(1_000_000_000))
-
-	// New Block Target = (Tp * ns) / (1 * 10^9)
-	Tn := new(big.Int)
-	Tn = Tn.Mul(Tp, ns)
-	// TODO: Prevent possible overflows
-	Tn = Tn.Div(Tn, new(big.Int).SetInt64(1_000_000_000))
-
-	// New Block Cumulative Difficulty = Dp + (pot / Tn)
-	// Where Pot = 2^64
-	CD := new(big.Int)
-	var pot uint64 = 1 << 63
-	Dp := new(big.Int).SetUint64(previousBlock.CumulativeDifficulty)
-	CD = CD.Div(new(big.Int).SetUint64(pot), Tn) // pot / Tn
-	// TODO: Prevent possible overflows
-	CD = CD.Add(Dp, CD) // Dp + (pot / Tn)
-
-	return &Proof{
-		Challenge:            hex.EncodeToString(challengeBytes[:]),
-		Hit:                  hit,
-		UpperBound:           *UB,
-		Target:               Tn.Uint64(),
-		CumulativeDifficulty: CD.Uint64(),
-		Stake:                *stake,
-		NanoSeconds:          ns.Int64(),
-	}, nil
+	return UB
 }
 
 // Get max. 512 transactions from the pending transactions
@@ -534,12 +535,22 @@ func (chain *Blockchain) MintBlock() (*Block, error) {
 	return block, nil
 }
 
-// Run a scheduled concurrent block creation loop.
-func (chain *Blockchain) RunContinuousMinting() {
+// Run a scheduled concurrent block creation loop until the
+// given context is done. A block that is being minted is
+// always migrated completely before the loop stops.
+func (chain *Blockchain) RunContinuousMinting(ctx context.Context) {
 	for {
 		// Check every 500 ms if we are ready to create a block
 		// i.e. if the upper bound is high enough
-		time.Sleep(500 * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(500 * time.Millisecond):
+		}
+		// Don't mint on top of an outdated chain
+		if Syncer != nil && !Syncer.IsSynced() {
+			continue
+		}
 		chain.ThreadSafe(func() {
 			block, err := chain.MintBlock()
 			if err != nil {
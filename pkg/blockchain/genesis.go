@@ -69,6 +69,7 @@ func init() {
 			TimeUnixNano: time.Unix(0, 0).UnixNano(),
 			Data:         nil,
 			Fee:          0,
+			Position:     len(GenesisTransactions),
 			BlockID:      &GenesisAddress, // Genesis block
 			// Part of the signing process
 			Signature: nil,
@@ -0,0 +1,77 @@
+package blockchain
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/peerbridge/peerbridge/pkg/color"
+	"github.com/peerbridge/peerbridge/pkg/encryption/secp256k1"
+	"github.com/peerbridge/peerbridge/pkg/peer"
+)
+
+// The id of the network that nodes belong to by default.
+const DefaultNetworkID = "peerbridge"
+
+// Get the id of the network that this node belongs to.
+// Nodes only connect to peers of the same network.
+func GetNetworkID() string {
+	networkID := os.Getenv("NETWORK_ID")
+	if networkID != "" {
+		return networkID
+	}
+
+	return DefaultNetworkID
+}
+
+// Create the signed handshake of this node, which has the given peer id.
+func (chain *Blockchain) Handshake(peerID string) (*peer.Handshake, error) {
+	endpoint, err := Repo.GetMainChainEndpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	h := &peer.Handshake{
+		ProtocolVersion:      peer.ProtocolVersion,
+		GenesisID:            GenesisBlock.ID,
+		NetworkID:            GetNetworkID(),
+		Height:               endpoint.Height,
+		CumulativeDifficulty: endpoint.CumulativeDifficulty,
+		PeerID:               peerID,
+		PublicKey:            chain.keyPair.PublicKey,
+		TimeUnixNano:         time.Now().UnixNano(),
+	}
+	signature, err := secp256k1.ComputeSignature(h, chain.keyPair.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	h.Signature = signature
+	return h, nil
+}
+
+// React to a completed handshake. The peer is used as sync remote,
+// and its chain is synced right away if it is ahead of us.
+func reactToHandshake(h *peer.Handshake) {
+	if Syncer == nil {
+		return
+	}
+	Syncer.observePeerHeight(h.Height)
+	Syncer.AddRemote(NewPeerRemote(h.PeerID))
+
+	endpoint, err := Repo.GetMainChainEndpoint()
+	if err != nil {
+		return
+	}
+	local := NewChainTip(endpoint)
+	remote := ChainTip{Height: h.Height, CumulativeDifficulty: h.CumulativeDifficulty}
+	if !remote.IsBetterThan(local) {
+		return
+	}
+	log.Printf(
+		"Peer %s is ahead of us (H %s), syncing from it\n",
+		color.Sprintf(h.PeerID, color.Notice),
+		color.Sprintf(fmt.Sprintf("%d", h.Height), color.Info),
+	)
+	Syncer.RequestSync()
+}
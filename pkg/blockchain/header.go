@@ -0,0 +1,326 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/peerbridge/peerbridge/pkg/encryption"
+	"github.com/peerbridge/peerbridge/pkg/encryption/secp256k1"
+)
+
+const (
+	// The maximum number of headers that are returned
+	// for a single block locator.
+	MaxLocatedHeaders = 2000
+
+	// The maximum number of bytes of transaction sign strings
+	// that are returned together with the located headers.
+	// At least one header is always returned.
+	MaxLocatedHeadersSize = 1 << 20
+
+	// The number of blocks that are loaded at once
+	// when collecting the located headers.
+	locatedHeadersBatchSize = 64
+
+	// The maximum number of blocks that can be
+	// requested with a single request.
+	MaxBlocksPerRequest = 16
+
+	// The maximum number of bytes of JSON encoded blocks that are
+	// returned for a single request, so that responses fit into a
+	// peer message frame. Responses only contain the first of the
+	// requested blocks that fit, but at least one block.
+	MaxBlocksResponseSize = 8 << 20
+)
+
+var (
+	ErrHeaderParentMismatch     = errors.New("Header does not link to its parent!")
+	ErrHeaderHeightMismatch     = errors.New("Header height does not follow its parent!")
+	ErrHeaderChallengeMismatch  = errors.New("Header challenge is invalid!")
+	ErrHeaderTargetMismatch     = errors.New("Header target is invalid!")
+	ErrHeaderDifficultyMismatch = errors.New("Header cumulative difficulty is invalid!")
+	ErrBlockHeaderMismatch      = errors.New("Block does not match its header!")
+)
+
+// The header of a block, i.e. the block without its transactions.
+// Headers are small, so that a long chain can be downloaded and
+// checked before the much larger block bodies are requested.
+// Instead of the transactions, the header contains their sign
+// strings, so that the block signature can be verified.
+type BlockHeader struct {
+	// The random id of the block.
+	ID encryption.SHA256HexString `json:"id"`
+
+	// The id of the parent block.
+	// This is only "nil" for the genesis block.
+	ParentID *encryption.SHA256HexString `json:"parentID"`
+
+	// The height of the block.
+	Height uint64 `json:"height"`
+
+	// The timestamp of the block creation.
+	TimeUnixNano int64 `json:"timeUnixNano"`
+
+	// The address of the block creator.
+	Creator secp256k1.PublicKeyHexString `json:"creator"`
+
+	// The target value of the block.
+	Target uint64 `json:"target"`
+
+	// The challenge of the block.
+	Challenge encryption.SHA256HexString `json:"challenge"`
+
+	// The cumulative difficulty of the block.
+	CumulativeDifficulty uint64 `json:"cumulativeDifficulty"`
+
+	// The concatenated sign strings of the block transactions.
+	// Since the data of versioned transactions is signed by its
+	// hash, this is much smaller than the transactions themselves.
+	SignedTransactions string `json:"signedTransactions"`
+
+	// The signature of the block.
+	Signature *secp256k1.SignatureHexString `json:"signature"`
+}
+
+func (h *BlockHeader) GetSender() secp256k1.PublicKeyHexString {
+	return h.Creator
+}
+
+func (h *BlockHeader) GetSignString() string {
+	parent := ""
+	if h.ParentID != nil {
+		parent = *h.ParentID
+	}
+
+	str := fmt.Sprintf(
+		"id:%s|parentID:%s|height:%d|timeUnixNano:%d|transactions:%s|creator:%s|target:%d|challenge:%s|cumulativeDifficulty:%d",
+		h.ID,
+		parent,
+		h.Height,
+		h.TimeUnixNano,
+		h.SignedTransactions,
+		h.Creator,
+		h.Target,
+		h.Challenge,
+		h.CumulativeDifficulty,
+	)
+
+	return str
+}
+
+// Get the header of the block.
+func (b *Block) Header() BlockHeader {
+	return BlockHeader{
+		ID:                   b.ID,
+		ParentID:             b.ParentID,
+		Height:               b.Height,
+		TimeUnixNano:         b.TimeUnixNano,
+		Creator:              b.Creator,
+		Target:               b.Target,
+		Challenge:            b.Challenge,
+		CumulativeDifficulty: b.CumulativeDifficulty,
+		SignedTransactions:   b.transactionsSignString(),
+		Signature:            b.Signature,
+	}
+}
+
+// Check if the given block has exactly this header.
+func (h *BlockHeader) Matches(b *Block) bool {
+	if b.ParentID == nil || h.ParentID == nil || *b.ParentID != *h.ParentID {
+		return false
+	}
+	if b.Signature == nil || h.Signature == nil || *b.Signature != *h.Signature {
+		return false
+	}
+	return b.ID == h.ID &&
+		b.Height == h.Height &&
+		b.TimeUnixNano == h.TimeUnixNano &&
+		b.Creator == h.Creator &&
+		b.Target == h.Target &&
+		b.Challenge == h.Challenge &&
+		b.CumulativeDifficulty == h.CumulativeDifficulty &&
+		b.transactionsSignString() == h.SignedTransactions
+}
+
+// Validate the header against the header of its parent.
+//
+// This checks the linkage, the challenge, the target, the
+// cumulative difficulty and the signature of the header.
+// The hit of the proof of stake depends on the stake of the
+// creator, which can only be calculated from the transactions
+// of the chain. Therefore, the hit is checked against the total
+// supply here, and against the actual stake once the block
+// body is migrated.
+func (h *BlockHeader) Validate(parent *BlockHeader) error {
+	if !isHexOfLength(h.ID, encryption.SHA256ByteLength) ||
+		!isHexOfLength(h.Creator, secp256k1.PublicKeyByteLength) ||
+		!isHexOfLength(h.Challenge, encryption.SHA256ByteLength) ||
+		h.Signature == nil ||
+		!isHexOfLength(*h.Signature, secp256k1.SignatureByteLength) {
+		return ErrMalformedBlock
+	}
+	if h.ParentID == nil || *h.ParentID != parent.ID {
+		return ErrHeaderParentMismatch
+	}
+	if h.Height != parent.Height+1 {
+		return ErrHeaderHeightMismatch
+	}
+
+	challenge, err := calculateChallenge(h.Creator, parent.Challenge)
+	if err != nil {
+		return err
+	}
+	if h.Challenge != hex.EncodeToString(challenge[:]) {
+		return ErrHeaderChallengeMismatch
+	}
+
+	target, cumulativeDifficulty, err := calculateTarget(
+		parent.Target,
+		parent.CumulativeDifficulty,
+		h.TimeUnixNano-parent.TimeUnixNano,
+	)
+	if err != nil {
+		return err
+	}
+	if h.Target != target {
+		return ErrHeaderTargetMismatch
+	}
+	if h.CumulativeDifficulty != cumulativeDifficulty {
+		return ErrHeaderDifficultyMismatch
+	}
+
+	hit := new(big.Int).SetUint64(binary.BigEndian.Uint64(challenge[0:8]))
+	upperBound := calculateUpperBound(
+		parent.Target,
+		h.TimeUnixNano-parent.TimeUnixNano,
+		totalSupplyUntilHeight(parent.Height),
+	)
+	if hit.Cmp(upperBound) == 1 {
+		return ErrProofHitAboveUpperBound
+	}
+
+	return secp256k1.VerifySignature(h, *h.Signature)
+}
+
+// Get the total supply of coins until the block with the given
+// height, which is an upper bound for the stake of every account.
+func totalSupplyUntilHeight(height uint64) int64 {
+	supply := int64(0)
+	for _, stake := range Stakeholders {
+		supply += int64(stake)
+	}
+	// Every block, including the genesis block, is rewarded
+	return supply + BlockReward*int64(height+1)
+}
+
+// Get the blocks with the given ids, in the given order.
+// Unknown ids are skipped.
+func (r *BlockRepo) GetBlocksByIDs(ids []encryption.SHA256HexString) (*[]Block, error) {
+	blocks := []Block{}
+	if len(ids) == 0 {
+		return &blocks, nil
+	}
+	err := r.DB.Model(&blocks).
+		Where("id IN (?)", pg.In(ids)).
+		Relation("Transactions", orderTransactions).
+		Select()
+	if err != nil {
+		return nil, err
+	}
+
+	blocksByID := map[encryption.SHA256HexString]Block{}
+	for _, b := range blocks {
+		blocksByID[b.ID] = b
+	}
+	ordered := []Block{}
+	for _, id := range ids {
+		if b, ok := blocksByID[id]; ok {
+			ordered = append(ordered, b)
+		}
+	}
+	return &ordered, nil
+}
+
+// Get the first of the given blocks whose encoded size fits into
+// `MaxBlocksResponseSize`. The first block is always included.
+func limitBlocksResponse(blocks []Block) ([]Block, error) {
+	size := 0
+	for i := range blocks {
+		encoded, err := json.Marshal(&blocks[i])
+		if err != nil {
+			return nil, err
+		}
+		size += len(encoded)
+		if i > 0 && size > MaxBlocksResponseSize {
+			return blocks[:i], nil
+		}
+	}
+	return blocks, nil
+}
+
+// Get up to `limit` main chain headers on top of the most recent
+// block that is contained in the given block locator.
+// The headers are ordered by ascending height.
+//
+// This does not need the chain lock. If the main chain changes
+// between the queries, only the headers which still link to the
+// fork point are returned.
+func (r *BlockRepo) GetMainChainHeadersAfterLocator(
+	locator []encryption.SHA256HexString, limit int,
+) (*Block, *[]BlockHeader, error) {
+	forkPoint, err := r.FindMainChainForkPoint(locator)
+	if err != nil {
+		return nil, nil, err
+	}
+	if limit <= 0 || limit > MaxLocatedHeaders {
+		limit = MaxLocatedHeaders
+	}
+
+	var ids []encryption.SHA256HexString
+	_, err = r.DB.Query(&ids, fmt.Sprintf(`
+		%s
+
+		SELECT id
+		FROM main_chain
+		WHERE height BETWEEN ? AND ?
+		ORDER BY height ASC;
+	`, mainChainPartialQuery), forkPoint.Height+1, forkPoint.Height+uint64(limit))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Load the blocks in batches, and stop once the sign strings
+	// of their transactions exceed the response size
+	headers := []BlockHeader{}
+	parentID := forkPoint.ID
+	size := 0
+	for start := 0; start < len(ids); start += locatedHeadersBatchSize {
+		end := start + locatedHeadersBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		blocks, err := r.GetBlocksByIDs(ids[start:end])
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range *blocks {
+			h := (*blocks)[i].Header()
+			if h.ParentID == nil || *h.ParentID != parentID {
+				// The main chain was reorganized in the meantime
+				return forkPoint, &headers, nil
+			}
+			size += len(h.SignedTransactions)
+			if len(headers) > 0 && size > MaxLocatedHeadersSize {
+				return forkPoint, &headers, nil
+			}
+			headers = append(headers, h)
+			parentID = h.ID
+		}
+	}
+	return forkPoint, &headers, nil
+}
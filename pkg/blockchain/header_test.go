@@ -0,0 +1,104 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestValidateHeader(t *testing.T) {
+	genesis := GenesisBlock.Header()
+	firstBlock := signedChild(t, GenesisBlock)
+	first := firstBlock.Header()
+	if err := first.Validate(&genesis); err != nil {
+		t.Fatalf("Expected a valid header, got %s", err)
+	}
+	second := signedChild(t, firstBlock).Header()
+	if err := second.Validate(&first); err != nil {
+		t.Fatalf("Expected a valid header, got %s", err)
+	}
+
+	if err := second.Validate(&genesis); err != ErrHeaderParentMismatch {
+		t.Errorf("Expected %s, got %s", ErrHeaderParentMismatch, err)
+	}
+
+	wrongChallenge := second
+	wrongChallenge.Challenge = first.Challenge
+	if err := wrongChallenge.Validate(&first); err != ErrHeaderChallengeMismatch {
+		t.Errorf("Expected %s, got %s", ErrHeaderChallengeMismatch, err)
+	}
+
+	wrongDifficulty := second
+	wrongDifficulty.CumulativeDifficulty++
+	if err := wrongDifficulty.Validate(&first); err != ErrHeaderDifficultyMismatch {
+		t.Errorf("Expected %s, got %s", ErrHeaderDifficultyMismatch, err)
+	}
+
+	wrongTime := second
+	wrongTime.TimeUnixNano = first.TimeUnixNano
+	if err := wrongTime.Validate(&first); err != ErrBlockTimeBeforeParent {
+		t.Errorf("Expected %s, got %s", ErrBlockTimeBeforeParent, err)
+	}
+
+	forgedTransactions := second
+	forgedTransactions.SignedTransactions = first.SignedTransactions
+	if err := forgedTransactions.Validate(&first); err == nil {
+		t.Error("Expected the signature of forged transactions to be invalid")
+	}
+}
+
+func TestValidateHeaderHit(t *testing.T) {
+	genesis := GenesisBlock.Header()
+	ns := eligibleAfter(t, GenesisBlock)
+	if ns <= int64(time.Second) {
+		t.Skip("The genesis key pair is eligible right away")
+	}
+
+	early := signedChildAfter(t, GenesisBlock, ns/2).Header()
+	if err := early.Validate(&genesis); err != ErrProofHitAboveUpperBound {
+		t.Errorf("Expected %s, got %v", ErrProofHitAboveUpperBound, err)
+	}
+	eligible := signedChildAfter(t, GenesisBlock, ns).Header()
+	if err := eligible.Validate(&genesis); err != nil {
+		t.Errorf("Expected a valid header, got %s", err)
+	}
+}
+
+func TestHeaderSignString(t *testing.T) {
+	b := signedChild(t, GenesisBlock)
+	h := b.Header()
+	if h.GetSignString() != b.GetSignString() {
+		t.Error("Expected the header to have the sign string of its block")
+	}
+	if !h.Matches(b) {
+		t.Error("Expected the header to match its block")
+	}
+}
+
+func TestLimitBlocksResponse(t *testing.T) {
+	data := make([]byte, MaxBlocksResponseSize/6)
+	blocks := make([]Block, 8)
+	for i := range blocks {
+		blocks[i].Transactions = []Transaction{{Data: &data}}
+	}
+	encoded, err := json.Marshal(&blocks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limited, err := limitBlocksResponse(blocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := MaxBlocksResponseSize / len(encoded); len(limited) != want {
+		t.Errorf("Expected %d blocks to fit into the response, got %d", want, len(limited))
+	}
+
+	// A single block is always returned, even if it is too large
+	large := make([]byte, MaxBlocksResponseSize)
+	blocks[0].Transactions[0].Data = &large
+	limited, err = limitBlocksResponse(blocks)
+	if err != nil || len(limited) != 1 {
+		t.Errorf("Expected only the first block to be returned, got %d block(s) (%v)", len(limited), err)
+	}
+}
@@ -0,0 +1,141 @@
+package blockchain
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/peerbridge/peerbridge/pkg/color"
+	"github.com/peerbridge/peerbridge/pkg/encryption"
+	"github.com/peerbridge/peerbridge/pkg/encryption/secp256k1"
+)
+
+// The number of blocks that are loaded at once
+// during the integrity check.
+const integrityCheckBatchSize = 100
+
+// Find blocks that were only partially written to the repository,
+// e.g. because the node crashed while a block was inserted.
+//
+// A block is considered partially written if its signature cannot
+// be verified with the transactions that are stored for it, since
+// the block signature covers all of its transactions.
+// The genesis block is skipped, as it is recreated on every start.
+// The signatures of blocks with pruned transaction data are verified
+// with the data hashes of their transactions.
+//
+// The blocks are paged by height and id, so that every batch is
+// found through the height index instead of skipping the previous
+// blocks with an offset.
+func (r *BlockRepo) FindPartialBlocks() ([]encryption.SHA256HexString, error) {
+	partialBlocks := []encryption.SHA256HexString{}
+	var last *Block
+	for {
+		var blocks []Block
+		q := r.DB.Model(&blocks)
+		if last != nil {
+			q = q.Where("(height, id) > (?, ?)", last.Height, last.ID)
+		}
+		err := q.
+			Order("height ASC", "id ASC").
+			Limit(integrityCheckBatchSize).
+			Relation("Transactions", orderTransactions).
+			Select()
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range blocks {
+			if isPartialBlock(&blocks[i]) {
+				partialBlocks = append(partialBlocks, blocks[i].ID)
+			}
+		}
+
+		if len(blocks) < integrityCheckBatchSize {
+			return partialBlocks, nil
+		}
+		last = &blocks[len(blocks)-1]
+	}
+}
+
+// Check if a stored block is missing some of its transactions.
+// The genesis block is never considered partially written.
+func isPartialBlock(b *Block) bool {
+	if b.ParentID == nil {
+		return false
+	}
+	if len(b.Transactions) == 0 || b.Signature == nil {
+		return true
+	}
+	return secp256k1.VerifySignature(b, *b.Signature) != nil
+}
+
+// Detect and repair partially written blocks. This runs when the
+// server starts, so that the node never uses such blocks.
+//
+// Partially written blocks are removed together with all of their
+// descendants, so that they can be obtained again from other peers.
+// Missing genesis transactions are restored.
+func (r *BlockRepo) RepairPartialBlocks() error {
+	log.Println(color.Sprintf("Checking the integrity of the database...", color.Notice))
+
+	err := r.repairGenesisTransactions()
+	if err != nil {
+		return err
+	}
+
+	partialBlocks, err := r.FindPartialBlocks()
+	if err != nil {
+		return err
+	}
+
+	if len(partialBlocks) == 0 {
+		log.Println(color.Sprintf("No partially written blocks found.", color.Success))
+		return nil
+	}
+
+	for _, id := range partialBlocks {
+		log.Printf("Partially written block: %s\n", color.Sprintf(id, color.Warning))
+	}
+
+	removedBlocks := 0
+	err = r.DB.RunInTransaction(context.Background(), func(tx *pg.Tx) error {
+		for _, id := range partialBlocks {
+			removed, err := removeBlockTree(tx, id)
+			if err != nil {
+				return err
+			}
+			removedBlocks += len(removed)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Println(color.Sprintf(fmt.Sprintf(
+		"Found %d partially written block(s), removed %d block(s) including descendants.",
+		len(partialBlocks), removedBlocks,
+	), color.Warning))
+	return nil
+}
+
+// Restore genesis transactions which are missing in the repository.
+func (r *BlockRepo) repairGenesisTransactions() error {
+	if !r.ContainsBlockByID(GenesisBlock.ID) || len(GenesisTransactions) == 0 {
+		return nil
+	}
+	txns := make([]Transaction, len(GenesisTransactions))
+	copy(txns, GenesisTransactions)
+	res, err := r.DB.Model(&txns).OnConflict("DO NOTHING").Insert()
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() > 0 {
+		log.Println(color.Sprintf(fmt.Sprintf(
+			"Restored %d missing genesis transaction(s).", res.RowsAffected(),
+		), color.Warning))
+	}
+	return nil
+}
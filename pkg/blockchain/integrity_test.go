@@ -0,0 +1,53 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/peerbridge/peerbridge/pkg/encryption/secp256k1"
+)
+
+func TestIsPartialBlock(t *testing.T) {
+	b := signedBlockWithData(t, TransactionVersionDataHash)
+	if isPartialBlock(b) {
+		t.Error("Expected a complete block not to be partial")
+	}
+
+	pruned := signedBlockWithData(t, TransactionVersionDataHash)
+	pruneBlock(pruned)
+	if isPartialBlock(pruned) {
+		t.Error("Expected a pruned block not to be partial")
+	}
+
+	genesis := *b
+	genesis.ParentID = nil
+	genesis.Transactions = []Transaction{}
+	if isPartialBlock(&genesis) {
+		t.Error("Expected the genesis block never to be partial")
+	}
+
+	empty := *b
+	empty.Transactions = []Transaction{}
+	if !isPartialBlock(&empty) {
+		t.Error("Expected a block without stored transactions to be partial")
+	}
+}
+
+func TestBlockWithRemovedTransactionIsPartial(t *testing.T) {
+	b := signedBlockWithData(t, TransactionVersionDataHash)
+	b.Transactions = append(b.Transactions, signedBlockWithData(t, TransactionVersionDataHash).Transactions...)
+	var err error
+	b.Signature, err = secp256k1.ComputeSignature(b, GenesisKeyPair.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isPartialBlock(b) {
+		t.Fatal("Expected the complete block not to be partial")
+	}
+
+	// Only the first transaction was written before the crash
+	stored := *b
+	stored.Transactions = b.Transactions[:1]
+	if !isPartialBlock(&stored) {
+		t.Error("Expected a block with a removed transaction to be partial")
+	}
+}
@@ -0,0 +1,161 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/peerbridge/peerbridge/pkg/encryption"
+)
+
+const (
+	// The number of most recent blocks that are included
+	// one by one in a block locator, before the step
+	// between the included blocks starts to double.
+	locatorDenseBlocks = 10
+
+	// The maximum number of block ids in a block locator.
+	// A locator of a chain with a height of 2^50 has
+	// around 60 entries, so longer locators are rejected.
+	MaxLocatorLength = 64
+)
+
+var (
+	ErrNoCommonBlock  = errors.New("No common block found!")
+	ErrLocatorTooLong = errors.New("The locator contains too many blocks!")
+)
+
+// The endpoint of a chain, i.e. a block without children.
+type ChainTip struct {
+	// The id of the endpoint block.
+	ID encryption.SHA256HexString `json:"id"`
+
+	// The height of the endpoint block.
+	Height uint64 `json:"height"`
+
+	// The cumulative difficulty of the endpoint block.
+	CumulativeDifficulty uint64 `json:"cumulativeDifficulty"`
+}
+
+// Create a chain tip from the given block.
+func NewChainTip(b *Block) ChainTip {
+	return ChainTip{
+		ID:                   b.ID,
+		Height:               b.Height,
+		CumulativeDifficulty: b.CumulativeDifficulty,
+	}
+}
+
+// Check if the chain ending in this tip is preferred over the
+// chain ending in the other tip. Just as for the main chain,
+// the higher chain is preferred and the cumulative difficulty
+// is used to decide between chains with equal heights.
+func (t ChainTip) IsBetterThan(o ChainTip) bool {
+	if t.Height != o.Height {
+		return t.Height > o.Height
+	}
+	return t.CumulativeDifficulty > o.CumulativeDifficulty
+}
+
+// Get all chain tips, ordered from the best to the worst tip.
+// The first tip is the main chain endpoint.
+func (r *BlockRepo) GetChainTips() (*[]ChainTip, error) {
+	tips := []ChainTip{}
+	_, err := r.DB.Query(&tips, `
+		SELECT b.id, b.height, b.cumulative_difficulty
+		FROM blocks b
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM blocks c
+			WHERE c.parent_id = b.id
+		)
+		ORDER BY b.height DESC, b.cumulative_difficulty DESC;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return &tips, nil
+}
+
+// Get the heights of the blocks that are included in a
+// block locator for a chain with the given head height.
+// The most recent blocks are included one by one, after
+// which the step between the blocks doubles, so that the
+// locator stays small even for long chains. The genesis
+// height is always included as the last entry.
+func locatorHeights(head uint64) []uint64 {
+	heights := []uint64{}
+	step := uint64(1)
+	height := head
+	for height > 0 {
+		heights = append(heights, height)
+		if len(heights) >= locatorDenseBlocks {
+			step *= 2
+		}
+		if height < step {
+			break
+		}
+		height -= step
+	}
+	return append(heights, 0)
+}
+
+// Get a block locator for the chain that ends in the given block.
+// The locator is a list of block ids from the given block down to the
+// genesis block, which is dense at the top and sparse at the bottom.
+// A remote can use it to find the most recent block that we have in
+// common, even when our chain endpoint is on a fork.
+func (r *BlockRepo) GetBlockLocator(b *Block) ([]encryption.SHA256HexString, error) {
+	var ids []encryption.SHA256HexString
+	_, err := r.DB.Query(&ids, `
+		WITH RECURSIVE chain AS(
+			SELECT id, parent_id, height
+			FROM blocks
+			WHERE id = ?
+			UNION ALL
+			SELECT b.id, b.parent_id, b.height
+			FROM blocks b
+			INNER JOIN chain c
+			ON c.parent_id = b.id
+		)
+
+		SELECT id
+		FROM chain
+		WHERE height IN (?)
+		ORDER BY height DESC;
+	`, b.ID, pg.In(locatorHeights(b.Height)))
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Find the most recent main chain block that is contained
+// in the given block locator. Returns `ErrNoCommonBlock` if
+// none of the blocks is in our main chain and `ErrLocatorTooLong`
+// if the locator has more than `MaxLocatorLength` entries.
+func (r *BlockRepo) FindMainChainForkPoint(locator []encryption.SHA256HexString) (*Block, error) {
+	if len(locator) > MaxLocatorLength {
+		return nil, ErrLocatorTooLong
+	}
+	if len(locator) == 0 {
+		return nil, ErrNoCommonBlock
+	}
+	var ids []encryption.SHA256HexString
+	_, err := r.DB.Query(&ids, fmt.Sprintf(`
+		%s
+
+		SELECT id
+		FROM main_chain
+		WHERE id IN (?)
+		ORDER BY height DESC
+		LIMIT 1;
+	`, mainChainPartialQuery), pg.In(locator))
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, ErrNoCommonBlock
+	}
+	return r.GetBlockByID(ids[0])
+}
@@ -0,0 +1,67 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/peerbridge/peerbridge/pkg/encryption"
+	"github.com/peerbridge/peerbridge/pkg/peer"
+)
+
+func TestLocatorHeights(t *testing.T) {
+	heights := locatorHeights(0)
+	if len(heights) != 1 || heights[0] != 0 {
+		t.Errorf("Expected only the genesis height, got %v", heights)
+	}
+
+	heights = locatorHeights(5)
+	expected := []uint64{5, 4, 3, 2, 1, 0}
+	if len(heights) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, heights)
+	}
+	for i := range expected {
+		if heights[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, heights)
+		}
+	}
+
+	heights = locatorHeights(1_000_000)
+	if len(heights) > 40 {
+		t.Errorf("Expected a sparse locator, got %d entries", len(heights))
+	}
+	for i := 1; i < len(heights); i++ {
+		if heights[i] >= heights[i-1] {
+			t.Errorf("Expected strictly descending heights, got %v", heights)
+		}
+	}
+	if heights[len(heights)-1] != 0 {
+		t.Errorf("Expected the locator to end with the genesis height")
+	}
+}
+
+func TestFindMainChainForkPointRejectsLongLocators(t *testing.T) {
+	locator := make([]encryption.SHA256HexString, MaxLocatorLength+1)
+	_, err := (&BlockRepo{}).FindMainChainForkPoint(locator)
+	if err != ErrLocatorTooLong {
+		t.Errorf("Expected %s, got %v", ErrLocatorTooLong, err)
+	}
+}
+
+func TestLocatorFitsMaxLength(t *testing.T) {
+	// The sync service prepends the parent of the endpoint
+	if n := len(locatorHeights(1<<50)) + 1; n > MaxLocatorLength {
+		t.Errorf("Expected at most %d locator entries, got %d", MaxLocatorLength, n)
+	}
+}
+
+func TestLocateHeadersRequestRejectsLongLocators(t *testing.T) {
+	locator := make([]encryption.SHA256HexString, MaxLocatorLength+1)
+	payload, err := json.Marshal(LocateHeadersRequest{Locator: locator})
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope := &peer.Envelope{Type: LocateHeadersRequestType, Payload: payload}
+	if _, err := handleLocateHeadersRequest(envelope); err != ErrLocatorTooLong {
+		t.Errorf("Expected %s, got %v", ErrLocatorTooLong, err)
+	}
+}
@@ -1,13 +1,30 @@
 package blockchain
 
 import (
+	"context"
 	"encoding/json"
 	"log"
+	"time"
 
 	"github.com/peerbridge/peerbridge/pkg/encryption"
 	"github.com/peerbridge/peerbridge/pkg/peer"
 )
 
+// The types of the blockchain peer messages.
+const (
+	NewTransactionMessageType       peer.MessageType = "blockchain/newTransaction"
+	NewBlockMessageType             peer.MessageType = "blockchain/newBlock"
+	ResolveBlockRequestMessageType  peer.MessageType = "blockchain/resolveBlockRequest"
+	ResolveBlockResponseMessageType peer.MessageType = "blockchain/resolveBlockResponse"
+	ChainTipsRequestMessageType     peer.MessageType = "blockchain/chainTipsRequest"
+	ChainTipsResponseMessageType    peer.MessageType = "blockchain/chainTipsResponse"
+)
+
+var (
+	// Peers that send malformed messages are penalized.
+	ErrMalformedMessage = peer.ErrMalformedPayload
+)
+
 type NewTransactionMessage struct {
 	NewTransaction *Transaction `json:"newTransaction"`
 }
@@ -24,79 +41,218 @@ type ResolveBlockRequest struct {
 	BlockID *encryption.SHA256HexString `json:"blockID"`
 }
 
-func BroadcastNewTransaction(t *Transaction) {
-	log.Printf("Broadcast new transaction: %s\n", t.ID[:6])
-	go peer.Service.Broadcast(NewTransactionMessage{t})
+type ChainTipsRequest struct {
+	RequestChainTips bool `json:"requestChainTips"`
 }
 
-func BroadcastNewBlock(b *Block) {
-	log.Printf("Broadcast new block: %s\n", b.ID[:6])
-	go peer.Service.Broadcast(NewBlockMessage{b})
+type ChainTipsResponse struct {
+	ChainTips *[]ChainTip `json:"chainTips"`
 }
 
+// The interval in which the peers are asked for their chain tips.
+const chainTipsRequestInterval = 1 * time.Minute
+
 func BroadcastResolveBlockRequest(id *encryption.SHA256HexString) {
 	log.Printf("Broadcast resolve block request: %s\n", (*id)[:6])
-	go peer.Service.Broadcast(ResolveBlockRequest{id})
+	go peer.Service.Broadcast(ResolveBlockRequestMessageType, ResolveBlockRequest{id})
 }
 
-func BroadcastResolveBlockResponse(b *Block) {
-	log.Printf("Broadcast resolve block response: %s\n", b.ID[:6])
-	go peer.Service.Broadcast(ResolveBlockResponse{b})
+// Request a block from the peer which will most likely know it,
+// e.g. the peer that sent a child of the block. The block is
+// requested from the other peers if the peer doesn't have it.
+func RequestBlock(id *encryption.SHA256HexString, remote string) {
+	log.Printf("Request block %s\n", (*id)[:6])
+	go resolveBlock(*id, remote)
 }
 
-func reactToPeerMessage(bytes []byte) {
-	log.Println("Got new blockchain peer message.")
+func BroadcastChainTipsRequest() {
+	log.Println("Broadcast chain tips request")
+	go peer.Service.Broadcast(ChainTipsRequestMessageType, ChainTipsRequest{true})
+}
 
-	var newTMessage NewTransactionMessage
-	err := json.Unmarshal(bytes, &newTMessage)
-	if err == nil && newTMessage.NewTransaction != nil {
-		Instance.ThreadSafe(func() {
-			Instance.AddPendingTransaction(newTMessage.NewTransaction)
-		})
+// Request unknown chain tips of a peer that are better than
+// our main chain endpoint. The missing parents are resolved
+// block by block, until the common ancestor is reached.
+func resolveBetterChainTips(tips *[]ChainTip, remote string) {
+	endpoint, err := Repo.GetMainChainEndpoint()
+	if err != nil {
 		return
 	}
+	for _, tip := range *tips {
+		if !tip.IsBetterThan(NewChainTip(endpoint)) {
+			continue
+		}
+		if Repo.ContainsBlockByID(tip.ID) || Repo.IsBlockInvalidated(tip.ID) {
+			continue
+		}
+		id := tip.ID
+		RequestBlock(&id, remote)
+	}
+}
 
-	var newBMessage NewBlockMessage
-	err = json.Unmarshal(bytes, &newBMessage)
-	if err == nil && newBMessage.NewBlock != nil {
-		Instance.ThreadSafe(func() {
-			Instance.MigrateBlock(newBMessage.NewBlock, false)
-		})
+// Report the outcome of handling a transaction or block from a peer
+// to the peer reputation. Invalid data is penalized and new data is
+// rewarded. Reasons which the peer cannot know about are ignored.
+// Forwarded data is not penalized here, since the forwarding peer
+// did not create it. It is already penalized by the topic validator,
+// if the forwarding peer could have detected the error.
+func reportPeerData(source string, forwarded bool, isNew bool, err error) {
+	if _, ok := err.(storageError); ok {
 		return
 	}
-
-	var rRequest ResolveBlockRequest
-	err = json.Unmarshal(bytes, &rRequest)
-	if err == nil && rRequest.BlockID != nil {
-		block, err := Repo.GetBlockByID(*rRequest.BlockID)
-		if err == nil {
-			BroadcastResolveBlockResponse(block)
+	switch err {
+	case nil:
+		if isNew {
+			peer.Service.Reward(source, peer.RewardUsefulData)
+		}
+	case ErrTransactionAlreadyPending, ErrBlockInvalidated, ErrOrphanQuotaExceeded:
+	default:
+		if !forwarded {
+			peer.Service.Penalize(source, peer.PenaltyInvalidData, err)
 		}
-		return
 	}
+}
 
-	var rResponse ResolveBlockResponse
-	err = json.Unmarshal(bytes, &rResponse)
-	if err == nil && rResponse.ResolvedBlock != nil {
-		Instance.ThreadSafe(func() {
-			Instance.MigrateBlock(rResponse.ResolvedBlock, false)
-		})
-		return
+// Migrate a block from a peer and report the outcome
+// to the peer reputation.
+func migratePeerBlock(b *Block, source string, forwarded bool) {
+	Instance.ThreadSafe(func() {
+		isNew := !Repo.ContainsBlockByID(b.ID) && !Instance.Orphans.Contains(b.ID)
+		err := Instance.MigrateBlockFrom(b, source, false)
+		reportPeerData(source, forwarded, isNew, err)
+	})
+}
+
+func handleNewTransaction(envelope *peer.Envelope) error {
+	var message NewTransactionMessage
+	if err := envelope.Decode(&message); err != nil || message.NewTransaction == nil {
+		return ErrMalformedMessage
+	}
+	Instance.ThreadSafe(func() {
+		err := Instance.AddPendingTransactionFrom(message.NewTransaction, envelope.Sender)
+		reportPeerData(envelope.Sender, envelope.Forwarded, true, err)
+	})
+	return nil
+}
+
+func handleNewBlock(envelope *peer.Envelope) error {
+	var message NewBlockMessage
+	if err := envelope.Decode(&message); err != nil || message.NewBlock == nil {
+		return ErrMalformedMessage
+	}
+	migratePeerBlock(message.NewBlock, envelope.Sender, envelope.Forwarded)
+	return nil
+}
+
+func handleResolveBlockRequest(envelope *peer.Envelope) error {
+	var message ResolveBlockRequest
+	if err := envelope.Decode(&message); err != nil || message.BlockID == nil {
+		return ErrMalformedMessage
+	}
+	block, err := Repo.GetBlockByID(*message.BlockID)
+	if err != nil || block.IsPruned() {
+		return nil
 	}
+	// Only the requesting peer needs the block
+	return peer.Service.Send(envelope.Sender, ResolveBlockResponseMessageType, ResolveBlockResponse{block})
+}
+
+func handleResolveBlockResponse(envelope *peer.Envelope) error {
+	var message ResolveBlockResponse
+	if err := envelope.Decode(&message); err != nil || message.ResolvedBlock == nil {
+		return ErrMalformedMessage
+	}
+	migratePeerBlock(message.ResolvedBlock, envelope.Sender, false)
+	return nil
+}
+
+func handleChainTipsRequest(envelope *peer.Envelope) error {
+	var message ChainTipsRequest
+	if err := envelope.Decode(&message); err != nil || !message.RequestChainTips {
+		return ErrMalformedMessage
+	}
+	tips, err := Repo.GetChainTips()
+	if err != nil {
+		return nil
+	}
+	return peer.Service.Send(envelope.Sender, ChainTipsResponseMessageType, ChainTipsResponse{tips})
+}
+
+func handleChainTipsResponse(envelope *peer.Envelope) error {
+	var message ChainTipsResponse
+	if err := envelope.Decode(&message); err != nil || message.ChainTips == nil {
+		return ErrMalformedMessage
+	}
+	Instance.ThreadSafe(func() {
+		resolveBetterChainTips(message.ChainTips, envelope.Sender)
+	})
+	return nil
+}
+
+// Determine the type of a message that was sent without an
+// envelope by a node that runs an older version. The type
+// is determined by the first field which is set.
+func decodeLegacyMessage(bytes []byte) (peer.MessageType, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(bytes, &fields); err != nil {
+		return "", false
+	}
+	isSet := func(field string) bool {
+		value, ok := fields[field]
+		return ok && string(value) != "null" && string(value) != "false"
+	}
+
+	switch {
+	case isSet("newTransaction"):
+		return NewTransactionMessageType, true
+	case isSet("newBlock"):
+		return NewBlockMessageType, true
+	case isSet("blockID"):
+		return ResolveBlockRequestMessageType, true
+	case isSet("resolvedBlock"):
+		return ResolveBlockResponseMessageType, true
+	case isSet("requestChainTips"):
+		return ChainTipsRequestMessageType, true
+	case isSet("chainTips"):
+		return ChainTipsResponseMessageType, true
+	}
+	return "", false
 }
 
 // Bind the blockchain to new messages from the peer.
 func ReactToPeerMessages() {
-	channel := make(chan []byte)
-
-	go func() {
-		for {
-			select {
-			case message := <-channel:
-				reactToPeerMessage(message)
-			}
-		}
-	}()
+	// Publish and receive transactions and blocks via topics
+	peer.Service.SetNetworkID(GetNetworkID())
+	peer.Service.HandleTopic(NewTransactionMessageType, validateTransactionMessage, handleNewTransaction)
+	peer.Service.HandleTopic(NewBlockMessageType, validateBlockMessage, handleNewBlock)
+	peer.Service.Handle(ResolveBlockRequestMessageType, handleResolveBlockRequest)
+	peer.Service.Handle(ResolveBlockResponseMessageType, handleResolveBlockResponse)
+	peer.Service.Handle(ChainTipsRequestMessageType, handleChainTipsRequest)
+	peer.Service.Handle(ChainTipsResponseMessageType, handleChainTipsResponse)
+
+	// Answer directed requests, e.g. for syncing
+	peer.Service.HandleRequest(GetChainTipsRequestType, handleGetChainTipsRequest)
+	peer.Service.HandleRequest(LocateHeadersRequestType, handleLocateHeadersRequest)
+	peer.Service.HandleRequest(GetBlocksRequestType, handleGetBlocksRequest)
 
-	peer.Service.SubscribeIncoming(channel)
+	// Accept messages without envelope from older nodes
+	peer.Service.SetLegacyDecoder(decodeLegacyMessage)
+
+	// Exchange the chain state with new peers
+	peer.Service.SetHandshakeProvider(Instance.Handshake)
+	peer.Service.OnHandshake(reactToHandshake)
+}
+
+// Ask the peers for their chain tips in a regular interval, so that
+// better chains are found even when we missed their blocks.
+// The requests are stopped when the given context is done.
+func RunContinuousChainTipsRequests(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(chainTipsRequestInterval):
+		}
+		BroadcastChainTipsRequest()
+	}
 }
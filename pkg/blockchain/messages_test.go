@@ -0,0 +1,52 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/peerbridge/peerbridge/pkg/peer"
+)
+
+func TestDecodeLegacyMessage(t *testing.T) {
+	cases := map[string]peer.MessageType{
+		`{"newTransaction":{"id":"a"}}`: NewTransactionMessageType,
+		`{"newBlock":{"id":"a"}}`:       NewBlockMessageType,
+		`{"blockID":"a"}`:               ResolveBlockRequestMessageType,
+		`{"resolvedBlock":{"id":"a"}}`:  ResolveBlockResponseMessageType,
+		`{"requestChainTips":true}`:     ChainTipsRequestMessageType,
+		`{"chainTips":[]}`:              ChainTipsResponseMessageType,
+	}
+	for message, expected := range cases {
+		got, ok := decodeLegacyMessage([]byte(message))
+		if !ok || got != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, message, got)
+		}
+	}
+
+	for _, message := range []string{`{"newBlock":null}`, `{"unknown":1}`, `[]`, `not json`} {
+		if _, ok := decodeLegacyMessage([]byte(message)); ok {
+			t.Errorf("Expected %s to be rejected", message)
+		}
+	}
+}
+
+func TestReportPeerDataPenalizesDirectSenders(t *testing.T) {
+	score := func(id string) int {
+		for _, s := range peer.Service.Scores() {
+			if s.ID == id {
+				return s.Score
+			}
+		}
+		return 0
+	}
+	forwarder := "QmRKEapfMSqsyKPdc4fBGsXR7ULrXCsJ1eKKScycQ1GkW8"
+	sender := "QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN"
+
+	reportPeerData(forwarder, true, true, ErrMalformedBlock)
+	if s := score(forwarder); s != 0 {
+		t.Errorf("Expected the forwarder not to be penalized, got score %d", s)
+	}
+	reportPeerData(sender, false, true, ErrMalformedBlock)
+	if s := score(sender); s != peer.PenaltyInvalidData {
+		t.Errorf("Expected score %d for the sender, got %d", peer.PenaltyInvalidData, s)
+	}
+}
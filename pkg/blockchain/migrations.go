@@ -0,0 +1,343 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/peerbridge/peerbridge/pkg/color"
+)
+
+// A lock id that is used to ensure that only one node
+// migrates a shared database at the same time.
+const migrationLockID = 7_235_001
+
+var (
+	ErrUnknownSchemaVersion  = errors.New("Unknown schema version!")
+	ErrIrreversibleMigration = errors.New("The migration cannot be reverted!")
+)
+
+// A versioned migration of the database schema.
+// Every migration can be applied (`Up`) and reverted (`Down`).
+// Migrations must never be changed once they are released,
+// instead a new migration with a higher version is added.
+type Migration struct {
+	// The version of the schema after this migration was applied.
+	Version int
+
+	// A short description of the migration.
+	Name string
+
+	// Apply the migration inside the given database transaction.
+	Up func(tx *pg.Tx) error
+
+	// Revert the migration inside the given database transaction.
+	Down func(tx *pg.Tx) error
+}
+
+// A record of an applied migration in the database.
+type SchemaMigration struct {
+	tableName struct{} `pg:"schema_migrations"`
+
+	// The version of the applied migration.
+	Version int `pg:",pk,use_zero"`
+
+	// The name of the applied migration.
+	Name string `pg:",notnull"`
+
+	// The time when the migration was applied.
+	AppliedAt time.Time `pg:",notnull,default:now()"`
+}
+
+// Create a migration function which executes the given sql statements.
+func execSQL(statements ...string) func(tx *pg.Tx) error {
+	return func(tx *pg.Tx) error {
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// A migration function for migrations which cannot be reverted.
+func irreversible(tx *pg.Tx) error {
+	return ErrIrreversibleMigration
+}
+
+// All migrations of the database schema, ordered by version.
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create blocks and transactions",
+		// Note: `IF NOT EXISTS` is used so that databases which were
+		// created before the introduction of migrations are adopted.
+		Up: execSQL(
+			`CREATE TABLE IF NOT EXISTS "blocks" (
+				"id" text NOT NULL UNIQUE,
+				"parent_id" text,
+				"height" bigint NOT NULL,
+				"time_unix_nano" bigint NOT NULL,
+				"creator" text NOT NULL,
+				"target" bigint NOT NULL,
+				"challenge" text NOT NULL,
+				"cumulative_difficulty" bigint NOT NULL,
+				"signature" text NOT NULL,
+				PRIMARY KEY ("id")
+			)`,
+			`CREATE TABLE IF NOT EXISTS "transactions" (
+				"id" text NOT NULL,
+				"sender" text NOT NULL,
+				"receiver" text NOT NULL,
+				"balance" bigint NOT NULL,
+				"time_unix_nano" bigint NOT NULL,
+				"data" bytea,
+				"fee" bigint NOT NULL,
+				"signature" text NOT NULL,
+				"block_id" text NOT NULL,
+				PRIMARY KEY ("id", "block_id")
+			)`,
+		),
+		// Reverting this migration would drop the whole chain,
+		// so the tables have to be dropped manually if needed.
+		Down: irreversible,
+	},
+	{
+		Version: 2,
+		Name:    "allow transactions in blocks of competing forks",
+		// Older databases enforced a unique transaction id,
+		// which made it impossible to store the same transaction
+		// in two blocks on different forks.
+		Up: execSQL(
+			`DO $$
+			DECLARE c record;
+			BEGIN
+				FOR c IN
+					SELECT conname
+					FROM pg_constraint
+					WHERE conrelid = 'transactions'::regclass AND contype = 'u'
+				LOOP
+					EXECUTE format('ALTER TABLE "transactions" DROP CONSTRAINT %I', c.conname);
+				END LOOP;
+			END $$`,
+		),
+		// Note: this fails if a transaction is included in multiple blocks.
+		Down: execSQL(
+			`ALTER TABLE "transactions" ADD CONSTRAINT "transactions_id_key" UNIQUE ("id")`,
+		),
+	},
+	{
+		Version: 3,
+		Name:    "add indexes for block and transaction lookups",
+		Up: execSQL(
+			`CREATE INDEX IF NOT EXISTS "blocks_parent_id_idx" ON "blocks" ("parent_id")`,
+			`CREATE INDEX IF NOT EXISTS "blocks_creator_idx" ON "blocks" ("creator")`,
+			`CREATE INDEX IF NOT EXISTS "blocks_height_cumulative_difficulty_idx" ON "blocks" ("height" DESC, "cumulative_difficulty" DESC)`,
+			`CREATE INDEX IF NOT EXISTS "transactions_block_id_idx" ON "transactions" ("block_id")`,
+			`CREATE INDEX IF NOT EXISTS "transactions_sender_idx" ON "transactions" ("sender")`,
+			`CREATE INDEX IF NOT EXISTS "transactions_receiver_idx" ON "transactions" ("receiver")`,
+		),
+		Down: execSQL(
+			`DROP INDEX IF EXISTS "transactions_receiver_idx"`,
+			`DROP INDEX IF EXISTS "transactions_sender_idx"`,
+			`DROP INDEX IF EXISTS "transactions_block_id_idx"`,
+			`DROP INDEX IF EXISTS "blocks_height_cumulative_difficulty_idx"`,
+			`DROP INDEX IF EXISTS "blocks_creator_idx"`,
+			`DROP INDEX IF EXISTS "blocks_parent_id_idx"`,
+		),
+	},
+	{
+		Version: 4,
+		Name:    "add invalid blocks",
+		Up: execSQL(
+			`CREATE TABLE IF NOT EXISTS "invalid_blocks" (
+				"id" text NOT NULL,
+				"reason" text NOT NULL DEFAULT '',
+				"invalidated_at" timestamptz NOT NULL DEFAULT now(),
+				PRIMARY KEY ("id")
+			)`,
+		),
+		Down: execSQL(
+			`DROP TABLE IF EXISTS "invalid_blocks"`,
+		),
+	},
+	{
+		Version: 5,
+		Name:    "add transaction data hashes for pruning",
+		// Note: the data hashes of existing transactions are not
+		// computed, since only transactions that are inserted with
+		// a data hash can be pruned, see migration 7.
+		Up: execSQL(
+			`ALTER TABLE "transactions" ADD COLUMN IF NOT EXISTS "data_hash" text`,
+			`ALTER TABLE "transactions" ADD COLUMN IF NOT EXISTS "pruned" boolean NOT NULL DEFAULT false`,
+		),
+		Down: execSQL(
+			`ALTER TABLE "transactions" DROP COLUMN IF EXISTS "pruned"`,
+			`ALTER TABLE "transactions" DROP COLUMN IF EXISTS "data_hash"`,
+		),
+	},
+	{
+		Version: 6,
+		Name:    "add transaction positions",
+		// The positions of existing transactions are restored from the
+		// physical row order, which is the insertion order unless the
+		// rows were rewritten. Blocks whose signature cannot be verified
+		// afterwards are reported by the integrity check on startup.
+		Up: execSQL(
+			`ALTER TABLE "transactions" ADD COLUMN IF NOT EXISTS "position" integer NOT NULL DEFAULT 0`,
+			`UPDATE "transactions" t
+			SET "position" = p."position"
+			FROM (
+				SELECT ctid, row_number() OVER (PARTITION BY "block_id" ORDER BY ctid) - 1 AS "position"
+				FROM "transactions"
+			) p
+			WHERE t.ctid = p.ctid AND p."position" > 0`,
+		),
+		Down: execSQL(
+			`ALTER TABLE "transactions" DROP COLUMN IF EXISTS "position"`,
+		),
+	},
+	{
+		Version: 7,
+		Name:    "add transaction versions",
+		// Existing transactions are legacy transactions,
+		// whose signature covers the transaction data.
+		Up: execSQL(
+			`ALTER TABLE "transactions" ADD COLUMN IF NOT EXISTS "version" integer NOT NULL DEFAULT 0`,
+		),
+		Down: execSQL(
+			`ALTER TABLE "transactions" DROP COLUMN IF EXISTS "version"`,
+		),
+	},
+}
+
+// Get the latest known schema version.
+func LatestSchemaVersion() int {
+	if len(Migrations) == 0 {
+		return 0
+	}
+	return Migrations[len(Migrations)-1].Version
+}
+
+// Create the table that keeps track of applied migrations.
+func (r *BlockRepo) initSchemaMigrations() error {
+	_, err := r.DB.Exec(`CREATE TABLE IF NOT EXISTS "schema_migrations" (
+		"version" bigint NOT NULL,
+		"name" text NOT NULL,
+		"applied_at" timestamptz NOT NULL DEFAULT now(),
+		PRIMARY KEY ("version")
+	)`)
+	return err
+}
+
+// Get the current schema version of the database.
+// A database without applied migrations has version 0.
+func (r *BlockRepo) SchemaVersion() (int, error) {
+	err := r.initSchemaMigrations()
+	if err != nil {
+		return 0, err
+	}
+	return schemaVersion(r.DB)
+}
+
+func schemaVersion(db pg.DBI) (int, error) {
+	var version int
+	_, err := db.QueryOne(
+		pg.Scan(&version),
+		`SELECT COALESCE(MAX("version"), 0) FROM "schema_migrations"`,
+	)
+	return version, err
+}
+
+// Migrate the database schema to the latest version.
+func (r *BlockRepo) MigrateToLatest() error {
+	return r.Migrate(LatestSchemaVersion())
+}
+
+// Migrate the database schema to the given version.
+// Depending on the current version, this applies or reverts
+// migrations. Every migration is run in its own database
+// transaction, together with the bookkeeping of its version.
+func (r *BlockRepo) Migrate(target int) error {
+	if target < 0 || target > LatestSchemaVersion() {
+		return ErrUnknownSchemaVersion
+	}
+
+	err := r.initSchemaMigrations()
+	if err != nil {
+		return err
+	}
+
+	for {
+		done := false
+		err := r.DB.RunInTransaction(context.Background(), func(tx *pg.Tx) error {
+			// Serialize migrations of nodes sharing the database
+			if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(?)`, migrationLockID); err != nil {
+				return err
+			}
+
+			current, err := schemaVersion(tx)
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case current < target:
+				return applyMigration(tx, current)
+			case current > target:
+				return revertMigration(tx, current)
+			default:
+				done = true
+				return nil
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// Apply the next migration after the given version.
+func applyMigration(tx *pg.Tx, current int) error {
+	for _, m := range Migrations {
+		if m.Version <= current {
+			continue
+		}
+		if err := m.Up(tx); err != nil {
+			return fmt.Errorf("Migration %d (%s) failed: %s", m.Version, m.Name, err)
+		}
+		_, err := tx.Model(&SchemaMigration{Version: m.Version, Name: m.Name}).Insert()
+		if err != nil {
+			return err
+		}
+		log.Println(color.Sprintf(fmt.Sprintf("Applied migration %d (%s).", m.Version, m.Name), color.Success))
+		return nil
+	}
+	return ErrUnknownSchemaVersion
+}
+
+// Revert the migration with the given version.
+func revertMigration(tx *pg.Tx, current int) error {
+	for _, m := range Migrations {
+		if m.Version != current {
+			continue
+		}
+		if err := m.Down(tx); err != nil {
+			return fmt.Errorf("Reverting migration %d (%s) failed: %s", m.Version, m.Name, err)
+		}
+		_, err := tx.Model((*SchemaMigration)(nil)).Where("version = ?", m.Version).Delete()
+		if err != nil {
+			return err
+		}
+		log.Println(color.Sprintf(fmt.Sprintf("Reverted migration %d (%s).", m.Version, m.Name), color.Warning))
+		return nil
+	}
+	return ErrUnknownSchemaVersion
+}
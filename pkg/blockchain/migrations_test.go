@@ -0,0 +1,27 @@
+package blockchain
+
+import "testing"
+
+func TestMigrationsAreContiguous(t *testing.T) {
+	for i, m := range Migrations {
+		if m.Version != i+1 {
+			t.Errorf("Expected migration %d to have version %d, got %d", i, i+1, m.Version)
+		}
+		if m.Name == "" {
+			t.Errorf("Expected migration %d to have a name", m.Version)
+		}
+		if m.Up == nil || m.Down == nil {
+			t.Errorf("Expected migration %d to have up and down functions", m.Version)
+		}
+	}
+
+	if LatestSchemaVersion() != len(Migrations) {
+		t.Errorf("Expected latest schema version %d, got %d", len(Migrations), LatestSchemaVersion())
+	}
+}
+
+func TestInitialMigrationIsIrreversible(t *testing.T) {
+	if err := Migrations[0].Down(nil); err != ErrIrreversibleMigration {
+		t.Errorf("Expected the initial migration to be irreversible, got %v", err)
+	}
+}
@@ -0,0 +1,263 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/peerbridge/peerbridge/pkg/encryption"
+)
+
+const (
+	// The maximum number of orphan blocks that are kept.
+	MaxOrphanBlocks = 256
+
+	// The maximum number of orphan blocks that are kept per source.
+	MaxOrphanBlocksPerSource = 64
+
+	// The time after which an orphan block is dropped,
+	// if its parent did not arrive in the meantime.
+	OrphanBlockExpiry = 10 * time.Minute
+
+	// The minimum time between two requests for the same parent.
+	orphanParentRequestInterval = 30 * time.Second
+
+	// The interval in which expired orphan blocks are dropped.
+	orphanExpiryInterval = time.Minute
+
+	// The orphan sources of blocks which are imported from
+	// a bootstrap file, and of blocks minted by this node.
+	// Peer sources are peer ids, so these cannot collide.
+	orphanSourceImport = "<import>"
+	orphanSourceLocal  = "<local>"
+)
+
+var (
+	ErrOrphanQuotaExceeded = errors.New("Too many orphan blocks from the same source!")
+)
+
+// A block whose parent is not known yet.
+type orphanBlock struct {
+	// The orphan block.
+	block Block
+
+	// The source from which the block was received.
+	source string
+
+	// The time at which the block was added to the pool.
+	addedAt time.Time
+}
+
+// A bounded pool of blocks whose parent is not known yet.
+// The orphans are indexed by their parent id, so that the
+// children of a newly inserted block are found directly.
+type OrphanPool struct {
+	// The orphan blocks by their id.
+	orphans map[encryption.SHA256HexString]*orphanBlock
+
+	// The ids of the orphan blocks by their parent id.
+	children map[encryption.SHA256HexString][]encryption.SHA256HexString
+
+	// The number of orphan blocks per source.
+	sources map[string]int
+
+	// The time at which a parent was last requested.
+	requests map[encryption.SHA256HexString]time.Time
+
+	// The maximum number of orphan blocks in total.
+	maxSize int
+
+	// The maximum number of orphan blocks per source.
+	maxPerSource int
+
+	// The time after which orphan blocks are dropped.
+	expiry time.Duration
+
+	// A lock to make the pool safe for concurrent use.
+	lock sync.Mutex
+}
+
+// Create a new orphan pool with the given limits.
+func NewOrphanPool(maxSize, maxPerSource int, expiry time.Duration) *OrphanPool {
+	return &OrphanPool{
+		orphans:      map[encryption.SHA256HexString]*orphanBlock{},
+		children:     map[encryption.SHA256HexString][]encryption.SHA256HexString{},
+		sources:      map[string]int{},
+		requests:     map[encryption.SHA256HexString]time.Time{},
+		maxSize:      maxSize,
+		maxPerSource: maxPerSource,
+		expiry:       expiry,
+	}
+}
+
+// Get the number of orphan blocks in the pool.
+func (pool *OrphanPool) Len() int {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	return len(pool.orphans)
+}
+
+// Check if the pool contains an orphan block with the given id.
+func (pool *OrphanPool) Contains(id encryption.SHA256HexString) bool {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	_, ok := pool.orphans[id]
+	return ok
+}
+
+// Add an orphan block, which was received from the given source.
+// If the pool is full, the oldest orphan block is dropped.
+// Returns `ErrOrphanQuotaExceeded` if the source has too many
+// orphan blocks in the pool.
+func (pool *OrphanPool) Add(b *Block, source string) error {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	pool.expire(time.Now())
+
+	if _, ok := pool.orphans[b.ID]; ok {
+		return nil
+	}
+	if pool.sources[source] >= pool.maxPerSource {
+		return ErrOrphanQuotaExceeded
+	}
+	if len(pool.orphans) >= pool.maxSize {
+		var oldest *orphanBlock
+		for _, o := range pool.orphans {
+			if oldest == nil || o.addedAt.Before(oldest.addedAt) {
+				oldest = o
+			}
+		}
+		pool.remove(oldest.block.ID)
+	}
+
+	pool.orphans[b.ID] = &orphanBlock{
+		block:   *b,
+		source:  source,
+		addedAt: time.Now(),
+	}
+	pool.children[*b.ParentID] = append(pool.children[*b.ParentID], b.ID)
+	pool.sources[source]++
+	return nil
+}
+
+// Remove and return the orphan blocks whose parent has the given id.
+func (pool *OrphanPool) TakeChildren(parentID encryption.SHA256HexString) []Block {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	children := []Block{}
+	for _, id := range pool.children[parentID] {
+		if o, ok := pool.orphans[id]; ok {
+			children = append(children, o.block)
+			pool.remove(id)
+		}
+	}
+	delete(pool.children, parentID)
+	delete(pool.requests, parentID)
+	return children
+}
+
+// Remove all orphan blocks that descend from the block with
+// the given id. Returns the number of removed blocks.
+func (pool *OrphanPool) RemoveDescendants(id encryption.SHA256HexString) int {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	removed := 0
+	queue := []encryption.SHA256HexString{id}
+	for len(queue) > 0 {
+		parentID := queue[0]
+		queue = queue[1:]
+		for _, childID := range pool.children[parentID] {
+			if _, ok := pool.orphans[childID]; ok {
+				pool.remove(childID)
+				removed++
+				queue = append(queue, childID)
+			}
+		}
+		delete(pool.children, parentID)
+		delete(pool.requests, parentID)
+	}
+	return removed
+}
+
+// Check if the parent with the given id should be requested from
+// the peers. Parents are requested at most once per interval, and
+// only if they are not orphans themselves, since in that case an
+// ancestor is already requested.
+func (pool *OrphanPool) ShouldRequestParent(parentID encryption.SHA256HexString) bool {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	if _, ok := pool.orphans[parentID]; ok {
+		return false
+	}
+	if requestedAt, ok := pool.requests[parentID]; ok &&
+		time.Since(requestedAt) < orphanParentRequestInterval {
+		return false
+	}
+	pool.requests[parentID] = time.Now()
+	return true
+}
+
+// Drop all orphan blocks that are older than the expiry.
+func (pool *OrphanPool) Expire() {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	pool.expire(time.Now())
+}
+
+// Drop expired orphan blocks in a regular interval,
+// until the given context is done.
+func (pool *OrphanPool) RunContinuousExpiry(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(orphanExpiryInterval):
+		}
+		pool.Expire()
+	}
+}
+
+func (pool *OrphanPool) expire(now time.Time) {
+	for id, o := range pool.orphans {
+		if now.Sub(o.addedAt) > pool.expiry {
+			pool.remove(id)
+		}
+	}
+	for parentID, requestedAt := range pool.requests {
+		if now.Sub(requestedAt) > pool.expiry {
+			delete(pool.requests, parentID)
+		}
+	}
+}
+
+// Remove a single orphan block. The lock must be held.
+func (pool *OrphanPool) remove(id encryption.SHA256HexString) {
+	o, ok := pool.orphans[id]
+	if !ok {
+		return
+	}
+	delete(pool.orphans, id)
+
+	parentID := *o.block.ParentID
+	siblings := []encryption.SHA256HexString{}
+	for _, siblingID := range pool.children[parentID] {
+		if siblingID != id {
+			siblings = append(siblings, siblingID)
+		}
+	}
+	if len(siblings) == 0 {
+		delete(pool.children, parentID)
+	} else {
+		pool.children[parentID] = siblings
+	}
+
+	pool.sources[o.source]--
+	if pool.sources[o.source] <= 0 {
+		delete(pool.sources, o.source)
+	}
+}
@@ -0,0 +1,95 @@
+package blockchain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/peerbridge/peerbridge/pkg/encryption"
+)
+
+// Create a block with a random id on top of the given parent.
+func orphanTestBlock(t *testing.T, parentID encryption.SHA256HexString) *Block {
+	id, err := encryption.RandomSHA256HexString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Block{ID: *id, ParentID: &parentID}
+}
+
+func TestOrphanPoolResolvesChildren(t *testing.T) {
+	pool := NewOrphanPool(10, 10, time.Minute)
+	root := encryption.ZeroSHA256HexString()
+	child := orphanTestBlock(t, root)
+	grandchild := orphanTestBlock(t, child.ID)
+	sibling := orphanTestBlock(t, root)
+
+	for _, b := range []*Block{child, grandchild, sibling} {
+		if err := pool.Add(b, "peer"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pool.Len() != 3 {
+		t.Fatalf("Expected 3 orphans, got %d", pool.Len())
+	}
+
+	children := pool.TakeChildren(root)
+	if len(children) != 2 {
+		t.Fatalf("Expected 2 children, got %d", len(children))
+	}
+	if pool.Contains(child.ID) || pool.Contains(sibling.ID) || !pool.Contains(grandchild.ID) {
+		t.Errorf("Expected only the grandchild to remain")
+	}
+
+	if n := pool.RemoveDescendants(child.ID); n != 1 || pool.Len() != 0 {
+		t.Errorf("Expected the grandchild to be removed, removed %d", n)
+	}
+}
+
+func TestOrphanPoolLimits(t *testing.T) {
+	pool := NewOrphanPool(3, 2, time.Minute)
+	root := encryption.ZeroSHA256HexString()
+
+	first := orphanTestBlock(t, root)
+	pool.Add(first, "a")
+	pool.Add(orphanTestBlock(t, root), "a")
+	if err := pool.Add(orphanTestBlock(t, root), "a"); err != ErrOrphanQuotaExceeded {
+		t.Errorf("Expected %s, got %v", ErrOrphanQuotaExceeded, err)
+	}
+
+	pool.Add(orphanTestBlock(t, root), "b")
+	pool.Add(orphanTestBlock(t, root), "b")
+	if pool.Len() != 3 {
+		t.Errorf("Expected the pool to be capped at 3, got %d", pool.Len())
+	}
+	if pool.Contains(first.ID) {
+		t.Errorf("Expected the oldest orphan to be evicted")
+	}
+}
+
+func TestOrphanPoolExpiry(t *testing.T) {
+	pool := NewOrphanPool(10, 10, time.Millisecond)
+	b := orphanTestBlock(t, encryption.ZeroSHA256HexString())
+	pool.Add(b, "peer")
+	time.Sleep(5 * time.Millisecond)
+	pool.Expire()
+	if pool.Contains(b.ID) {
+		t.Errorf("Expected the orphan to expire")
+	}
+}
+
+func TestOrphanPoolParentRequests(t *testing.T) {
+	pool := NewOrphanPool(10, 10, time.Minute)
+	root := encryption.ZeroSHA256HexString()
+	child := orphanTestBlock(t, root)
+	pool.Add(child, "peer")
+
+	if !pool.ShouldRequestParent(root) {
+		t.Errorf("Expected the first parent request to be sent")
+	}
+	if pool.ShouldRequestParent(root) {
+		t.Errorf("Expected the repeated parent request to be deduplicated")
+	}
+	if pool.ShouldRequestParent(child.ID) {
+		t.Errorf("Expected no request for a parent that is an orphan itself")
+	}
+}
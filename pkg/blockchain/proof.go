@@ -1,6 +1,8 @@
 package blockchain
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"math/big"
 
@@ -9,6 +11,8 @@
 
 var (
 	ErrProofHitAboveUpperBound = errors.New("Hit is above the upper bound!")
+	ErrBlockTimeBeforeParent   = errors.New("Block was created before its parent!")
+	ErrTargetTooLow            = errors.New("Block target is too low!")
 )
 
 // A block proof of stake.
@@ -33,3 +37,59 @@ func (proof *Proof) Validate() error {
 	// The hit is below the upper bound
 	return nil
 }
+
+// Calculate the challenge of a block, by hashing the
+// block creator public key together with the parent
+// block challenge using the SHA256 hashing algorithm.
+func calculateChallenge(
+	creator, parentChallenge string,
+) (*[encryption.SHA256ByteLength]byte, error) {
+	challengeHasher := sha256.New()
+	hexCreatorBytes, err := hex.DecodeString(creator)
+	if err != nil {
+		return nil, err
+	}
+	previousChallengeBytes, err := hex.DecodeString(parentChallenge)
+	if err != nil {
+		return nil, err
+	}
+	challengeHasher.Write(hexCreatorBytes)
+	challengeHasher.Write(previousChallengeBytes)
+	var challengeBytes [encryption.SHA256ByteLength]byte
+	copy(
+		challengeBytes[:],
+		challengeHasher.Sum(nil)[:encryption.SHA256ByteLength],
+	)
+	return &challengeBytes, nil
+}
+
+// Calculate the target and the cumulative difficulty of a block,
+// given the target and cumulative difficulty of its parent and
+// the nanoseconds that passed since the parent was created.
+func calculateTarget(
+	parentTarget, parentCumulativeDifficulty uint64, ns int64,
+) (uint64, uint64, error) {
+	if ns <= 0 {
+		return 0, 0, ErrBlockTimeBeforeParent
+	}
+
+	// New Block Target = (Tp * ns) / (1 * 10^9)
+	Tn := new(big.Int)
+	Tn = Tn.Mul(new(big.Int).SetUint64(parentTarget), new(big.Int).SetInt64(ns))
+	// TODO: Prevent possible overflows
+	Tn = Tn.Div(Tn, new(big.Int).SetInt64(1_000_000_000))
+	if Tn.Sign() <= 0 {
+		return 0, 0, ErrTargetTooLow
+	}
+
+	// New Block Cumulative Difficulty = Dp + (pot / Tn)
+	// Where Pot = 2^64
+	CD := new(big.Int)
+	var pot uint64 = 1 << 63
+	Dp := new(big.Int).SetUint64(parentCumulativeDifficulty)
+	CD = CD.Div(new(big.Int).SetUint64(pot), Tn) // pot / Tn
+	// TODO: Prevent possible overflows
+	CD = CD.Add(Dp, CD) // Dp + (pot / Tn)
+
+	return Tn.Uint64(), CD.Uint64(), nil
+}
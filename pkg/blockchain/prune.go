@@ -0,0 +1,132 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/peerbridge/peerbridge/pkg/color"
+)
+
+const (
+	// The minimum depth below the main chain head from which
+	// transaction data may be pruned. Blocks above this depth
+	// must be kept complete to serve them to other peers.
+	MinPruneDepth = 128
+
+	// The interval in which pruning is run.
+	pruneInterval = 1 * time.Minute
+)
+
+var (
+	ErrPruneDepthTooLow = fmt.Errorf("The prune depth must be at least %d!", MinPruneDepth)
+	ErrBlockPruned      = errors.New("Block data was pruned!")
+)
+
+// Remove the data of main chain transactions whose block is at
+// least `depth` blocks below the main chain head. The data hashes
+// of pruned transactions are kept. Only prunable transactions are
+// pruned, whose signature covers the data hash instead of the data.
+// Returns the number of pruned transactions.
+func (r *BlockRepo) PruneMainChainTransactionData(depth uint64) (int, error) {
+	head, err := r.GetMainChainEndpoint()
+	if err != nil {
+		return 0, err
+	}
+	height, ok := pruneHeight(head.Height, depth)
+	if !ok {
+		return 0, nil
+	}
+
+	res, err := r.DB.Exec(fmt.Sprintf(`
+		%s
+
+		UPDATE transactions t
+		SET data = NULL, pruned = true
+		FROM main_chain c
+		WHERE t.block_id = c.id
+		AND c.height <= ?
+		AND t.version >= ?
+		AND t.data IS NOT NULL;
+	`, mainChainPartialQuery), height, TransactionVersionDataHash)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
+// Get the height up to which transaction data is pruned, given
+// the height of the main chain head and the prune depth. Returns
+// false if the main chain is not deep enough to be pruned.
+func pruneHeight(head, depth uint64) (uint64, bool) {
+	if head < depth {
+		return 0, false
+	}
+	return head - depth, true
+}
+
+// Get the height of the highest block with pruned transaction data.
+// Returns `nil` if no transaction data was pruned.
+func (r *BlockRepo) GetPrunedHeight() (*uint64, error) {
+	var height *uint64
+	_, err := r.DB.QueryOne(pg.Scan(&height), `
+		SELECT MAX(b.height)
+		FROM transactions t
+		INNER JOIN blocks b ON t.block_id = b.id
+		WHERE t.pruned;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return height, nil
+}
+
+// Enable the pruning of old transaction data.
+// A depth of 0 disables pruning (archival mode).
+func (chain *Blockchain) EnablePruning(depth uint64) error {
+	if depth != 0 && depth < MinPruneDepth {
+		return ErrPruneDepthTooLow
+	}
+	chain.pruneDepth = depth
+	return nil
+}
+
+// Check if this node prunes old transaction data.
+func (chain *Blockchain) IsPruning() bool {
+	return chain.pruneDepth > 0
+}
+
+// Get the depth below the main chain head from which
+// transaction data is pruned. This is 0 for archival nodes.
+func (chain *Blockchain) PruneDepth() uint64 {
+	return chain.pruneDepth
+}
+
+// Run a scheduled pruning loop until the given
+// context is done, if pruning is enabled.
+func (chain *Blockchain) RunContinuousPruning(ctx context.Context) {
+	if !chain.IsPruning() {
+		return
+	}
+
+	log.Println(color.Sprintf(fmt.Sprintf(
+		"Pruning transaction data older than %d blocks.", chain.pruneDepth,
+	), color.Notice))
+
+	for {
+		n, err := Repo.PruneMainChainTransactionData(chain.pruneDepth)
+		if err != nil {
+			log.Println(color.Sprintf(fmt.Sprintf("Pruning failed: %s", err), color.Error))
+		} else if n > 0 {
+			log.Printf("Pruned the data of %s transaction(s)\n", color.Sprintf(fmt.Sprintf("%d", n), color.Info))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pruneInterval):
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package blockchain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/peerbridge/peerbridge/pkg/encryption"
+	"github.com/peerbridge/peerbridge/pkg/encryption/secp256k1"
+)
+
+// Create a block with a single signed transaction of the given version.
+func signedBlockWithData(t *testing.T, version int) *Block {
+	id, err := encryption.RandomSHA256HexString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("payload")
+	txn := Transaction{
+		ID:           *id,
+		Sender:       GenesisKeyPair.PublicKey,
+		Receiver:     GenesisKeyPair.PublicKey,
+		Balance:      1,
+		TimeUnixNano: 1,
+		Data:         &data,
+		Version:      version,
+	}
+	txn.Signature, err = secp256k1.ComputeSignature(&txn, GenesisKeyPair.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &Block{
+		ID:           *id,
+		ParentID:     &GenesisAddress,
+		Height:       1,
+		Transactions: []Transaction{txn},
+		Creator:      GenesisKeyPair.PublicKey,
+		Challenge:    GenesisChallenge,
+	}
+	b.Signature, err = secp256k1.ComputeSignature(b, GenesisKeyPair.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// Prune the transaction data of a block in the same way as the repository.
+func pruneBlock(b *Block) {
+	for i := range b.Transactions {
+		t := &b.Transactions[i]
+		t.DataHash = t.ComputeDataHash()
+		t.Data = nil
+		t.Pruned = true
+	}
+}
+
+func TestPrunedBlocksCanBeVerified(t *testing.T) {
+	b := signedBlockWithData(t, TransactionVersionDataHash)
+	pruneBlock(b)
+
+	if !b.IsPruned() {
+		t.Fatal("Expected the block to be pruned")
+	}
+	if err := b.VerifySignatures(); err != nil {
+		t.Errorf("Expected the signatures of the pruned block to be valid, got %s", err)
+	}
+
+	// Pruned transactions are not accepted in new blocks
+	if err := (&Blockchain{}).ValidateTransaction(&b.Transactions[0]); err != ErrTransactionPruned {
+		t.Errorf("Expected %s, got %v", ErrTransactionPruned, err)
+	}
+}
+
+func TestPrunedDataHashIsSigned(t *testing.T) {
+	b := signedBlockWithData(t, TransactionVersionDataHash)
+	pruneBlock(b)
+
+	forged := encryption.HashToSHA256HexString([]byte("forged"))
+	b.Transactions[0].DataHash = &forged
+	if err := b.VerifySignatures(); err == nil {
+		t.Error("Expected the signatures to be invalid with a forged data hash")
+	}
+}
+
+func TestLegacyTransactionsCannotBePruned(t *testing.T) {
+	b := signedBlockWithData(t, TransactionVersionLegacy)
+	if err := b.VerifySignatures(); err != nil {
+		t.Fatalf("Expected the signatures of the legacy block to be valid, got %s", err)
+	}
+	if b.Transactions[0].IsPrunable() {
+		t.Error("Expected legacy transactions not to be prunable")
+	}
+
+	pruneBlock(b)
+	if err := b.VerifySignatures(); err != ErrTransactionPruned {
+		t.Errorf("Expected %s, got %v", ErrTransactionPruned, err)
+	}
+}
+
+func TestPruneDepth(t *testing.T) {
+	chain := &Blockchain{}
+	if err := chain.EnablePruning(MinPruneDepth - 1); err != ErrPruneDepthTooLow {
+		t.Errorf("Expected %s, got %v", ErrPruneDepthTooLow, err)
+	}
+	if chain.IsPruning() {
+		t.Error("Expected the chain not to prune with a rejected depth")
+	}
+	if err := chain.EnablePruning(MinPruneDepth); err != nil || !chain.IsPruning() {
+		t.Errorf("Expected the chain to prune with depth %d, got %v", MinPruneDepth, err)
+	}
+	if err := chain.EnablePruning(0); err != nil || chain.IsPruning() {
+		t.Errorf("Expected depth 0 to disable pruning, got %v", err)
+	}
+}
+
+func TestPruneHeight(t *testing.T) {
+	cases := []struct {
+		head, depth uint64
+		height      uint64
+		ok          bool
+	}{
+		{head: 100, depth: 128, ok: false},
+		{head: 128, depth: 128, height: 0, ok: true},
+		{head: 1000, depth: 128, height: 872, ok: true},
+	}
+	for _, c := range cases {
+		height, ok := pruneHeight(c.head, c.depth)
+		if ok != c.ok || height != c.height {
+			t.Errorf(
+				"Expected prune height (%d, %t) for head %d and depth %d, got (%d, %t)",
+				c.height, c.ok, c.head, c.depth, height, ok,
+			)
+		}
+	}
+}
+
+func TestGoneIfPruned(t *testing.T) {
+	complete := signedBlockWithData(t, TransactionVersionDataHash)
+	pruned := signedBlockWithData(t, TransactionVersionDataHash)
+	pruneBlock(pruned)
+
+	w := httptest.NewRecorder()
+	if goneIfPruned(w, []Block{*complete}) {
+		t.Error("Expected no response for complete blocks")
+	}
+
+	w = httptest.NewRecorder()
+	if !goneIfPruned(w, []Block{*complete, *pruned}) {
+		t.Fatal("Expected a response for pruned blocks")
+	}
+	if w.Code != http.StatusGone {
+		t.Errorf("Expected status %d, got %d", http.StatusGone, w.Code)
+	}
+}
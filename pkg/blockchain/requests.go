@@ -0,0 +1,168 @@
+package blockchain
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/peerbridge/peerbridge/pkg/encryption"
+	"github.com/peerbridge/peerbridge/pkg/peer"
+)
+
+// The types of the blockchain peer requests.
+const (
+	GetChainTipsRequestType  peer.MessageType = "blockchain/getChainTips"
+	LocateHeadersRequestType peer.MessageType = "blockchain/locateHeaders"
+	GetBlocksRequestType     peer.MessageType = "blockchain/getBlocks"
+)
+
+// A peer from which blocks can be synced via directed requests.
+// Only peers which completed the handshake can be requested.
+type PeerRemote struct {
+	// The id of the peer.
+	ID string
+}
+
+// Create a new remote for the peer with the given id.
+func NewPeerRemote(id string) *PeerRemote {
+	return &PeerRemote{ID: id}
+}
+
+func (r *PeerRemote) String() string {
+	return fmt.Sprintf("peer %s", r.ID)
+}
+
+// Send a request to the peer. Errors with which the peer
+// responds are translated into the given known errors.
+func (r *PeerRemote) request(t peer.MessageType, request interface{}, response interface{}, known ...error) error {
+	err := peer.Service.Request(r.ID, t, request, response)
+	if remoteErr, ok := err.(peer.RemoteError); ok {
+		for _, k := range known {
+			if remoteErr.Is(k) {
+				return k
+			}
+		}
+	}
+	if err == peer.ErrMessageNotDecodable {
+		return ErrMalformedResponse
+	}
+	return err
+}
+
+func (r *PeerRemote) GetChainTips() (*[]ChainTip, error) {
+	var body GetChainTipsResponse
+	err := r.request(GetChainTipsRequestType, struct{}{}, &body, ErrChainTipsNotFound)
+	if err != nil {
+		return nil, err
+	}
+	if body.Tips == nil {
+		return nil, ErrMalformedResponse
+	}
+	return body.Tips, nil
+}
+
+func (r *PeerRemote) LocateHeaders(locator []encryption.SHA256HexString, limit int) (*LocateHeadersResponse, error) {
+	var body LocateHeadersResponse
+	err := r.request(LocateHeadersRequestType, LocateHeadersRequest{locator, limit}, &body, ErrNoCommonBlock)
+	if err != nil {
+		return nil, err
+	}
+	if body.ForkPoint == nil || body.Headers == nil {
+		return nil, ErrMalformedResponse
+	}
+	return &body, nil
+}
+
+func (r *PeerRemote) GetBlocks(ids []encryption.SHA256HexString) (*[]Block, error) {
+	var body GetBlocksResponse
+	err := r.request(GetBlocksRequestType, GetBlocksRequest{ids}, &body, ErrBlockNotFound, ErrBlockPruned)
+	if err != nil {
+		return nil, err
+	}
+	if body.Blocks == nil {
+		return nil, ErrMalformedResponse
+	}
+	return body.Blocks, nil
+}
+
+func handleGetChainTipsRequest(envelope *peer.Envelope) (interface{}, error) {
+	tips, err := Repo.GetChainTips()
+	if err != nil {
+		return nil, err
+	}
+	return GetChainTipsResponse{tips}, nil
+}
+
+func handleLocateHeadersRequest(envelope *peer.Envelope) (interface{}, error) {
+	var request LocateHeadersRequest
+	if err := envelope.Decode(&request); err != nil || len(request.Locator) == 0 {
+		return nil, ErrMalformedMessage
+	}
+	if len(request.Locator) > MaxLocatorLength {
+		return nil, ErrLocatorTooLong
+	}
+
+	// The query only reads the main chain, so it does not need the chain lock
+	forkPoint, headers, err := Repo.GetMainChainHeadersAfterLocator(
+		request.Locator, request.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	tip := NewChainTip(forkPoint)
+	return LocateHeadersResponse{&tip, headers}, nil
+}
+
+func handleGetBlocksRequest(envelope *peer.Envelope) (interface{}, error) {
+	var request GetBlocksRequest
+	if err := envelope.Decode(&request); err != nil {
+		return nil, ErrMalformedMessage
+	}
+	if len(request.IDs) == 0 || len(request.IDs) > MaxBlocksPerRequest {
+		return nil, ErrMalformedMessage
+	}
+
+	blocks, err := Repo.GetBlocksByIDs(request.IDs)
+	if err != nil {
+		return nil, err
+	}
+	if len(*blocks) != len(request.IDs) {
+		return nil, ErrBlockNotFound
+	}
+	for _, b := range *blocks {
+		if b.IsPruned() {
+			return nil, ErrBlockPruned
+		}
+	}
+	limited, err := limitBlocksResponse(*blocks)
+	if err != nil {
+		return nil, err
+	}
+	return GetBlocksResponse{&limited}, nil
+}
+
+// Resolve a missing block by requesting it from the given peer first,
+// and then from the other peers which completed the handshake. The
+// request is only broadcasted to older peers, if none of them has it.
+func resolveBlock(id encryption.SHA256HexString, remote string) {
+	candidates := []string{}
+	if remote != "" {
+		candidates = append(candidates, remote)
+	}
+	for _, h := range peer.Service.SyncCandidates() {
+		if h.PeerID != remote {
+			candidates = append(candidates, h.PeerID)
+		}
+	}
+
+	for _, candidate := range candidates {
+		blocks, err := NewPeerRemote(candidate).GetBlocks([]encryption.SHA256HexString{id})
+		if err != nil || len(*blocks) != 1 || (*blocks)[0].ID != id {
+			continue
+		}
+		log.Printf("Resolved block %s from %s\n", id[:6], candidate)
+		migratePeerBlock(&(*blocks)[0], candidate, false)
+		return
+	}
+
+	BroadcastResolveBlockRequest(&id)
+}
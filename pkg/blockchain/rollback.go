@@ -0,0 +1,179 @@
+package blockchain
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/peerbridge/peerbridge/pkg/color"
+	"github.com/peerbridge/peerbridge/pkg/encryption"
+)
+
+// A block that was invalidated by an operator.
+// Invalidated blocks are never added to the chain again.
+type InvalidBlock struct {
+	// The id of the invalidated block.
+	ID encryption.SHA256HexString `json:"id" pg:",pk"`
+
+	// The reason why the block was invalidated.
+	Reason string `json:"reason" pg:",use_zero"`
+
+	// The time when the block was invalidated.
+	InvalidatedAt time.Time `json:"invalidatedAt" pg:",notnull,default:now()"`
+}
+
+// Check if a block was invalidated by an operator.
+func (r *BlockRepo) IsBlockInvalidated(id encryption.SHA256HexString) bool {
+	exists, err := r.DB.Model((*InvalidBlock)(nil)).
+		Where("id = ?", id).
+		Exists()
+	return err == nil && exists
+}
+
+// Mark a block as invalid and remove it together with all of its
+// descendants. The removed blocks are returned.
+func (r *BlockRepo) InvalidateBlock(id encryption.SHA256HexString, reason string) ([]Block, error) {
+	if id == GenesisBlock.ID {
+		return nil, ErrGenesisBlockImmutable
+	}
+	var removed []Block
+	err := r.DB.RunInTransaction(context.Background(), func(tx *pg.Tx) error {
+		_, err := tx.Model(&InvalidBlock{ID: id, Reason: reason}).
+			OnConflict("(id) DO UPDATE").
+			Set("reason = EXCLUDED.reason").
+			Insert()
+		if err != nil {
+			return err
+		}
+		removed, err = removeBlockTree(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// Remove all blocks above the given height, on all forks.
+// The removed blocks are returned.
+func (r *BlockRepo) RollbackToHeight(height uint64) ([]Block, error) {
+	removed := []Block{}
+	err := r.DB.RunInTransaction(context.Background(), func(tx *pg.Tx) error {
+		var ids []encryption.SHA256HexString
+		err := tx.Model((*Block)(nil)).
+			Column("id").
+			Where("height = ?", height+1).
+			Select(&ids)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			blocks, err := removeBlockTree(tx, id)
+			if err != nil {
+				return err
+			}
+			removed = append(removed, blocks...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// The result of a rollback or block invalidation.
+type RollbackResult struct {
+	// The number of blocks that were removed from the chain.
+	RemovedBlocks int `json:"removedBlocks"`
+
+	// The number of transactions of removed blocks that
+	// were put back into the pending transactions.
+	RequeuedTransactions int `json:"requeuedTransactions"`
+
+	// The id of the main chain head after the rollback.
+	HeadID encryption.SHA256HexString `json:"headID"`
+
+	// The height of the main chain head after the rollback.
+	HeadHeight uint64 `json:"headHeight"`
+}
+
+// Remove all blocks above the given height from the chain.
+// Transactions of removed blocks are put back into the pending
+// transactions. Note that the removed blocks may be obtained
+// again from other peers. To prevent this, use `InvalidateBlock`.
+func (chain *Blockchain) RollbackToHeight(height uint64) (*RollbackResult, error) {
+	removed, err := Repo.RollbackToHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	return chain.afterRollback(removed)
+}
+
+// Invalidate a block, so that it is never added to the chain again.
+// The block is removed together with all of its descendants and
+// their transactions are put back into the pending transactions.
+func (chain *Blockchain) InvalidateBlock(id encryption.SHA256HexString, reason string) (*RollbackResult, error) {
+	removed, err := Repo.InvalidateBlock(id, reason)
+	if err != nil {
+		return nil, err
+	}
+	return chain.afterRollback(removed)
+}
+
+// Requeue the transactions of the removed blocks and
+// obtain the new main chain head.
+func (chain *Blockchain) afterRollback(removed []Block) (*RollbackResult, error) {
+	result := &RollbackResult{RemovedBlocks: len(removed)}
+	result.RequeuedTransactions = chain.requeueTransactions(removed, Repo.ContainsMainChainTransactionByID)
+
+	// Drop orphan blocks that build on top of removed blocks
+	for _, b := range removed {
+		chain.Orphans.RemoveDescendants(b.ID)
+	}
+
+	head, err := Repo.GetMainChainEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	result.HeadID = head.ID
+	result.HeadHeight = head.Height
+
+	log.Printf(
+		"Removed %s block(s), requeued %s transaction(s), new head %s (H %s)\n",
+		color.Sprintf(fmt.Sprintf("%d", result.RemovedBlocks), color.Warning),
+		color.Sprintf(fmt.Sprintf("%d", result.RequeuedTransactions), color.Info),
+		color.Sprintf(head.ID[:6], color.Debug),
+		color.Sprintf(fmt.Sprintf("%d", head.Height), color.Info),
+	)
+	return result, nil
+}
+
+// Put the transactions of the removed blocks back into the pending
+// transactions, unless they are already pending or still included
+// in the main chain. The transactions are validated like new pending
+// transactions, which drops pruned transactions, since their data
+// is gone. Returns the number of requeued transactions.
+func (chain *Blockchain) requeueTransactions(
+	removed []Block, onMainChain func(id encryption.SHA256HexString) bool,
+) int {
+	requeued := 0
+	for _, b := range removed {
+		for _, t := range b.Transactions {
+			if chain.ContainsPendingTransactionByID(t.ID) || onMainChain(t.ID) {
+				continue
+			}
+			t.DataHash = nil
+			t.BlockID = nil
+			if err := chain.ValidateTransaction(&t); err != nil {
+				log.Printf("Dropped transaction %s (reason: %s)\n", t.ID[:6], err)
+				continue
+			}
+			*chain.PendingTransactions = append(*chain.PendingTransactions, t)
+			requeued++
+		}
+	}
+	return requeued
+}
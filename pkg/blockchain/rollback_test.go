@@ -0,0 +1,110 @@
+package blockchain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/peerbridge/peerbridge/pkg/encryption"
+)
+
+func TestRequeueTransactions(t *testing.T) {
+	pending := signedBlockWithData(t, TransactionVersionDataHash).Transactions[0]
+	included := signedBlockWithData(t, TransactionVersionDataHash).Transactions[0]
+	requeued := signedBlockWithData(t, TransactionVersionDataHash).Transactions[0]
+
+	pruned := signedBlockWithData(t, TransactionVersionDataHash)
+	pruneBlock(pruned)
+
+	forged := signedBlockWithData(t, TransactionVersionDataHash).Transactions[0]
+	forged.Balance++
+
+	chain := &Blockchain{PendingTransactions: &[]Transaction{pending}}
+	removed := []Block{
+		{Transactions: []Transaction{pending, included}},
+		{Transactions: []Transaction{pruned.Transactions[0], forged, requeued}},
+	}
+	onMainChain := func(id encryption.SHA256HexString) bool {
+		return id == included.ID
+	}
+
+	if n := chain.requeueTransactions(removed, onMainChain); n != 1 {
+		t.Errorf("Expected 1 requeued transaction, got %d", n)
+	}
+	if len(*chain.PendingTransactions) != 2 || (*chain.PendingTransactions)[1].ID != requeued.ID {
+		t.Fatalf("Expected only the valid transaction to be requeued, got %v", *chain.PendingTransactions)
+	}
+	if (*chain.PendingTransactions)[1].BlockID != nil {
+		t.Error("Expected the requeued transaction not to belong to a block")
+	}
+}
+
+func TestGenesisBlockCannotBeInvalidated(t *testing.T) {
+	if _, err := (&BlockRepo{}).InvalidateBlock(GenesisBlock.ID, ""); err != ErrGenesisBlockImmutable {
+		t.Errorf("Expected %s, got %v", ErrGenesisBlockImmutable, err)
+	}
+}
+
+// Send a request with the given body and admin token to the blockchain routes.
+func serveAdminRoute(path, body, token string) int {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	Routes().ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestAdminRoutesRequireAdminToken(t *testing.T) {
+	paths := []string{"/admin/rollback", "/admin/blocks/invalidate"}
+
+	os.Unsetenv("ADMIN_TOKEN")
+	for _, path := range paths {
+		if code := serveAdminRoute(path, `{}`, "secret"); code != http.StatusForbidden {
+			t.Errorf("Expected %d for %s without a configured token, got %d", http.StatusForbidden, path, code)
+		}
+	}
+
+	os.Setenv("ADMIN_TOKEN", "secret")
+	defer os.Unsetenv("ADMIN_TOKEN")
+	for _, path := range paths {
+		if code := serveAdminRoute(path, `{}`, ""); code != http.StatusUnauthorized {
+			t.Errorf("Expected %d for %s without a token, got %d", http.StatusUnauthorized, path, code)
+		}
+		if code := serveAdminRoute(path, `{}`, "wrong"); code != http.StatusUnauthorized {
+			t.Errorf("Expected %d for %s with a wrong token, got %d", http.StatusUnauthorized, path, code)
+		}
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	os.Setenv("ADMIN_TOKEN", "secret")
+	defer os.Unsetenv("ADMIN_TOKEN")
+	defer func(instance *Blockchain, repo *BlockRepo) { Instance, Repo = instance, repo }(Instance, Repo)
+	Instance = &Blockchain{PendingTransactions: &[]Transaction{}}
+	// Nothing listens on this address, so that every query fails
+	Repo = &BlockRepo{DB: pg.Connect(&pg.Options{Addr: "127.0.0.1:1"})}
+	defer Repo.DB.Close()
+
+	cases := []struct {
+		path, body string
+		code       int
+	}{
+		{"/admin/rollback", `{}`, http.StatusBadRequest},
+		{"/admin/rollback", `{"height": -1}`, http.StatusBadRequest},
+		{"/admin/rollback", `{"height": 1}`, http.StatusInternalServerError},
+		{"/admin/blocks/invalidate", `{}`, http.StatusBadRequest},
+		{"/admin/blocks/invalidate", `{"id": "` + GenesisBlock.ID + `"}`, http.StatusBadRequest},
+		{"/admin/blocks/invalidate", `{"id": "unknown"}`, http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		if code := serveAdminRoute(c.path, c.body, "secret"); code != c.code {
+			t.Errorf("Expected %d for %s with %s, got %d", c.code, c.path, c.body, code)
+		}
+	}
+}
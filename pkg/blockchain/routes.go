@@ -2,8 +2,10 @@
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 
+	"github.com/peerbridge/peerbridge/pkg/encryption"
 	. "github.com/peerbridge/peerbridge/pkg/http"
 )
 
@@ -95,6 +97,20 @@ func getTransaction(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// Respond with 410 Gone if the data of any of the given blocks was
+// pruned. Pruned blocks cannot be validated by the requesting peer,
+// so it has to obtain them from an archival node instead.
+// Returns true if the response was written.
+func goneIfPruned(w http.ResponseWriter, blocks []Block) bool {
+	for _, b := range blocks {
+		if b.IsPruned() {
+			Gone(w, ErrBlockPruned)
+			return true
+		}
+	}
+	return false
+}
+
 // The response format for the `getTransaction` method.
 type GetChildrenResponse struct {
 	Children *[]Block `json:"children"`
@@ -105,6 +121,7 @@ type GetChildrenResponse struct {
 // This http route returns:
 // - 400 BadRequest if the request was malformed
 // - 404 NotFound if the block or its children could not be found
+// - 410 Gone if the data of a child block was pruned
 // - 200 OK together with the child blocks
 func getChildBlocks(w http.ResponseWriter, r *http.Request) {
 	idParams, ok := r.URL.Query()["id"]
@@ -123,10 +140,155 @@ func getChildBlocks(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if goneIfPruned(w, *children) {
+			return
+		}
+
 		Json(w, r, http.StatusOK, GetChildrenResponse{children})
 	})
 }
 
+// The response format for the `getChainTips` method.
+type GetChainTipsResponse struct {
+	// The chain tips, ordered from the best to the worst tip.
+	Tips *[]ChainTip `json:"tips"`
+}
+
+// Get all chain tips of this node via http.
+//
+// This http route returns:
+// - 500 InternalServerError if the tips could not be obtained
+// - 200 OK together with the chain tips
+func getChainTips(w http.ResponseWriter, r *http.Request) {
+	tips, err := Repo.GetChainTips()
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	Json(w, r, http.StatusOK, GetChainTipsResponse{tips})
+}
+
+// The request format for the `locateHeaders` method.
+type LocateHeadersRequest struct {
+	// The block locator of the requesting node.
+	Locator []encryption.SHA256HexString `json:"locator"`
+
+	// The maximum number of headers to return.
+	Limit int `json:"limit"`
+}
+
+// The response format for the `locateHeaders` method.
+type LocateHeadersResponse struct {
+	// The most recent main chain block contained in the locator.
+	ForkPoint *ChainTip `json:"forkPoint"`
+
+	// The main chain headers on top of the fork point.
+	Headers *[]BlockHeader `json:"headers"`
+}
+
+// Get the main chain headers on top of the most recent block
+// that is contained in a given block locator via http.
+//
+// This http route returns:
+// - 400 BadRequest if the request was malformed or the locator is too long
+// - 404 NotFound if no block of the locator is in the main chain
+// - 500 InternalServerError if the headers could not be obtained
+// - 200 OK together with the fork point and the headers
+func locateHeaders(w http.ResponseWriter, r *http.Request) {
+	var request LocateHeadersRequest
+
+	err := DecodeJSONBody(w, r, &request)
+	if err != nil {
+		BadRequest(w, err)
+		return
+	}
+
+	if len(request.Locator) == 0 {
+		BadRequest(w, errors.New("The locator must be supplied!"))
+		return
+	}
+
+	if len(request.Locator) > MaxLocatorLength {
+		BadRequest(w, ErrLocatorTooLong)
+		return
+	}
+
+	// The query only reads the main chain, so it does not need the chain lock
+	forkPoint, headers, err := Repo.GetMainChainHeadersAfterLocator(
+		request.Locator, request.Limit,
+	)
+	if err == ErrNoCommonBlock {
+		NotFound(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	tip := NewChainTip(forkPoint)
+	Json(w, r, http.StatusOK, LocateHeadersResponse{&tip, headers})
+}
+
+// The request format for the `getBlocks` method.
+type GetBlocksRequest struct {
+	// The ids of the requested blocks.
+	IDs []encryption.SHA256HexString `json:"ids"`
+}
+
+// The response format for the `getBlocks` method.
+type GetBlocksResponse struct {
+	// The requested blocks, in the requested order. Only the first
+	// blocks are returned, if all of them would exceed the
+	// `MaxBlocksResponseSize`.
+	Blocks *[]Block `json:"blocks"`
+}
+
+// Get multiple blocks by their ids via http. Only the first blocks
+// are returned, if all of them would exceed `MaxBlocksResponseSize`.
+//
+// This http route returns:
+// - 400 BadRequest if the request was malformed
+// - 404 NotFound if any of the blocks could not be found
+// - 410 Gone if the data of a block was pruned
+// - 500 InternalServerError if the blocks could not be obtained
+// - 200 OK together with the blocks
+func getBlocks(w http.ResponseWriter, r *http.Request) {
+	var request GetBlocksRequest
+
+	err := DecodeJSONBody(w, r, &request)
+	if err != nil {
+		BadRequest(w, err)
+		return
+	}
+
+	if len(request.IDs) == 0 || len(request.IDs) > MaxBlocksPerRequest {
+		BadRequest(w, fmt.Errorf("Between 1 and %d block ids must be supplied!", MaxBlocksPerRequest))
+		return
+	}
+
+	blocks, err := Repo.GetBlocksByIDs(request.IDs)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	if len(*blocks) != len(request.IDs) {
+		NotFound(w, ErrBlockNotFound)
+		return
+	}
+	if goneIfPruned(w, *blocks) {
+		return
+	}
+	limited, err := limitBlocksResponse(*blocks)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	Json(w, r, http.StatusOK, GetBlocksResponse{&limited})
+}
+
 // The response format for the `getAccountBalance` method.
 type GetAccountBalanceResponse struct {
 	Balance *int64 `json:"balance"`
@@ -202,13 +364,193 @@ func getAccountTransactions(w http.ResponseWriter, r *http.Request) {
 	Json(w, r, http.StatusOK, GetAccountTransactionsResponse{txns})
 }
 
+// The response format for the `getChainInfo` method.
+type GetChainInfoResponse struct {
+	// The id of the genesis block.
+	GenesisID string `json:"genesisID"`
+
+	// The id of the main chain head.
+	HeadID string `json:"headID"`
+
+	// The height of the main chain head.
+	HeadHeight uint64 `json:"headHeight"`
+
+	// If this node prunes old transaction data.
+	// Historical blocks should be obtained from
+	// archival nodes instead.
+	Pruned bool `json:"pruned"`
+
+	// The depth below the main chain head from which
+	// transaction data is pruned (0 for archival nodes).
+	PruneDepth uint64 `json:"pruneDepth"`
+
+	// The height of the highest block with pruned
+	// transaction data, if any data was pruned.
+	PrunedHeight *uint64 `json:"prunedHeight"`
+}
+
+// Get information about the chain of this node via http.
+//
+// This http route returns:
+// - 500 InternalServerError if the information could not be obtained
+// - 200 OK together with the chain information
+func getChainInfo(w http.ResponseWriter, r *http.Request) {
+	head, err := Repo.GetMainChainEndpoint()
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	prunedHeight, err := Repo.GetPrunedHeight()
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	Json(w, r, http.StatusOK, GetChainInfoResponse{
+		GenesisID:    GenesisBlock.ID,
+		HeadID:       head.ID,
+		HeadHeight:   head.Height,
+		Pruned:       Instance.IsPruning(),
+		PruneDepth:   Instance.PruneDepth(),
+		PrunedHeight: prunedHeight,
+	})
+}
+
+// The response format for the `getNodeStatus` method.
+type GetNodeStatusResponse struct {
+	Status *SyncStatus `json:"status"`
+}
+
+// Get the sync status of this node via http.
+//
+// This http route returns:
+// - 500 InternalServerError if the status could not be obtained
+// - 200 OK together with the sync status
+func getNodeStatus(w http.ResponseWriter, r *http.Request) {
+	head, err := Repo.GetMainChainEndpoint()
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	status := SyncStatus{State: SyncStateSynced, LocalHeight: head.Height, BestPeerHeight: head.Height}
+	if Syncer != nil {
+		status = Syncer.Status(head.Height)
+	}
+
+	Json(w, r, http.StatusOK, GetNodeStatusResponse{&status})
+}
+
+// The request format for the `rollbackChain` method.
+type RollbackRequest struct {
+	// The height of the last block to keep.
+	Height *uint64 `json:"height"`
+}
+
+// The response format for the `rollbackChain` and `invalidateBlock` methods.
+type RollbackResponse struct {
+	Result *RollbackResult `json:"result"`
+}
+
+// Remove all blocks above a given height via http.
+// Transactions of removed blocks are put back into
+// the pending transactions. This is an admin route.
+//
+// This http route returns:
+// - 400 BadRequest if the request was malformed
+// - 500 InternalServerError if the rollback failed
+// - 200 OK together with the rollback result
+func rollbackChain(w http.ResponseWriter, r *http.Request) {
+	var request RollbackRequest
+
+	err := DecodeJSONBody(w, r, &request)
+	if err != nil {
+		BadRequest(w, err)
+		return
+	}
+
+	if request.Height == nil {
+		BadRequest(w, errors.New("The height must be supplied!"))
+		return
+	}
+
+	Instance.ThreadSafe(func() {
+		result, err := Instance.RollbackToHeight(*request.Height)
+		if err != nil {
+			InternalServerError(w, err)
+			return
+		}
+
+		Json(w, r, http.StatusOK, RollbackResponse{result})
+	})
+}
+
+// The request format for the `invalidateBlock` method.
+type InvalidateBlockRequest struct {
+	// The id of the block to invalidate.
+	ID *string `json:"id"`
+
+	// An optional reason for the invalidation.
+	Reason string `json:"reason"`
+}
+
+// Invalidate a block and remove it together with its descendants
+// via http. Transactions of removed blocks are put back into the
+// pending transactions. This is an admin route.
+//
+// This http route returns:
+// - 400 BadRequest if the request was malformed
+// - 500 InternalServerError if the invalidation failed
+// - 200 OK together with the rollback result
+func invalidateBlock(w http.ResponseWriter, r *http.Request) {
+	var request InvalidateBlockRequest
+
+	err := DecodeJSONBody(w, r, &request)
+	if err != nil {
+		BadRequest(w, err)
+		return
+	}
+
+	if request.ID == nil || len(*request.ID) < 1 {
+		BadRequest(w, errors.New("The block id must be supplied!"))
+		return
+	}
+
+	Instance.ThreadSafe(func() {
+		result, err := Instance.InvalidateBlock(*request.ID, request.Reason)
+		if err == ErrGenesisBlockImmutable {
+			BadRequest(w, err)
+			return
+		}
+		if err != nil {
+			InternalServerError(w, err)
+			return
+		}
+
+		Json(w, r, http.StatusOK, RollbackResponse{result})
+	})
+}
+
 func Routes() (router *Router) {
 	router = NewRouter()
 	router.Post("/transaction/create", createTransaction)
 	router.Get("/transaction/get", getTransaction)
+	router.Get("/info", getChainInfo)
 	router.Get("/fees/get", getRecommendedTransactionFee)
 	router.Get("/blocks/children/get", getChildBlocks)
+	router.Get("/tips", getChainTips)
+	router.Post("/blocks/get", getBlocks)
+	router.Post("/headers/locate", locateHeaders)
 	router.Get("/accounts/balance/get", getAccountBalance)
 	router.Get("/accounts/transactions/get", getAccountTransactions)
+	router.Post("/admin/rollback", Authenticated(rollbackChain))
+	router.Post("/admin/blocks/invalidate", Authenticated(invalidateBlock))
+	return
+}
+
+func NodeRoutes() (router *Router) {
+	router = NewRouter()
+	router.Get("/status", getNodeStatus)
 	return
 }
@@ -0,0 +1,150 @@
+package blockchain
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/peerbridge/peerbridge/pkg/color"
+)
+
+// The sync state of a node.
+type SyncState string
+
+const (
+	// The node has not finished its initial sync yet.
+	SyncStateIdle SyncState = "idle"
+
+	// The node is downloading blocks from its remotes.
+	SyncStateSyncing SyncState = "syncing"
+
+	// The node has caught up with its remotes.
+	SyncStateSynced SyncState = "synced"
+)
+
+// The sync status of a node.
+type SyncStatus struct {
+	// The current sync state.
+	State SyncState `json:"state"`
+
+	// The height of the local main chain endpoint.
+	LocalHeight uint64 `json:"localHeight"`
+
+	// The best chain height that is known from the remotes.
+	BestPeerHeight uint64 `json:"bestPeerHeight"`
+
+	// The number of blocks that were synced per second
+	// during the current (or last) download.
+	BlocksPerSecond float64 `json:"blocksPerSecond"`
+
+	// The estimated number of seconds until the node is synced.
+	// This is only set while the node is syncing.
+	ETASeconds *float64 `json:"etaSeconds"`
+}
+
+// The progress of the sync service.
+type syncProgress struct {
+	// The current sync state.
+	state SyncState
+
+	// If the initial sync has finished.
+	initialSyncDone bool
+
+	// The number of failed passes during the initial sync.
+	failedPasses int
+
+	// The best chain height that is known from the remotes.
+	bestPeerHeight uint64
+
+	// The time when the current download started.
+	downloadStartedAt time.Time
+
+	// The number of blocks synced during the current download.
+	downloadedBlocks int
+
+	// The sync rate of the current (or last) download.
+	blocksPerSecond float64
+}
+
+// Get the sync status, given the height of our main chain endpoint.
+func (s *SyncService) Status(localHeight uint64) SyncStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	status := SyncStatus{
+		State:           s.progress.state,
+		LocalHeight:     localHeight,
+		BestPeerHeight:  s.progress.bestPeerHeight,
+		BlocksPerSecond: s.progress.blocksPerSecond,
+	}
+	if localHeight > status.BestPeerHeight {
+		status.BestPeerHeight = localHeight
+	}
+	if status.State == SyncStateSyncing && status.BlocksPerSecond > 0 {
+		eta := float64(status.BestPeerHeight-localHeight) / status.BlocksPerSecond
+		status.ETASeconds = &eta
+	}
+	return status
+}
+
+// Check if the initial sync has finished and the
+// node is not catching up with its remotes.
+func (s *SyncService) IsSynced() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.progress.initialSyncDone && s.progress.state != SyncStateSyncing
+}
+
+// Remember the chain height of a remote.
+func (s *SyncService) observePeerHeight(height uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if height > s.progress.bestPeerHeight {
+		s.progress.bestPeerHeight = height
+	}
+}
+
+// Mark that blocks are downloaded from the remotes.
+func (s *SyncService) beginDownload() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.progress.state != SyncStateSyncing {
+		s.progress.state = SyncStateSyncing
+		s.progress.downloadStartedAt = time.Now()
+		s.progress.downloadedBlocks = 0
+	}
+}
+
+// Record the given number of synced blocks.
+func (s *SyncService) recordSynced(n int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.progress.downloadedBlocks += n
+	elapsed := time.Since(s.progress.downloadStartedAt).Seconds()
+	if elapsed > 0 {
+		s.progress.blocksPerSecond = float64(s.progress.downloadedBlocks) / elapsed
+	}
+}
+
+// Update the sync state after a sync pass finished with the given error.
+func (s *SyncService) finishPass(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err == nil || err == ErrNoRemotes {
+		s.progress.initialSyncDone = true
+	} else if !s.progress.initialSyncDone {
+		s.progress.failedPasses++
+		if s.progress.failedPasses >= maxFailedInitialPasses {
+			s.progress.initialSyncDone = true
+			log.Println(color.Sprintf(fmt.Sprintf(
+				"Initial sync failed %d times (reason: %s), continuing with the local chain",
+				s.progress.failedPasses, err,
+			), color.Warning))
+		}
+	}
+	if s.progress.initialSyncDone {
+		s.progress.state = SyncStateSynced
+	} else {
+		s.progress.state = SyncStateIdle
+	}
+}
@@ -0,0 +1,62 @@
+package blockchain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSyncStatus(t *testing.T) {
+	s := &SyncService{progress: syncProgress{state: SyncStateIdle}}
+	if s.IsSynced() {
+		t.Errorf("Expected the node not to be synced before the initial sync")
+	}
+
+	s.observePeerHeight(1000)
+	s.beginDownload()
+	s.progress.downloadStartedAt = time.Now().Add(-10 * time.Second)
+	s.recordSynced(100)
+
+	status := s.Status(100)
+	if status.State != SyncStateSyncing {
+		t.Errorf("Expected state %s, got %s", SyncStateSyncing, status.State)
+	}
+	if status.BestPeerHeight != 1000 {
+		t.Errorf("Expected best peer height 1000, got %d", status.BestPeerHeight)
+	}
+	if status.BlocksPerSecond < 9 || status.BlocksPerSecond > 11 {
+		t.Errorf("Expected about 10 blocks per second, got %f", status.BlocksPerSecond)
+	}
+	if status.ETASeconds == nil || *status.ETASeconds < 80 || *status.ETASeconds > 100 {
+		t.Errorf("Expected an ETA of about 90 seconds, got %v", status.ETASeconds)
+	}
+	if s.IsSynced() {
+		t.Errorf("Expected the node not to be synced while syncing")
+	}
+
+	s.finishPass(nil)
+	if !s.IsSynced() {
+		t.Errorf("Expected the node to be synced after a successful pass")
+	}
+	if status := s.Status(1000); status.ETASeconds != nil {
+		t.Errorf("Expected no ETA for a synced node")
+	}
+
+	s.finishPass(ErrRemotesUnreachable)
+	if !s.IsSynced() {
+		t.Errorf("Expected the node to stay synced after a failed pass")
+	}
+}
+
+func TestInitialSyncGivesUp(t *testing.T) {
+	s := &SyncService{progress: syncProgress{state: SyncStateIdle}}
+	for i := 1; i < maxFailedInitialPasses; i++ {
+		s.finishPass(ErrRemotesUnreachable)
+		if s.IsSynced() {
+			t.Fatalf("Expected the node not to be synced after %d failed pass(es)", i)
+		}
+	}
+	s.finishPass(ErrRemotesUnreachable)
+	if !s.IsSynced() {
+		t.Errorf("Expected the initial sync to be done after %d failed passes", maxFailedInitialPasses)
+	}
+}
@@ -0,0 +1,826 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/peerbridge/peerbridge/pkg/color"
+	"github.com/peerbridge/peerbridge/pkg/encryption"
+	"github.com/peerbridge/peerbridge/pkg/encryption/secp256k1"
+	"github.com/peerbridge/peerbridge/pkg/peer"
+)
+
+const (
+	// The timeout for a single request to a remote.
+	remoteRequestTimeout = 30 * time.Second
+
+	// The initial backoff after a remote failed.
+	// The backoff doubles with every consecutive failure.
+	initialRemoteBackoff = 1 * time.Second
+
+	// The maximum backoff after a remote failed.
+	maxRemoteBackoff = 5 * time.Minute
+
+	// The interval in which the continuous sync checks
+	// the remotes for new blocks.
+	continuousSyncInterval = 10 * time.Second
+
+	// The maximum number of headers that are downloaded
+	// before the block bodies are downloaded.
+	maxHeadersPerPass = 20 * MaxLocatedHeaders
+
+	// The number of parallel body downloads per remote.
+	bodyWorkersPerRemote = 2
+
+	// The interval in which the sync progress is logged.
+	syncProgressInterval = 5 * time.Second
+
+	// The number of times a failing remote is retried during
+	// a single sync pass, before it is skipped for this pass.
+	maxSyncAttempts = 3
+
+	// The number of failed sync passes after which the initial
+	// sync is considered done, so that an unreachable remote
+	// does not keep the node from minting forever.
+	maxFailedInitialPasses = 3
+)
+
+var (
+	ErrNoRemotes          = errors.New("No remotes to sync with!")
+	ErrRemotesUnreachable = errors.New("None of the remotes could be reached!")
+	ErrMalformedResponse  = errors.New("Remote sent a malformed response!")
+	ErrUnexpectedBlock    = errors.New("Remote sent an unexpected block!")
+	ErrChainTipsNotFound  = errors.New("Remote has no chain tips!")
+
+	ErrBodyDownloadIncomplete = errors.New("Block bodies could not be downloaded from any remote!")
+)
+
+// A remote node from which blocks can be synced.
+type Remote interface {
+	// A human readable name of the remote.
+	String() string
+
+	// Get all chain tips of the remote,
+	// ordered from the best to the worst tip.
+	GetChainTips() (*[]ChainTip, error)
+
+	// Get the main chain headers of the remote on top of the most
+	// recent block that is contained in the given block locator.
+	LocateHeaders(locator []encryption.SHA256HexString, limit int) (*LocateHeadersResponse, error)
+
+	// Get the blocks with the given ids, in the given order.
+	GetBlocks(ids []encryption.SHA256HexString) (*[]Block, error)
+}
+
+// The stored chain that the sync service syncs, which is `Repo`.
+type syncStore interface {
+	GetMainChainEndpoint() (*Block, error)
+	ContainsBlockByID(id encryption.SHA256HexString) bool
+	GetBlockByID(id encryption.SHA256HexString) (*Block, error)
+	GetBlockLocator(b *Block) ([]encryption.SHA256HexString, error)
+}
+
+// A remote node which is reachable via http.
+type HTTPRemote struct {
+	// The base url of the remote, e.g. https://peerbridge.herokuapp.com
+	URL string
+
+	client *http.Client
+}
+
+// Create a new remote which is reachable under the given base url.
+func NewHTTPRemote(url string) *HTTPRemote {
+	return &HTTPRemote{
+		URL:    url,
+		client: &http.Client{Timeout: remoteRequestTimeout},
+	}
+}
+
+func (r *HTTPRemote) String() string {
+	return r.URL
+}
+
+// Decode the body of a response from the remote. The given
+// error is returned if the remote responds with 404 NotFound.
+func (r *HTTPRemote) decode(response *http.Response, body interface{}, notFound error) error {
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return notFound
+	case http.StatusGone:
+		return ErrBlockPruned
+	default:
+		return fmt.Errorf("Remote responded with %d %s", response.StatusCode, http.StatusText(response.StatusCode))
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(body); err != nil {
+		return ErrMalformedResponse
+	}
+	return nil
+}
+
+// Post the given request to the remote and decode the response body.
+func (r *HTTPRemote) post(path string, request interface{}, body interface{}, notFound error) error {
+	requestBytes, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	response, err := r.client.Post(
+		fmt.Sprintf("%s%s", r.URL, path),
+		"application/json",
+		bytes.NewBuffer(requestBytes),
+	)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	return r.decode(response, body, notFound)
+}
+
+func (r *HTTPRemote) GetChainTips() (*[]ChainTip, error) {
+	response, err := r.client.Get(fmt.Sprintf("%s/blockchain/tips", r.URL))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var body GetChainTipsResponse
+	if err = r.decode(response, &body, ErrChainTipsNotFound); err != nil {
+		return nil, err
+	}
+	if body.Tips == nil {
+		return nil, ErrMalformedResponse
+	}
+	return body.Tips, nil
+}
+
+func (r *HTTPRemote) LocateHeaders(locator []encryption.SHA256HexString, limit int) (*LocateHeadersResponse, error) {
+	var body LocateHeadersResponse
+	err := r.post("/blockchain/headers/locate", LocateHeadersRequest{locator, limit}, &body, ErrNoCommonBlock)
+	if err != nil {
+		return nil, err
+	}
+	if body.ForkPoint == nil || body.Headers == nil {
+		return nil, ErrMalformedResponse
+	}
+	return &body, nil
+}
+
+func (r *HTTPRemote) GetBlocks(ids []encryption.SHA256HexString) (*[]Block, error) {
+	var body GetBlocksResponse
+	err := r.post("/blockchain/blocks/get", GetBlocksRequest{ids}, &body, ErrBlockNotFound)
+	if err != nil {
+		return nil, err
+	}
+	if body.Blocks == nil {
+		return nil, ErrMalformedResponse
+	}
+	return body.Blocks, nil
+}
+
+// An error that is caused by a remote which sent invalid data.
+// Remotes that misbehave are dropped.
+type misbehaviourError struct {
+	reason error
+}
+
+func (e misbehaviourError) Error() string {
+	return e.reason.Error()
+}
+
+// The sync state of a single remote.
+type remoteState struct {
+	remote Remote
+
+	// The number of consecutive failures of the remote.
+	failures int
+
+	// The time before which the remote should not be contacted.
+	backoffUntil time.Time
+}
+
+// A service that syncs the chain against a set of remotes.
+// The remotes are used in a round robin fashion. Remotes which are
+// unreachable are retried with an exponential backoff. Remotes which
+// send invalid data are dropped.
+type SyncService struct {
+	// The remotes that are used for syncing.
+	remotes []*remoteState
+
+	// The index of the next remote to use.
+	next int
+
+	// A lock to protect the remotes.
+	lock sync.Mutex
+
+	// The chain which is synced, and where it is stored.
+	chain *Blockchain
+	store syncStore
+
+	// Migrate a synced block from the given source into the chain.
+	// Returns true if the block was added to the chain.
+	migrate func(b *Block, source string) (bool, error)
+
+	// The progress of the sync, protected by the lock.
+	progress syncProgress
+
+	// Receives a value when a sync pass is requested
+	// before the next continuous sync interval.
+	requested chan struct{}
+}
+
+// The main sync service of the blockchain.
+// This instance is `nil` until `InitSync(remotes)` is called.
+var Syncer *SyncService
+
+// Initiate the sync service with the given remotes.
+// The sync service is accessible under `Syncer`.
+func InitSync(remotes ...Remote) {
+	Syncer = newSyncService(Instance, Repo)
+	for _, remote := range remotes {
+		Syncer.AddRemote(remote)
+	}
+}
+
+// Create a sync service for the given chain, which is stored in the given store.
+func newSyncService(chain *Blockchain, store syncStore) *SyncService {
+	s := &SyncService{
+		chain:     chain,
+		store:     store,
+		progress:  syncProgress{state: SyncStateIdle},
+		requested: make(chan struct{}, 1),
+	}
+	s.migrate = s.migrateBlock
+	return s
+}
+
+// Migrate a synced block into the chain.
+// Returns true if the block was added to the chain.
+func (s *SyncService) migrateBlock(b *Block, source string) (added bool, err error) {
+	s.chain.ThreadSafe(func() {
+		err = s.chain.MigrateBlockFrom(b, source, true)
+		added = err == nil && s.store.ContainsBlockByID(b.ID)
+	})
+	return
+}
+
+// Request a sync pass without waiting for the next continuous sync
+// interval, e.g. because a new remote is ahead of us. Requests
+// which arrive while a pass is already requested are merged.
+func (s *SyncService) RequestSync() {
+	select {
+	case s.requested <- struct{}{}:
+	default:
+	}
+}
+
+// Add a remote to sync with. Remotes that are already known are ignored.
+func (s *SyncService) AddRemote(remote Remote) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, state := range s.remotes {
+		if state.remote.String() == remote.String() {
+			return
+		}
+	}
+	s.remotes = append(s.remotes, &remoteState{remote: remote})
+}
+
+// Drop a misbehaving remote, so that it is not used anymore.
+func (s *SyncService) dropRemote(state *remoteState, reason error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	remotes := []*remoteState{}
+	for _, r := range s.remotes {
+		if r != state {
+			remotes = append(remotes, r)
+		}
+	}
+	s.remotes = remotes
+	log.Println(color.Sprintf(fmt.Sprintf("Dropped remote %s (reason: %s)", state.remote, reason), color.Warning))
+
+	// Peers which send invalid data are penalized
+	if r, ok := state.remote.(*PeerRemote); ok {
+		if _, ok := reason.(misbehaviourError); ok {
+			peer.Service.Penalize(r.ID, peer.PenaltyInvalidData, reason)
+		}
+	}
+}
+
+// Back off from a remote which could not be reached.
+func (s *SyncService) backOff(state *remoteState, reason error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	backoff := initialRemoteBackoff << state.failures
+	if backoff > maxRemoteBackoff || backoff <= 0 {
+		backoff = maxRemoteBackoff
+	} else {
+		state.failures++
+	}
+	state.backoffUntil = time.Now().Add(backoff)
+	log.Println(color.Sprintf(fmt.Sprintf("Remote %s failed, retrying in %s (reason: %s)", state.remote, backoff, reason), color.Warning))
+}
+
+// Reset the backoff of a remote after a successful request.
+func (s *SyncService) reset(state *remoteState) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	state.failures = 0
+	state.backoffUntil = time.Time{}
+}
+
+// Get the next remote in round robin order, which is not in backoff
+// and not contained in the given set of skipped remotes. If all
+// remaining remotes are in backoff, this waits for the earliest one,
+// unless its backoff exceeds the continuous sync interval.
+// Returns `nil` if no remote is left or the context is done.
+func (s *SyncService) nextRemote(ctx context.Context, skip map[*remoteState]bool) *remoteState {
+	for {
+		s.lock.Lock()
+		var earliest *remoteState
+		for i := 0; i < len(s.remotes); i++ {
+			state := s.remotes[(s.next+i)%len(s.remotes)]
+			if skip[state] {
+				continue
+			}
+			if time.Now().After(state.backoffUntil) {
+				s.next = (s.next + i + 1) % len(s.remotes)
+				s.lock.Unlock()
+				return state
+			}
+			if earliest == nil || state.backoffUntil.Before(earliest.backoffUntil) {
+				earliest = state
+			}
+		}
+		s.lock.Unlock()
+
+		if earliest == nil || time.Until(earliest.backoffUntil) > continuousSyncInterval {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Until(earliest.backoffUntil)):
+		}
+	}
+}
+
+// Sync the chain until none of the remotes has a better chain
+// than our main chain, or until the given context is done.
+// Returns the number of synced blocks.
+func (s *SyncService) Sync(ctx context.Context) (int, error) {
+	n, err := s.sync(ctx)
+	if ctx.Err() == nil {
+		s.finishPass(err)
+	}
+	return n, err
+}
+
+func (s *SyncService) sync(ctx context.Context) (int, error) {
+	synced := 0
+
+	s.lock.Lock()
+	hasRemotes := len(s.remotes) > 0
+	s.lock.Unlock()
+	if !hasRemotes {
+		return synced, ErrNoRemotes
+	}
+
+	// The remotes which have no more blocks for us
+	exhausted := map[*remoteState]bool{}
+	// The remotes which are skipped during this pass
+	skipped := map[*remoteState]bool{}
+	// The failed attempts per remote during this pass
+	attempts := map[*remoteState]int{}
+
+	for {
+		state := s.nextRemote(ctx, skipped)
+		if err := ctx.Err(); err != nil {
+			return synced, err
+		}
+		if state == nil {
+			if len(exhausted) == 0 {
+				return synced, ErrRemotesUnreachable
+			}
+			return synced, nil
+		}
+
+		n, done, err := s.syncFromRemote(ctx, state)
+		synced += n
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return synced, ctxErr
+		}
+		if err == ErrBodyDownloadIncomplete {
+			// The failing remotes were already handled
+			continue
+		}
+		if _, ok := err.(storageError); ok {
+			// The remotes are not at fault, but syncing from
+			// other remotes would fail the same way
+			return synced, err
+		}
+		if _, ok := err.(misbehaviourError); ok || err == peer.ErrPeerNotConnected {
+			s.dropRemote(state, err)
+			continue
+		}
+		if err != nil {
+			s.backOff(state, err)
+			attempts[state]++
+			if attempts[state] >= maxSyncAttempts {
+				skipped[state] = true
+			}
+			continue
+		}
+		s.reset(state)
+
+		if done {
+			exhausted[state] = true
+			skipped[state] = true
+			continue
+		}
+
+		// New blocks were added, so all remotes may have more
+		for remote := range exhausted {
+			delete(skipped, remote)
+		}
+		exhausted = map[*remoteState]bool{}
+	}
+}
+
+// Download the best branch of the given remote, if it is better
+// than our main chain. To do so, a block locator is sent to the
+// remote, which finds our most recent common block, even when
+// our main chain endpoint is on a fork that the remote abandoned.
+// The headers of the branch are downloaded and validated first,
+// after which the block bodies are downloaded in parallel.
+// Returns the number of added blocks, and `true` if the remote
+// has no better chain than ours.
+func (s *SyncService) syncFromRemote(ctx context.Context, state *remoteState) (int, bool, error) {
+	tips, err := state.remote.GetChainTips()
+	if err == ErrMalformedResponse {
+		return 0, false, misbehaviourError{err}
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if len(*tips) == 0 {
+		return 0, false, misbehaviourError{ErrMalformedResponse}
+	}
+	best := (*tips)[0]
+	s.observePeerHeight(best.Height)
+
+	endpoint, err := s.store.GetMainChainEndpoint()
+	if err != nil {
+		return 0, false, err
+	}
+	if s.store.ContainsBlockByID(best.ID) || !best.IsBetterThan(NewChainTip(endpoint)) {
+		return 0, true, nil
+	}
+
+	s.beginDownload()
+	headers, err := s.downloadHeaders(ctx, state.remote, endpoint, best)
+	if err != nil {
+		return 0, false, err
+	}
+
+	added, err := s.downloadBodies(ctx, state, headers)
+	if err != nil {
+		return added, false, err
+	}
+	return added, added == 0, nil
+}
+
+// Download and validate the headers of the best branch of the
+// given remote, on top of the most recent common block.
+func (s *SyncService) downloadHeaders(
+	ctx context.Context, remote Remote, endpoint *Block, best ChainTip,
+) ([]BlockHeader, error) {
+	baseLocator, err := s.store.GetBlockLocator(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := []BlockHeader{}
+	var parent BlockHeader
+	for len(headers) < maxHeadersPerPass {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		locator := baseLocator
+		if len(headers) > 0 {
+			// Continue after the last downloaded header
+			locator = append([]encryption.SHA256HexString{parent.ID}, baseLocator...)
+		}
+
+		response, err := remote.LocateHeaders(locator, MaxLocatedHeaders)
+		if err == ErrMalformedResponse || err == ErrNoCommonBlock {
+			return nil, misbehaviourError{err}
+		}
+		if err != nil {
+			return nil, err
+		}
+		batch := *response.Headers
+		if len(batch) == 0 {
+			break
+		}
+
+		if len(headers) == 0 {
+			forkPoint, err := s.store.GetBlockByID(response.ForkPoint.ID)
+			if err != nil {
+				return nil, misbehaviourError{ErrUnexpectedBlock}
+			}
+			parent = forkPoint.Header()
+			log.Printf(
+				"Found common block %s at height %s with %s\n",
+				color.Sprintf(parent.ID[:6], color.Debug),
+				color.Sprintf(fmt.Sprintf("%d", parent.Height), color.Info),
+				remote,
+			)
+		} else if response.ForkPoint.ID != parent.ID {
+			// The remote switched to another branch in the meantime
+			break
+		}
+
+		for _, h := range batch {
+			if err := h.Validate(&parent); err != nil {
+				return nil, misbehaviourError{err}
+			}
+			headers = append(headers, h)
+			parent = h
+		}
+		log.Printf(
+			"Downloaded %s header(s) up to height %s (remote height: %s)\n",
+			color.Sprintf(fmt.Sprintf("%d", len(headers)), color.Info),
+			color.Sprintf(fmt.Sprintf("%d", parent.Height), color.Info),
+			color.Sprintf(fmt.Sprintf("%d", best.Height), color.Info),
+		)
+
+		// Responses may be shorter than requested, see `MaxLocatedHeadersSize`
+		if parent.ID == best.ID || parent.Height >= best.Height {
+			break
+		}
+	}
+	return headers, nil
+}
+
+// Get the remotes which are currently not in backoff,
+// starting with the given remote.
+func (s *SyncService) availableRemotes(first *remoteState) []*remoteState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	available := []*remoteState{first}
+	for _, state := range s.remotes {
+		if state != first && time.Now().After(state.backoffUntil) {
+			available = append(available, state)
+		}
+	}
+	return available
+}
+
+// Check that a block body received from a remote matches its
+// header, and that the block and its transactions are signed.
+func (s *SyncService) validateBody(h *BlockHeader, b *Block) error {
+	if !h.Matches(b) {
+		return ErrBlockHeaderMismatch
+	}
+	if err := b.CheckFormat(); err != nil {
+		return err
+	}
+	if len(b.Transactions) == 0 {
+		return ErrMalformedBlock
+	}
+	if err := secp256k1.VerifySignature(b, *b.Signature); err != nil {
+		return err
+	}
+	for i := range b.Transactions {
+		if err := s.chain.ValidateTransaction(&b.Transactions[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The blocks of a batch of headers, which were downloaded by a worker.
+type bodyBatch struct {
+	index  int
+	blocks []Block
+}
+
+// A failed body download of a remote.
+type bodyFailure struct {
+	state *remoteState
+	err   error
+}
+
+// Download the bodies of the given headers from the remote and
+// validate them against the headers. Remotes may only return the
+// first of the requested blocks, see `MaxBlocksResponseSize`, so
+// the remaining blocks are requested until all of them arrived.
+func (s *SyncService) downloadBatch(remote Remote, headers []BlockHeader) ([]Block, error) {
+	blocks := []Block{}
+	for len(blocks) < len(headers) {
+		ids := []encryption.SHA256HexString{}
+		for _, h := range headers[len(blocks):] {
+			ids = append(ids, h.ID)
+		}
+		received, err := remote.GetBlocks(ids)
+		if err == ErrMalformedResponse {
+			return nil, misbehaviourError{err}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(*received) == 0 || len(*received) > len(ids) {
+			return nil, misbehaviourError{ErrMalformedResponse}
+		}
+		for i := range *received {
+			if err := s.validateBody(&headers[len(blocks)+i], &(*received)[i]); err != nil {
+				return nil, misbehaviourError{err}
+			}
+		}
+		blocks = append(blocks, *received...)
+	}
+	return blocks, nil
+}
+
+// Download the bodies of the given headers in parallel from the
+// available remotes, and migrate them into the chain in order.
+// Remotes that fail to deliver bodies are handled directly.
+// Returns the number of added blocks.
+func (s *SyncService) downloadBodies(
+	ctx context.Context, primary *remoteState, headers []BlockHeader,
+) (int, error) {
+	// Only download blocks which we don't have yet
+	missing := []BlockHeader{}
+	for _, h := range headers {
+		if !s.store.ContainsBlockByID(h.ID) {
+			missing = append(missing, h)
+		}
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+
+	batches := [][]BlockHeader{}
+	for i := 0; i < len(missing); i += MaxBlocksPerRequest {
+		end := i + MaxBlocksPerRequest
+		if end > len(missing) {
+			end = len(missing)
+		}
+		batches = append(batches, missing[i:end])
+	}
+
+	jobs := make(chan int, len(batches))
+	for i := range batches {
+		jobs <- i
+	}
+	results := make(chan bodyBatch)
+	failures := make(chan bodyFailure)
+	done := make(chan struct{})
+	defer close(done)
+
+	worker := func(state *remoteState) {
+		for {
+			var index int
+			select {
+			case <-done:
+				return
+			case index = <-jobs:
+			}
+
+			blocks, err := s.downloadBatch(state.remote, batches[index])
+			if err != nil {
+				// Leave the batch to the other workers
+				jobs <- index
+				select {
+				case failures <- bodyFailure{state, err}:
+				case <-done:
+				}
+				return
+			}
+
+			select {
+			case results <- bodyBatch{index, blocks}:
+			case <-done:
+				return
+			}
+		}
+	}
+
+	workers := 0
+	for _, state := range s.availableRemotes(primary) {
+		for i := 0; i < bodyWorkersPerRemote; i++ {
+			go worker(state)
+			workers++
+		}
+	}
+
+	log.Printf(
+		"Downloading %s block(s) from %s remote(s)...\n",
+		color.Sprintf(fmt.Sprintf("%d", len(missing)), color.Info),
+		color.Sprintf(fmt.Sprintf("%d", workers/bodyWorkersPerRemote), color.Info),
+	)
+
+	added := 0
+	migrated := 0
+	next := 0
+	downloaded := map[int][]Block{}
+	failed := map[*remoteState]bool{}
+	lastProgress := time.Now()
+	for next < len(batches) {
+		select {
+		case <-ctx.Done():
+			return added, ctx.Err()
+		case result := <-results:
+			downloaded[result.index] = result.blocks
+		case failure := <-failures:
+			workers--
+			if !failed[failure.state] {
+				failed[failure.state] = true
+				if _, ok := failure.err.(misbehaviourError); ok {
+					s.dropRemote(failure.state, failure.err)
+				} else {
+					s.backOff(failure.state, failure.err)
+				}
+			}
+			if workers == 0 {
+				return added, ErrBodyDownloadIncomplete
+			}
+			continue
+		}
+
+		// Migrate the downloaded batches in order, so that
+		// the parents are always migrated before their children
+		for ctx.Err() == nil {
+			blocks, ok := downloaded[next]
+			if !ok {
+				break
+			}
+			delete(downloaded, next)
+			next++
+
+			for i := range blocks {
+				ok, err := s.migrate(&blocks[i], primary.remote.String())
+				if ok {
+					added++
+				}
+				if _, ok := err.(storageError); ok {
+					return added, err
+				}
+				if err != nil {
+					// The headers were valid, but the chain they
+					// form is not, so the primary remote misbehaves
+					return added, misbehaviourError{err}
+				}
+			}
+			migrated += len(blocks)
+			s.recordSynced(len(blocks))
+
+			if time.Since(lastProgress) > syncProgressInterval || next == len(batches) {
+				lastProgress = time.Now()
+				log.Printf(
+					"Synced %s/%s block(s) (%s%%)\n",
+					color.Sprintf(fmt.Sprintf("%d", migrated), color.Info),
+					color.Sprintf(fmt.Sprintf("%d", len(missing)), color.Info),
+					color.Sprintf(fmt.Sprintf("%d", migrated*100/len(missing)), color.Info),
+				)
+			}
+		}
+	}
+	return added, nil
+}
+
+// Sync the chain against the remotes, and keep syncing in a regular
+// interval, or when a sync is requested, until the context is done.
+func (s *SyncService) RunContinuousSync(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(continuousSyncInterval):
+		case <-s.requested:
+		}
+		n, err := s.Sync(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == ErrNoRemotes {
+			continue
+		}
+		if err != nil {
+			log.Println(color.Sprintf(fmt.Sprintf("Sync failed: %s", err), color.Error))
+			continue
+		}
+		if n > 0 {
+			log.Printf("Synced %s new block(s)\n", color.Sprintf(fmt.Sprintf("%d", n), color.Info))
+		}
+	}
+}
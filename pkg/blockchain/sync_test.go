@@ -0,0 +1,507 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/peerbridge/peerbridge/pkg/encryption"
+	"github.com/peerbridge/peerbridge/pkg/encryption/secp256k1"
+)
+
+// Create a signed block with a signed transaction on top of the given
+// parent, whose challenge, target, cumulative difficulty and hit are valid.
+func signedChild(t *testing.T, parent *Block) *Block {
+	return signedChildAfter(t, parent, eligibleAfter(t, parent))
+}
+
+// Get the smallest doubling of a second after the given parent block,
+// at which the genesis key pair may create a block, assuming
+// that it holds the total supply of coins.
+func eligibleAfter(t *testing.T, parent *Block) int64 {
+	challenge, err := calculateChallenge(GenesisKeyPair.PublicKey, parent.Challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hit := new(big.Int).SetUint64(binary.BigEndian.Uint64(challenge[0:8]))
+	stake := totalSupplyUntilHeight(parent.Height)
+	ns := int64(time.Second)
+	for hit.Cmp(calculateUpperBound(parent.Target, ns, stake)) == 1 {
+		ns *= 2
+	}
+	return ns
+}
+
+// Create a signed block with a signed transaction, which is
+// created the given nanoseconds after the given parent.
+func signedChildAfter(t *testing.T, parent *Block, ns int64) *Block {
+	id, err := encryption.RandomSHA256HexString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn := Transaction{
+		ID:           *id,
+		Sender:       GenesisKeyPair.PublicKey,
+		Receiver:     GenesisKeyPair.PublicKey,
+		Balance:      1,
+		TimeUnixNano: parent.TimeUnixNano,
+		Version:      TransactionVersion,
+	}
+	txn.Signature, err = secp256k1.ComputeSignature(&txn, GenesisKeyPair.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	challenge, err := calculateChallenge(GenesisKeyPair.PublicKey, parent.Challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, cumulativeDifficulty, err := calculateTarget(parent.Target, parent.CumulativeDifficulty, ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Block{
+		ID:                   *id,
+		ParentID:             &parent.ID,
+		Height:               parent.Height + 1,
+		TimeUnixNano:         parent.TimeUnixNano + ns,
+		Transactions:         []Transaction{txn},
+		Creator:              GenesisKeyPair.PublicKey,
+		Target:               target,
+		Challenge:            hex.EncodeToString(challenge[:]),
+		CumulativeDifficulty: cumulativeDifficulty,
+	}
+	b.Signature, err = secp256k1.ComputeSignature(b, GenesisKeyPair.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// Create a chain of the given length on top of the given block.
+func signedChain(t *testing.T, base *Block, length int) []*Block {
+	chain := []*Block{}
+	parent := base
+	for i := 0; i < length; i++ {
+		parent = signedChild(t, parent)
+		chain = append(chain, parent)
+	}
+	return chain
+}
+
+// A stored chain in memory.
+type memStore struct {
+	blocks   map[encryption.SHA256HexString]*Block
+	endpoint *Block
+}
+
+func newMemStore(blocks ...*Block) *memStore {
+	s := &memStore{blocks: map[encryption.SHA256HexString]*Block{}}
+	s.add(GenesisBlock)
+	for _, b := range blocks {
+		s.add(b)
+	}
+	return s
+}
+
+func (s *memStore) add(b *Block) {
+	s.blocks[b.ID] = b
+	if s.endpoint == nil || NewChainTip(b).IsBetterThan(NewChainTip(s.endpoint)) {
+		s.endpoint = b
+	}
+}
+
+func (s *memStore) GetMainChainEndpoint() (*Block, error) {
+	return s.endpoint, nil
+}
+
+func (s *memStore) ContainsBlockByID(id encryption.SHA256HexString) bool {
+	_, ok := s.blocks[id]
+	return ok
+}
+
+func (s *memStore) GetBlockByID(id encryption.SHA256HexString) (*Block, error) {
+	b, ok := s.blocks[id]
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+	return b, nil
+}
+
+func (s *memStore) GetBlockLocator(b *Block) ([]encryption.SHA256HexString, error) {
+	included := map[uint64]bool{}
+	for _, height := range locatorHeights(b.Height) {
+		included[height] = true
+	}
+	locator := []encryption.SHA256HexString{}
+	for next := b; next != nil; {
+		if included[next.Height] {
+			locator = append(locator, next.ID)
+		}
+		if next.ParentID == nil {
+			break
+		}
+		next = s.blocks[*next.ParentID]
+	}
+	return locator, nil
+}
+
+// A remote which serves the given main chain (without genesis).
+type fakeRemote struct {
+	name  string
+	chain []*Block
+
+	// The error that is returned for chain tips requests.
+	tipsErr error
+
+	// The locators that were received.
+	locators [][]encryption.SHA256HexString
+
+	// The maximum number of blocks per response, or 0 for no limit.
+	maxBlocks int
+}
+
+func (r *fakeRemote) String() string {
+	return r.name
+}
+
+func (r *fakeRemote) GetChainTips() (*[]ChainTip, error) {
+	if r.tipsErr != nil {
+		return nil, r.tipsErr
+	}
+	tip := NewChainTip(GenesisBlock)
+	if len(r.chain) > 0 {
+		tip = NewChainTip(r.chain[len(r.chain)-1])
+	}
+	return &[]ChainTip{tip}, nil
+}
+
+func (r *fakeRemote) LocateHeaders(locator []encryption.SHA256HexString, limit int) (*LocateHeadersResponse, error) {
+	r.locators = append(r.locators, locator)
+	for _, id := range locator {
+		forkPoint, index := GenesisBlock, 0
+		if id != GenesisBlock.ID {
+			forkPoint, index = nil, -1
+			for i, b := range r.chain {
+				if b.ID == id {
+					forkPoint, index = b, i+1
+				}
+			}
+		}
+		if forkPoint == nil {
+			continue
+		}
+		headers := []BlockHeader{}
+		for _, b := range r.chain[index:] {
+			if len(headers) >= limit {
+				break
+			}
+			headers = append(headers, b.Header())
+		}
+		tip := NewChainTip(forkPoint)
+		return &LocateHeadersResponse{&tip, &headers}, nil
+	}
+	return nil, ErrNoCommonBlock
+}
+
+func (r *fakeRemote) GetBlocks(ids []encryption.SHA256HexString) (*[]Block, error) {
+	blocks := []Block{}
+	for _, id := range ids {
+		for _, b := range r.chain {
+			if b.ID == id {
+				blocks = append(blocks, *b)
+			}
+		}
+	}
+	if r.maxBlocks > 0 && len(blocks) > r.maxBlocks {
+		blocks = blocks[:r.maxBlocks]
+	}
+	return &blocks, nil
+}
+
+// Create a sync service for the given store, which migrates blocks into the store.
+// The ids of the migrated blocks are recorded in the given slice.
+func newTestSyncService(store *memStore, migrated *[]encryption.SHA256HexString) *SyncService {
+	s := newSyncService(&Blockchain{}, store)
+	s.migrate = func(b *Block, source string) (bool, error) {
+		*migrated = append(*migrated, b.ID)
+		store.add(b)
+		return true, nil
+	}
+	return s
+}
+
+// Get the names of the remotes of the sync service.
+func remoteNames(s *SyncService) []string {
+	names := []string{}
+	for _, state := range s.remotes {
+		names = append(names, state.remote.String())
+	}
+	return names
+}
+
+func TestNextRemoteRoundRobin(t *testing.T) {
+	s := newTestSyncService(newMemStore(), &[]encryption.SHA256HexString{})
+	for _, name := range []string{"a", "b", "c"} {
+		s.AddRemote(&fakeRemote{name: name})
+	}
+	s.AddRemote(&fakeRemote{name: "a"})
+
+	order := []string{}
+	for i := 0; i < 4; i++ {
+		order = append(order, s.nextRemote(context.Background(), nil).remote.String())
+	}
+	if fmt.Sprint(order) != "[a b c a]" {
+		t.Errorf("Expected the remotes in round robin order, got %v", order)
+	}
+
+	skip := map[*remoteState]bool{s.remotes[1]: true, s.remotes[2]: true}
+	if next := s.nextRemote(context.Background(), skip).remote.String(); next != "a" {
+		t.Errorf("Expected the only remote which is not skipped, got %s", next)
+	}
+	skip[s.remotes[0]] = true
+	if next := s.nextRemote(context.Background(), skip); next != nil {
+		t.Errorf("Expected no remote if all are skipped, got %s", next.remote)
+	}
+}
+
+func TestBackOff(t *testing.T) {
+	cases := []struct {
+		name     string
+		failures int
+		backoff  time.Duration
+	}{
+		{"first failure", 0, initialRemoteBackoff},
+		{"second failure", 1, 2 * initialRemoteBackoff},
+		{"maximum backoff", 20, maxRemoteBackoff},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := newTestSyncService(newMemStore(), &[]encryption.SHA256HexString{})
+			s.AddRemote(&fakeRemote{name: "a"})
+			s.AddRemote(&fakeRemote{name: "b"})
+			failing := s.remotes[0]
+			failing.failures = c.failures
+
+			before := time.Now()
+			s.backOff(failing, ErrRemotesUnreachable)
+			backoff := failing.backoffUntil.Sub(before)
+			if backoff < c.backoff || backoff > c.backoff+time.Second {
+				t.Errorf("Expected a backoff of %s, got %s", c.backoff, backoff)
+			}
+
+			// Remotes in backoff are not used
+			for i := 0; i < 2; i++ {
+				if next := s.nextRemote(context.Background(), nil); next != s.remotes[1] {
+					t.Errorf("Expected the remote without backoff, got %s", next.remote)
+				}
+			}
+
+			s.reset(failing)
+			if failing.failures != 0 || !failing.backoffUntil.IsZero() {
+				t.Error("Expected the backoff to be reset")
+			}
+		})
+	}
+}
+
+func TestSync(t *testing.T) {
+	// Our chain, which is a fork of the remote chain
+	local := signedChain(t, GenesisBlock, 2)
+	// The chain of the remotes, which is longer
+	remote := signedChain(t, GenesisBlock, 3)
+	// A chain with a forged header, which is longer than all other
+	// chains, so that its headers are always downloaded, no matter
+	// in which order the remotes are contacted
+	forged := signedChain(t, GenesisBlock, 4)
+	forged[1].Challenge = forged[0].Challenge
+
+	cases := []struct {
+		name        string
+		local       []*Block
+		remotes     []*fakeRemote
+		wantErr     error
+		wantSynced  []*Block
+		wantRemotes []string
+	}{
+		{
+			name:    "no remotes",
+			wantErr: ErrNoRemotes,
+		},
+		{
+			name:        "up to date",
+			local:       remote,
+			remotes:     []*fakeRemote{{name: "a", chain: remote}},
+			wantRemotes: []string{"a"},
+		},
+		{
+			name:        "remote is behind",
+			local:       remote,
+			remotes:     []*fakeRemote{{name: "a", chain: remote[:1]}},
+			wantRemotes: []string{"a"},
+		},
+		{
+			name:        "sync from genesis",
+			remotes:     []*fakeRemote{{name: "a", chain: remote}},
+			wantSynced:  remote,
+			wantRemotes: []string{"a"},
+		},
+		{
+			name:        "resolve fork",
+			local:       local,
+			remotes:     []*fakeRemote{{name: "a", chain: remote}},
+			wantSynced:  remote,
+			wantRemotes: []string{"a"},
+		},
+		{
+			name:  "drop remote with malformed response",
+			local: local,
+			remotes: []*fakeRemote{
+				{name: "a", tipsErr: ErrMalformedResponse},
+				{name: "b", chain: remote},
+			},
+			wantSynced:  remote,
+			wantRemotes: []string{"b"},
+		},
+		{
+			name:  "drop remote with forged headers",
+			local: local,
+			remotes: []*fakeRemote{
+				{name: "a", chain: forged},
+				{name: "b", chain: remote},
+			},
+			wantSynced:  remote,
+			wantRemotes: []string{"b"},
+		},
+		{
+			name:        "drop all misbehaving remotes",
+			remotes:     []*fakeRemote{{name: "a", chain: forged}},
+			wantErr:     ErrRemotesUnreachable,
+			wantRemotes: []string{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			migrated := []encryption.SHA256HexString{}
+			s := newTestSyncService(newMemStore(c.local...), &migrated)
+			for _, r := range c.remotes {
+				s.AddRemote(r)
+			}
+
+			n, err := s.sync(context.Background())
+			if err != c.wantErr {
+				t.Fatalf("Expected error %v, got %v", c.wantErr, err)
+			}
+			if n != len(c.wantSynced) || len(migrated) != len(c.wantSynced) {
+				t.Fatalf("Expected %d synced block(s), got %d (%d migrated)", len(c.wantSynced), n, len(migrated))
+			}
+			for i, b := range c.wantSynced {
+				if migrated[i] != b.ID {
+					t.Errorf("Expected block %s at position %d, got %s", b.ID[:6], i, migrated[i][:6])
+				}
+			}
+			if c.wantRemotes != nil && fmt.Sprint(remoteNames(s)) != fmt.Sprint(c.wantRemotes) {
+				t.Errorf("Expected remotes %v, got %v", c.wantRemotes, remoteNames(s))
+			}
+		})
+	}
+}
+
+func TestSyncSendsForkLocator(t *testing.T) {
+	local := signedChain(t, GenesisBlock, 2)
+	r := &fakeRemote{name: "a", chain: signedChain(t, GenesisBlock, 3)}
+	s := newTestSyncService(newMemStore(local...), &[]encryption.SHA256HexString{})
+	s.AddRemote(r)
+
+	if _, err := s.sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.locators) == 0 {
+		t.Fatal("Expected a locator to be sent")
+	}
+	// The locator starts at our endpoint on the abandoned fork
+	// and ends at the genesis block, which is the common block
+	locator := r.locators[0]
+	if locator[0] != local[1].ID || locator[len(locator)-1] != GenesisBlock.ID {
+		t.Errorf("Expected a locator from our endpoint to genesis, got %v", locator)
+	}
+}
+
+func TestSyncStopsWhenContextIsDone(t *testing.T) {
+	migrated := []encryption.SHA256HexString{}
+	s := newTestSyncService(newMemStore(), &migrated)
+	s.AddRemote(&fakeRemote{name: "a", chain: signedChain(t, GenesisBlock, 3)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n, err := s.Sync(ctx)
+	if err != context.Canceled {
+		t.Fatalf("Expected error %v, got %v", context.Canceled, err)
+	}
+	if n != 0 || len(migrated) != 0 {
+		t.Errorf("Expected no synced blocks, got %d (%d migrated)", n, len(migrated))
+	}
+	if s.progress.initialSyncDone {
+		t.Error("Expected a cancelled pass not to finish the initial sync")
+	}
+}
+
+func TestSyncKeepsRemotesOnStorageErrors(t *testing.T) {
+	s := newTestSyncService(newMemStore(), &[]encryption.SHA256HexString{})
+	s.AddRemote(&fakeRemote{name: "a", chain: signedChain(t, GenesisBlock, 2)})
+	failure := storageError{errors.New("database is offline")}
+	s.migrate = func(b *Block, source string) (bool, error) {
+		return false, failure
+	}
+
+	if _, err := s.sync(context.Background()); err != failure {
+		t.Fatalf("Expected error %v, got %v", failure, err)
+	}
+	if names := remoteNames(s); len(names) != 1 {
+		t.Errorf("Expected the remote to be kept, got %v", names)
+	}
+}
+
+func TestSyncRequestsRemainingBlocks(t *testing.T) {
+	migrated := []encryption.SHA256HexString{}
+	s := newTestSyncService(newMemStore(), &migrated)
+	chain := signedChain(t, GenesisBlock, 3)
+	s.AddRemote(&fakeRemote{name: "a", chain: chain, maxBlocks: 1})
+
+	n, err := s.sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(chain) || len(remoteNames(s)) != 1 {
+		t.Errorf("Expected %d synced blocks from the remote, got %d from %v", len(chain), n, remoteNames(s))
+	}
+}
+
+func TestRequestSync(t *testing.T) {
+	s := newTestSyncService(newMemStore(), &[]encryption.SHA256HexString{})
+	s.AddRemote(&fakeRemote{name: "a", chain: signedChain(t, GenesisBlock, 1)})
+	migrated := make(chan encryption.SHA256HexString, 1)
+	s.migrate = func(b *Block, source string) (bool, error) {
+		migrated <- b.ID
+		return true, nil
+	}
+
+	// Requests are merged, so that requesting doesn't block
+	s.RequestSync()
+	s.RequestSync()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.RunContinuousSync(ctx)
+	select {
+	case <-migrated:
+	case <-time.After(continuousSyncInterval / 2):
+		t.Fatal("Expected the requested sync to run before the next interval")
+	}
+}
@@ -0,0 +1,95 @@
+package blockchain
+
+import (
+	"log"
+
+	"github.com/peerbridge/peerbridge/pkg/encryption/secp256k1"
+	"github.com/peerbridge/peerbridge/pkg/peer"
+)
+
+// Publish a new transaction to all peers except the
+// peer from which the transaction was received.
+func AnnounceNewTransaction(t *Transaction, origin string) {
+	log.Printf("Publish new transaction: %s\n", t.ID[:6])
+	go peer.Service.Publish(NewTransactionMessageType, NewTransactionMessage{t}, origin)
+}
+
+// Publish a new block to all peers except the
+// peer from which the block was received.
+func AnnounceNewBlock(b *Block, origin string) {
+	log.Printf("Publish new block: %s\n", b.ID[:6])
+	go peer.Service.Publish(NewBlockMessageType, NewBlockMessage{b}, origin)
+}
+
+// Validate a transaction that was received on the transactions topic,
+// so that invalid transactions are not forwarded to other peers.
+// The validity of a transaction doesn't depend on the chain, so the
+// forwarding peer could have detected an invalid transaction.
+func validateTransactionMessage(envelope *peer.Envelope) error {
+	var message NewTransactionMessage
+	if err := envelope.Decode(&message); err != nil || message.NewTransaction == nil {
+		return ErrMalformedMessage
+	}
+	t := message.NewTransaction
+	if err := Instance.ValidateTransaction(t); err != nil {
+		return peer.InvalidMessageError{Reason: err}
+	}
+
+	known := false
+	Instance.ThreadSafe(func() {
+		known = Instance.ContainsPendingTransactionByID(t.ID)
+	})
+	if known || Repo.ContainsMainChainTransactionByID(t.ID) {
+		return peer.ErrIgnoreMessage
+	}
+	return nil
+}
+
+// Validate a block that was received on the blocks topic, so that
+// invalid blocks are not forwarded to other peers. Blocks whose parent
+// is unknown cannot be validated completely, so they are not forwarded.
+// Instead, they are kept in the orphan pool and their parent is
+// requested from the forwarding peer.
+//
+// The forwarding peer only forwards blocks whose parent it knows, so
+// it could have detected invalid blocks as well. Only errors that
+// depend on the chain of this node don't penalize the peer.
+func validateBlockMessage(envelope *peer.Envelope) error {
+	var message NewBlockMessage
+	if err := envelope.Decode(&message); err != nil || message.NewBlock == nil {
+		return ErrMalformedMessage
+	}
+	b := message.NewBlock
+	if err := b.CheckFormat(); err != nil {
+		return peer.InvalidMessageError{Reason: err}
+	}
+	if b.ParentID == nil {
+		return peer.InvalidMessageError{Reason: ErrMissingParentID}
+	}
+	if Repo.IsBlockInvalidated(b.ID) {
+		return ErrBlockInvalidated
+	}
+	if Repo.ContainsBlockByID(b.ID) || Instance.Orphans.Contains(b.ID) {
+		return peer.ErrIgnoreMessage
+	}
+
+	if !Repo.ContainsBlockByID(*b.ParentID) {
+		if err := secp256k1.VerifySignature(b, *b.Signature); err != nil {
+			return peer.InvalidMessageError{Reason: err}
+		}
+		go migratePeerBlock(b, envelope.Sender, true)
+		return peer.ErrIgnoreMessage
+	}
+
+	proof, err := Instance.CalculateProof(b)
+	if err == ErrAccountHasNoStake {
+		return peer.InvalidMessageError{Reason: err}
+	}
+	if err != nil {
+		return err
+	}
+	if err := Instance.validateBlockWithProof(b, proof); err != nil {
+		return peer.InvalidMessageError{Reason: err}
+	}
+	return nil
+}
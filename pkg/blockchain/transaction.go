@@ -8,12 +8,28 @@
 	"github.com/peerbridge/peerbridge/pkg/encryption/secp256k1"
 )
 
+const (
+	// The version of legacy transactions, whose signature covers
+	// the transaction data. Their data cannot be pruned.
+	TransactionVersionLegacy = 0
+
+	// The version of transactions whose signature covers the hash of
+	// the transaction data instead of the data, so that the signature
+	// can still be verified after the data was pruned.
+	TransactionVersionDataHash = 1
+
+	// The version of new transactions.
+	TransactionVersion = TransactionVersionDataHash
+)
+
 // A transaction in the blockchain.
 // Transactions are obtained via the http interfaces and
 // forged into blocks to persist them in the blockchain.
 type Transaction struct {
-	// The random id of this transaction, as a unique key.
-	ID encryption.SHA256HexString `json:"id" sign:"yes" pg:",pk,unique,notnull"`
+	// The random id of this transaction. Together with the block id
+	// this forms the primary key, since the same transaction may be
+	// included in blocks of competing forks.
+	ID encryption.SHA256HexString `json:"id" sign:"yes" pg:",pk,notnull"`
 
 	// The sender of this transaction, by address.
 	Sender secp256k1.PublicKeyHexString `json:"sender" sign:"yes" pg:",notnull"`
@@ -31,14 +47,35 @@ type Transaction struct {
 	TimeUnixNano int64 `json:"timeUnixNano" sign:"yes" pg:",notnull,use_zero"`
 
 	// The included transaction data.
+	// This is `nil` if the data was pruned, see `Pruned`.
 	Data *[]byte `json:"data,omitempty" sign:"yes"`
 
+	// The SHA256 hash of the included transaction data.
+	// This field is set when the transaction is persisted
+	// and is kept when the data is pruned. The signature of
+	// a pruned transaction is verified with this hash.
+	DataHash *encryption.SHA256HexString `json:"dataHash,omitempty" sign:"no"`
+
+	// If the transaction data was pruned from this node.
+	// Pruned data can be obtained from archival nodes
+	// and checked against the data hash.
+	Pruned bool `json:"pruned,omitempty" sign:"no" pg:",notnull,use_zero"`
+
 	// The transaction fee.
 	Fee uint64 `json:"fee" sign:"yes" pg:",notnull,use_zero"`
 
+	// The version of the transaction, which determines what its
+	// signature covers. See `TransactionVersionDataHash`.
+	Version int `json:"version,omitempty" sign:"yes" pg:",notnull,use_zero"`
+
 	// The signature of the transaction.
 	Signature *secp256k1.SignatureHexString `json:"signature" sign:"no" pg:",notnull"`
 
+	// The position of the transaction in the block where it is included.
+	// The block signature covers the transactions in this order,
+	// so they are always loaded from the database in this order.
+	Position int `json:"-" sign:"no" pg:",notnull,use_zero"`
+
 	// The block id of the block where this transaction is included.
 	// This field is `nil` until the transaction is included into
 	// a block.
@@ -49,7 +86,86 @@ func (t *Transaction) GetSender() secp256k1.PublicKeyHexString {
 	return t.Sender
 }
 
+// Check that the identifiers, keys and signature of the
+// transaction are well-formed hex strings. This does not
+// verify the signature.
+func (t *Transaction) CheckFormat() error {
+	if !isHexOfLength(t.ID, encryption.SHA256ByteLength) {
+		return ErrMalformedTransaction
+	}
+	if !isHexOfLength(t.Sender, secp256k1.PublicKeyByteLength) {
+		return ErrMalformedTransaction
+	}
+	if !isHexOfLength(t.Receiver, secp256k1.PublicKeyByteLength) {
+		return ErrMalformedTransaction
+	}
+	if t.Signature == nil || !isHexOfLength(*t.Signature, secp256k1.SignatureByteLength) {
+		return ErrMalformedTransaction
+	}
+	if t.Version < TransactionVersionLegacy || t.Version > TransactionVersion {
+		return ErrUnknownTransactionVersion
+	}
+	return nil
+}
+
+// Check if the data of the transaction can be pruned, without
+// losing the ability to verify the signature of the transaction.
+func (t *Transaction) IsPrunable() bool {
+	return t.Version >= TransactionVersionDataHash
+}
+
+// Verify the signature of the transaction. The signature of a
+// pruned transaction is verified with its stored data hash.
+func (t *Transaction) VerifySignature() error {
+	if t.Signature == nil {
+		return ErrMissingSignature
+	}
+	if t.Pruned && !t.IsPrunable() {
+		return ErrTransactionPruned
+	}
+	return secp256k1.VerifySignature(t, *t.Signature)
+}
+
+// Check if a string is hex encoded and decodes to the given byte length.
+func isHexOfLength(s string, byteLength int) bool {
+	if len(s) != hex.EncodedLen(byteLength) {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// Compute the hash of the included transaction data.
+// Returns `nil` if the transaction includes no data.
+func (t *Transaction) ComputeDataHash() *encryption.SHA256HexString {
+	if t.Data == nil {
+		return nil
+	}
+	hash := encryption.HashToSHA256HexString(*t.Data)
+	return &hash
+}
+
+// Get the hash of the transaction data that is covered by the
+// signature. The hash is computed from the data, unless the data
+// was pruned, in which case the stored data hash is used.
+func (t *Transaction) signedDataHash() string {
+	if t.Data != nil {
+		return *t.ComputeDataHash()
+	}
+	if t.Pruned && t.DataHash != nil {
+		return *t.DataHash
+	}
+	return ""
+}
+
 func (t *Transaction) GetSignString() string {
+	if t.IsPrunable() {
+		return fmt.Sprintf(
+			"id:%s|sender:%s|receiver:%s|balance:%d|timeUnixNano:%d|dataHash:%s|fee:%d|version:%d",
+			t.ID, t.Sender, t.Receiver, t.Balance, t.TimeUnixNano, t.signedDataHash(), t.Fee, t.Version,
+		)
+	}
+
 	var dataStr = ""
 	if t.Data != nil {
 		dataStr = hex.EncodeToString(*t.Data)
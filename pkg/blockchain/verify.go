@@ -0,0 +1,202 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/peerbridge/peerbridge/pkg/encryption"
+	"github.com/peerbridge/peerbridge/pkg/encryption/secp256k1"
+)
+
+// The number of main chain blocks that are loaded at once
+// during the verification.
+const verificationBatchSize = 100
+
+var (
+	ErrChainVerificationFailed = errors.New("Chain verification failed!")
+)
+
+// An inconsistency that was found during a chain verification.
+type Inconsistency struct {
+	// The id of the inconsistent block.
+	BlockID encryption.SHA256HexString `json:"blockID"`
+
+	// The height of the inconsistent block.
+	Height uint64 `json:"height"`
+
+	// The name of the check that failed.
+	Check string `json:"check"`
+
+	// A description of the inconsistency.
+	Message string `json:"message"`
+}
+
+// The machine readable report of a chain verification.
+type VerificationReport struct {
+	// If the verified chain is consistent.
+	OK bool `json:"ok"`
+
+	// The number of blocks that were verified.
+	VerifiedBlocks int `json:"verifiedBlocks"`
+
+	// The number of verified blocks with pruned transaction data,
+	// whose signatures were verified with the data hashes.
+	PrunedBlocks int `json:"prunedBlocks"`
+
+	// The id of the main chain head.
+	HeadID encryption.SHA256HexString `json:"headID"`
+
+	// The height of the main chain head.
+	HeadHeight uint64 `json:"headHeight"`
+
+	// The first inconsistency that was found.
+	// This is `nil` if the chain is consistent.
+	Inconsistency *Inconsistency `json:"inconsistency,omitempty"`
+}
+
+// Verify the stored main chain from genesis to its head.
+//
+// Every block is validated in the same way as new blocks (proof,
+// signature and transactions, where the signatures of blocks with
+// pruned transaction data are verified with the data hashes) and
+// its stored parent link, height,
+// target, challenge and cumulative difficulty are compared against
+// the recalculated values. The stakes of the block creators are
+// tracked along the chain, so that the chain is only walked once.
+//
+// The verification stops at the first inconsistency.
+// An error is only returned if the verification could not be run.
+func (chain *Blockchain) VerifyChain() (*VerificationReport, error) {
+	head, err := Repo.GetMainChainEndpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &VerificationReport{HeadID: head.ID, HeadHeight: head.Height}
+	stakes := map[secp256k1.PublicKeyHexString]int64{}
+	var parent *Block
+
+	ids, err := Repo.GetMainChainIDsInRange(0, head.Height)
+	if err != nil {
+		return nil, err
+	}
+
+	for start := 0; start < len(ids); start += verificationBatchSize {
+		end := start + verificationBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		blocks, err := Repo.GetBlocksByIDs(ids[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for i := range *blocks {
+			b := &(*blocks)[i]
+			inconsistency := chain.verifyBlock(b, parent, stakes)
+			if inconsistency != nil {
+				report.Inconsistency = inconsistency
+				return report, nil
+			}
+			report.VerifiedBlocks++
+			if b.IsPruned() {
+				report.PrunedBlocks++
+			}
+			parent = b
+		}
+	}
+
+	if uint64(report.VerifiedBlocks) != head.Height+1 {
+		report.Inconsistency = &Inconsistency{
+			BlockID: head.ID,
+			Height:  head.Height,
+			Check:   "chain",
+			Message: fmt.Sprintf("Expected %d main chain blocks, found %d", head.Height+1, report.VerifiedBlocks),
+		}
+		return report, nil
+	}
+
+	report.OK = true
+	return report, nil
+}
+
+// Verify a single main chain block against its parent.
+// The stakes are updated with the transactions of the block.
+func (chain *Blockchain) verifyBlock(
+	b *Block,
+	parent *Block,
+	stakes map[secp256k1.PublicKeyHexString]int64,
+) *Inconsistency {
+	fail := func(check string, format string, a ...interface{}) *Inconsistency {
+		return &Inconsistency{
+			BlockID: b.ID,
+			Height:  b.Height,
+			Check:   check,
+			Message: fmt.Sprintf(format, a...),
+		}
+	}
+
+	if parent == nil {
+		// The chain must start with our genesis block
+		if b.ID != GenesisBlock.ID || b.ParentID != nil || b.Height != GenesisHeight {
+			return fail("genesis", "Block is not the genesis block")
+		}
+	} else {
+		if b.ParentID == nil || *b.ParentID != parent.ID {
+			return fail("parent", "Block does not link to the previous main chain block %s", parent.ID)
+		}
+		if b.Height != parent.Height+1 {
+			return fail("height", "Expected height %d, got %d", parent.Height+1, b.Height)
+		}
+		if b.TimeUnixNano <= parent.TimeUnixNano {
+			return fail("time", "Block was created before its parent")
+		}
+
+		// The stake of the creator until the parent block is
+		// taken from the tracked stakes instead of the database
+		proof, err := calculateProof(b, parent, stakes[b.Creator])
+		if err == nil {
+			if b.IsPruned() {
+				// Pruned transactions are not accepted in new blocks,
+				// so only the proof and the signatures are checked
+				err = proof.Validate()
+				if err == nil {
+					err = b.VerifySignatures()
+				}
+			} else {
+				err = chain.validateBlockWithProof(b, proof)
+			}
+		}
+		if err != nil {
+			return fail("validation", "%s", err)
+		}
+		if b.Challenge != proof.Challenge {
+			return fail("challenge", "Expected challenge %s, got %s", proof.Challenge, b.Challenge)
+		}
+		if b.Target != proof.Target {
+			return fail("target", "Expected target %d, got %d", proof.Target, b.Target)
+		}
+		if b.CumulativeDifficulty != proof.CumulativeDifficulty {
+			return fail(
+				"cumulativeDifficulty", "Expected cumulative difficulty %d, got %d",
+				proof.CumulativeDifficulty, b.CumulativeDifficulty,
+			)
+		}
+	}
+
+	// Note: balances may become negative, since this is not
+	// rejected when blocks are validated, see `ValidateBlock`
+	stakes[b.Creator] += BlockReward
+
+	for _, t := range b.Transactions {
+		if t.BlockID != nil && *t.BlockID != b.ID {
+			return fail("transaction", "Transaction %s belongs to block %s", t.ID, *t.BlockID)
+		}
+		// FIXME: Theoretically, this could overflow
+		// with very high fees or balances
+		stakes[t.Sender] -= int64(t.Balance)
+		stakes[t.Sender] -= int64(t.Fee)
+		stakes[t.Receiver] += int64(t.Balance)
+	}
+
+	return nil
+}
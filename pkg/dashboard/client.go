@@ -46,7 +46,10 @@ func (c *Client) PublishNewMessages() {
 	ticker := time.NewTicker(pingPeriod)
 	defer func() {
 		ticker.Stop()
-		Hub.unregister <- c
+		select {
+		case Hub.unregister <- c:
+		case <-Hub.stopped:
+		}
 		c.conn.Close()
 	}()
 	for {
@@ -91,7 +94,12 @@ func BindNewClient(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	client := &Client{conn: conn, send: make(chan []byte, 256)}
-	Hub.register <- client
+	select {
+	case Hub.register <- client:
+	case <-Hub.stopped:
+		conn.Close()
+		return
+	}
 
 	go client.PublishNewMessages()
 }
@@ -1,5 +1,7 @@
 package dashboard
 
+import "context"
+
 // Hub maintains the set of active clients and
 // broadcasts messages to the clients.
 type HubService struct {
@@ -7,6 +9,10 @@ type HubService struct {
 	broadcast  chan []byte
 	register   chan *Client
 	unregister chan *Client
+
+	// Closed when the hub was stopped, so that
+	// senders to the hub don't block afterwards.
+	stopped chan struct{}
 }
 
 var Hub = &HubService{
@@ -14,11 +20,21 @@ type HubService struct {
 	register:   make(chan *Client),
 	unregister: make(chan *Client),
 	clients:    make(map[*Client]bool),
+	stopped:    make(chan struct{}),
 }
 
-func RunHub() {
+// Run the hub until the given context is done.
+// Afterwards, the connections of all clients are closed.
+func RunHub(ctx context.Context) {
 	for {
 		select {
+		case <-ctx.Done():
+			close(Hub.stopped)
+			for client := range Hub.clients {
+				close(client.send)
+				delete(Hub.clients, client)
+			}
+			return
 		case client := <-Hub.register:
 			Hub.clients[client] = true
 		case client := <-Hub.unregister:
@@ -40,5 +56,8 @@ func RunHub() {
 }
 
 func Publish(message []byte) {
-	Hub.broadcast <- message
+	select {
+	case Hub.broadcast <- message:
+	case <-Hub.stopped:
+	}
 }
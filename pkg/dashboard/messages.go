@@ -1,24 +1,37 @@
 package dashboard
 
-import "github.com/peerbridge/peerbridge/pkg/peer"
+import (
+	"context"
+
+	"github.com/peerbridge/peerbridge/pkg/peer"
+)
 
 func reactToPeerMessage(bytes []byte) {
-	Hub.broadcast <- bytes
+	Publish(bytes)
 }
 
-// Bind the blockchain to new messages from the peer.
-func ReactToPeerMessages() {
-	channel := make(chan []byte)
+// Bind the dashboard to new messages from the peer, until the
+// given context is done. The dashboard only shows the latest
+// messages, so the oldest messages are dropped if it is too slow.
+func ReactToPeerMessages(ctx context.Context) {
+	options := peer.SubscriptionOptions{Policy: peer.DropOldest}
+	options.Name = "dashboard/incoming"
+	incoming := peer.Service.SubscribeIncoming(options)
+	options.Name = "dashboard/outgoing"
+	outgoing := peer.Service.SubscribeOutgoing(options)
 
 	go func() {
+		defer incoming.Unsubscribe()
+		defer outgoing.Unsubscribe()
 		for {
 			select {
-			case message := <-channel:
+			case <-ctx.Done():
+				return
+			case message := <-incoming.Messages():
+				reactToPeerMessage(message)
+			case message := <-outgoing.Messages():
 				reactToPeerMessage(message)
 			}
 		}
 	}()
-
-	peer.Service.SubscribeIncoming(channel)
-	peer.Service.SubscribeOutgoing(channel)
 }
@@ -66,7 +66,7 @@ func (input *SigningInput) VerifySignature(s SignatureHexString, sender PublicKe
 		return err
 	}
 	if len(signatureBytes) != SignatureByteLength {
-		panic("The signature should always have length 64!")
+		return ErrWrongSignatureLength
 	}
 
 	senderBytes, err := hex.DecodeString(sender)
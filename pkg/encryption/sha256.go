@@ -1,6 +1,7 @@
 package encryption
 
 import (
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"math/rand"
@@ -34,6 +35,12 @@ func RandomSHA256HexString() (*SHA256HexString, error) {
 	return &hashString, nil
 }
 
+// Hash the given data with the SHA256 hashing algorithm.
+func HashToSHA256HexString(data []byte) SHA256HexString {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
 func SHA256HexStringToBytes(hexString string) (*[SHA256ByteLength]byte, error) {
 	bytes, err := hex.DecodeString(hexString)
 	if err != nil {
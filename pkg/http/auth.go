@@ -0,0 +1,52 @@
+package http
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	AUTHORIZATION = "Authorization"
+	BEARER        = "Bearer "
+)
+
+// Get the token which is required to access admin routes.
+// The token can be configured by setting the environment
+// variable `ADMIN_TOKEN`. If no token is configured,
+// admin routes are disabled.
+func GetAdminToken() string {
+	return os.Getenv("ADMIN_TOKEN")
+}
+
+// Restrict a handler function to authenticated admin requests.
+// Requests must supply the admin token as a bearer token
+// in the authorization header.
+//
+// This returns:
+// - 403 Forbidden if no admin token is configured on this node
+// - 401 Unauthorized if the supplied token does not match
+func Authenticated(handlerFunc func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := GetAdminToken()
+		if token == "" {
+			Forbidden(w, errors.New("Admin routes are disabled, no admin token is configured!"))
+			return
+		}
+
+		header := r.Header.Get(AUTHORIZATION)
+		if !strings.HasPrefix(header, BEARER) {
+			Unauthorized(w, errors.New("Missing admin token!"))
+			return
+		}
+		supplied := strings.TrimPrefix(header, BEARER)
+		if subtle.ConstantTimeCompare([]byte(supplied), []byte(token)) != 1 {
+			Unauthorized(w, errors.New("Invalid admin token!"))
+			return
+		}
+
+		handlerFunc(w, r)
+	}
+}
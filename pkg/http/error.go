@@ -21,3 +21,18 @@ func BadRequest(w http.ResponseWriter, err error) {
 	log.Println(err.Error())
 	http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 }
+
+func Unauthorized(w http.ResponseWriter, err error) {
+	log.Println(err.Error())
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+func Forbidden(w http.ResponseWriter, err error) {
+	log.Println(err.Error())
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+}
+
+func Gone(w http.ResponseWriter, err error) {
+	log.Println(err.Error())
+	http.Error(w, http.StatusText(http.StatusGone), http.StatusGone)
+}
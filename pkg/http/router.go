@@ -1,14 +1,20 @@
 package http
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"time"
 )
 
 const DefaultPort = "8080"
 
+// The time that in-flight requests get to finish,
+// when the server is shut down.
+const ShutdownTimeout = 10 * time.Second
+
 type Router struct {
 	mux         *http.ServeMux
 	routes      []Route
@@ -106,3 +112,35 @@ func (r *Router) ListenAndServe() error {
 	srv := http.Server{Addr: addr, Handler: r}
 	return srv.ListenAndServe()
 }
+
+// `ServeContext` accepts incoming HTTP connections on the listener l
+// until the given context is done. Afterwards, the server stops
+// accepting connections and waits for in-flight requests to finish,
+// for up to `ShutdownTimeout`.
+func (r *Router) ServeContext(ctx context.Context, l net.Listener) error {
+	srv := http.Server{Handler: r}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(l)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// `ListenAndServeContext` launches the default http server
+// and shuts it down gracefully when the given context is done.
+func (r *Router) ListenAndServeContext(ctx context.Context) error {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%s", GetServerPort()))
+	if err != nil {
+		return err
+	}
+	return r.ServeContext(ctx, l)
+}
@@ -0,0 +1,57 @@
+package http
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServeContextDrainsRequests(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	router := NewRouter()
+	router.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- router.ServeContext(ctx, l)
+	}()
+
+	responses := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://" + l.Addr().String() + "/slow")
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		responses <- string(body)
+	}()
+
+	// Shut down while the request is in flight
+	<-started
+	cancel()
+
+	if body := <-responses; body != "done" {
+		t.Errorf("Expected the in-flight request to finish, got %q", body)
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("Expected a clean shutdown, got %v", err)
+	}
+	if _, err := http.Get("http://" + l.Addr().String() + "/slow"); err == nil {
+		t.Error("Expected no new requests to be accepted after the shutdown")
+	}
+}
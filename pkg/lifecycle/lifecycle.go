@@ -0,0 +1,150 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/peerbridge/peerbridge/pkg/color"
+)
+
+// The default time that a service gets to stop,
+// before the next service is stopped anyway.
+const DefaultStopTimeout = 15 * time.Second
+
+var (
+	ErrStopTimeout = errors.New("Service did not stop in time!")
+)
+
+// A long running service of the node.
+type Service struct {
+	// The name of the service, which is logged.
+	Name string
+
+	// Run the service until the given context is done.
+	// The function should return nil after the context
+	// is done, and an error if the service failed.
+	Run func(ctx context.Context) error
+}
+
+// Create a service from a function which runs until the
+// given context is done, and which cannot fail.
+func Func(name string, run func(ctx context.Context)) Service {
+	return Service{Name: name, Run: func(ctx context.Context) error {
+		run(ctx)
+		return nil
+	}}
+}
+
+// A running service.
+type running struct {
+	service Service
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// A lifecycle which starts services in order, and stops
+// them in the reverse order, so that every service can
+// rely on the services that were started before it.
+type Lifecycle struct {
+	// The time that a service gets to stop.
+	StopTimeout time.Duration
+
+	running []*running
+
+	// Closed when one of the services failed.
+	failed chan struct{}
+
+	// The error of the first failed service.
+	err error
+
+	once  sync.Once
+	mutex sync.Mutex
+}
+
+// Create a new lifecycle without services.
+func New() *Lifecycle {
+	return &Lifecycle{
+		StopTimeout: DefaultStopTimeout,
+		failed:      make(chan struct{}),
+	}
+}
+
+// Start the given services in order. Every service
+// runs in its own goroutine with its own context.
+func (l *Lifecycle) Start(services ...Service) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, service := range services {
+		ctx, cancel := context.WithCancel(context.Background())
+		r := &running{service: service, cancel: cancel, done: make(chan struct{})}
+		l.running = append(l.running, r)
+		go func() {
+			defer close(r.done)
+			err := r.service.Run(ctx)
+			if err == nil || ctx.Err() != nil {
+				return
+			}
+			log.Println(color.Sprintf(
+				fmt.Sprintf("Service %s failed: %s", r.service.Name, err),
+				color.Error,
+			))
+			l.once.Do(func() {
+				l.err = err
+				close(l.failed)
+			})
+		}()
+	}
+}
+
+// Wait until the given context is done or one of the services
+// failed, and stop all services afterwards. Returns the error of
+// the first failed service, if any.
+func (l *Lifecycle) Run(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+	case <-l.failed:
+	}
+	return l.Stop()
+}
+
+// Stop all services in the reverse order in which they were
+// started. A service which does not stop in time is left
+// behind, and the next service is stopped anyway.
+// Returns `ErrStopTimeout` if a service was left behind, so that
+// the resources it may still use are not released. Otherwise,
+// returns the error of the first failed service, if any.
+func (l *Lifecycle) Stop() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	timedOut := false
+	for i := len(l.running) - 1; i >= 0; i-- {
+		r := l.running[i]
+		log.Printf("Stopping %s...\n", color.Sprintf(r.service.Name, color.Info))
+		r.cancel()
+		select {
+		case <-r.done:
+		case <-time.After(l.StopTimeout):
+			log.Println(color.Sprintf(
+				fmt.Sprintf("Service %s did not stop in time.", r.service.Name),
+				color.Warning,
+			))
+			timedOut = true
+		}
+	}
+	l.running = nil
+
+	if timedOut {
+		return ErrStopTimeout
+	}
+	select {
+	case <-l.failed:
+		return l.err
+	default:
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A service that records when it was started and stopped.
+func recorded(name string, events *[]string, mutex *sync.Mutex) Service {
+	record := func(event string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		*events = append(*events, event)
+	}
+	return Service{Name: name, Run: func(ctx context.Context) error {
+		record("start " + name)
+		<-ctx.Done()
+		record("stop " + name)
+		return nil
+	}}
+}
+
+func TestServicesStopInReverseOrder(t *testing.T) {
+	var events []string
+	var mutex sync.Mutex
+	l := New()
+	l.Start(recorded("a", &events, &mutex))
+	// Wait until the first service is started
+	for {
+		mutex.Lock()
+		started := len(events) == 1
+		mutex.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	l.Start(recorded("b", &events, &mutex))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"start a", "start b", "stop b", "stop a"}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected events %v, got %v", expected, events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Fatalf("Expected events %v, got %v", expected, events)
+		}
+	}
+}
+
+func TestFailedServiceStopsLifecycle(t *testing.T) {
+	failure := errors.New("failure")
+	stopped := false
+	l := New()
+	l.Start(
+		Service{Name: "stable", Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			stopped = true
+			return nil
+		}},
+		Service{Name: "failing", Run: func(ctx context.Context) error {
+			return failure
+		}},
+	)
+
+	if err := l.Run(context.Background()); err != failure {
+		t.Errorf("Expected %v, got %v", failure, err)
+	}
+	if !stopped {
+		t.Error("Expected the other services to be stopped")
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	l := New()
+	l.StopTimeout = 10 * time.Millisecond
+	l.Start(Service{Name: "stuck", Run: func(ctx context.Context) error {
+		<-block
+		return nil
+	}})
+
+	if err := l.Stop(); err != ErrStopTimeout {
+		t.Errorf("Expected %v, got %v", ErrStopTimeout, err)
+	}
+}
+
+func TestStopTimeoutTakesPrecedence(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	l := New()
+	l.StopTimeout = 10 * time.Millisecond
+	l.Start(
+		Service{Name: "stuck", Run: func(ctx context.Context) error {
+			<-block
+			return nil
+		}},
+		Service{Name: "failing", Run: func(ctx context.Context) error {
+			return errors.New("Failed!")
+		}},
+	)
+
+	// A failed service must not hide a service which is still running
+	if err := l.Run(context.Background()); err != ErrStopTimeout {
+		t.Errorf("Expected %v, got %v", ErrStopTimeout, err)
+	}
+}
@@ -0,0 +1,162 @@
+package peer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/peerbridge/peerbridge/pkg/color"
+)
+
+const (
+	// The delay before the first retry, if no bootstrap
+	// peer could be reached. The delay doubles on every
+	// failed attempt, up to the maximum delay.
+	bootstrapMinRetryDelay = 1 * time.Second
+	bootstrapMaxRetryDelay = 1 * time.Minute
+
+	// The interval in which disconnected static peers are reconnected.
+	staticPeerInterval = 30 * time.Second
+
+	// The tag with which static peers are protected in the
+	// connection manager, so that they are never pruned.
+	staticPeerTag = "peerbridge/static"
+)
+
+// The peers that the p2p service connects to on startup,
+// and how other peers are discovered.
+type BootstrapConfig struct {
+	// The peers from which the dht is bootstrapped.
+	Peers []peer.AddrInfo
+
+	// The peers which are always kept connected.
+	StaticPeers []peer.AddrInfo
+
+	// A blockchain node whose peer urls are requested via http,
+	// if no bootstrap peers are given. This is kept for nodes
+	// which are still bootstrapped with the `--sync` host.
+	Host string
+
+	// Whether peers in the local network are discovered via mDNS.
+	MDNS bool
+
+	// The number of bound peers to keep, or 0 for `DefaultTargetPeers`.
+	TargetPeers int
+}
+
+// Parse multiaddresses with peer ids into peer infos,
+// e.g. /ip4/1.2.3.4/tcp/9080/p2p/Qm... Addresses of the
+// same peer are merged into a single peer info.
+func ParsePeerAddrs(addresses []string) ([]peer.AddrInfo, error) {
+	addrs := []multiaddr.Multiaddr{}
+	for _, address := range addresses {
+		addr, err := multiaddr.NewMultiaddr(address)
+		if err != nil {
+			return nil, ErrInvalidPeerAddress
+		}
+		addrs = append(addrs, addr)
+	}
+	infos, err := peer.AddrInfosFromP2pAddrs(addrs...)
+	if err != nil {
+		return nil, ErrInvalidPeerAddress
+	}
+	return infos, nil
+}
+
+// Connect to the bootstrap peers in the background. If none of them
+// can be reached, the connection is retried with an increasing delay.
+// The returned channel is closed after the first successful attempt,
+// or immediately if no bootstrap peers are configured.
+func (service *P2PService) bootstrap(config BootstrapConfig) <-chan struct{} {
+	done := make(chan struct{})
+	if len(config.Peers) == 0 && config.Host == "" {
+		close(done)
+		return done
+	}
+
+	go func() {
+		delay := bootstrapMinRetryDelay
+		for !service.connectBootstrapPeers(config) {
+			log.Println(color.Sprintf(
+				fmt.Sprintf("No bootstrap peer could be reached, retrying in %s...", delay),
+				color.Warning,
+			))
+			select {
+			case <-service.ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay *= 2
+			if delay > bootstrapMaxRetryDelay {
+				delay = bootstrapMaxRetryDelay
+			}
+		}
+		close(done)
+	}()
+	return done
+}
+
+// Connect to all bootstrap peers. Returns true if
+// at least one bootstrap peer could be reached.
+func (service *P2PService) connectBootstrapPeers(config BootstrapConfig) bool {
+	peers := config.Peers
+	if len(peers) == 0 {
+		urls, err := service.requestPeerURLs(config.Host)
+		if err != nil {
+			return false
+		}
+		peers, err = ParsePeerAddrs(urls)
+		if err != nil {
+			return false
+		}
+	}
+
+	connected := false
+	for _, info := range peers {
+		ctx, cancel := context.WithTimeout(service.ctx, connectTimeout)
+		err := service.host.Connect(ctx, info)
+		cancel()
+		if err != nil {
+			continue
+		}
+		log.Printf(
+			"Connected to the bootstrap peer %s\n",
+			color.Sprintf(fmt.Sprintf("%s", info.ID), color.Notice),
+		)
+		connected = true
+	}
+	return connected
+}
+
+// Keep the static peers connected and bound. Disconnected
+// static peers are reconnected periodically.
+func (service *P2PService) keepStaticPeers(peers []peer.AddrInfo) {
+	if len(peers) == 0 {
+		return
+	}
+	for _, info := range peers {
+		service.host.ConnManager().Protect(info.ID, staticPeerTag)
+	}
+
+	for {
+		for _, info := range peers {
+			if service.isBound(info.ID) {
+				continue
+			}
+			if err := service.connect(info); err != nil {
+				log.Printf(
+					"Static peer %s is offline (reason: %s)\n",
+					color.Sprintf(fmt.Sprintf("%s", info.ID), color.Warning), err,
+				)
+			}
+		}
+		select {
+		case <-service.ctx.Done():
+			return
+		case <-time.After(staticPeerInterval):
+		}
+	}
+}
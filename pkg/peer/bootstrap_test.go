@@ -0,0 +1,28 @@
+package peer
+
+import "testing"
+
+func TestParsePeerAddrs(t *testing.T) {
+	id := "QmYyQSo1c1Ym7orWxLYvCrM2EmxFTANf8wXmmE7DWjhx5N"
+	infos, err := ParsePeerAddrs([]string{
+		"/ip4/192.168.0.12/tcp/9080/p2p/" + id,
+		"/ip4/10.0.0.12/tcp/9080/p2p/" + id,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || len(infos[0].Addrs) != 2 {
+		t.Errorf("Expected the addresses of the same peer to be merged, got %v", infos)
+	}
+	if infos[0].ID.Pretty() != id {
+		t.Errorf("Expected peer id %s, got %s", id, infos[0].ID.Pretty())
+	}
+
+	// Bootstrap peers must be given with their peer id
+	if _, err := ParsePeerAddrs([]string{"/ip4/192.168.0.12/tcp/9080"}); err != ErrInvalidPeerAddress {
+		t.Errorf("Expected %v, got %v", ErrInvalidPeerAddress, err)
+	}
+	if _, err := ParsePeerAddrs([]string{"not an address"}); err != ErrInvalidPeerAddress {
+		t.Errorf("Expected %v, got %v", ErrInvalidPeerAddress, err)
+	}
+}
@@ -0,0 +1,394 @@
+package peer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	host "github.com/libp2p/go-libp2p-host"
+	"github.com/peerbridge/peerbridge/pkg/color"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	discovery "github.com/libp2p/go-libp2p-discovery"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// The number of bound peers that the connection manager
+	// keeps. Discovered peers are only connected while the
+	// number of bound peers is below the target.
+	DefaultTargetPeers = 8
+
+	// The number of bound peers from which on the
+	// connectivity of the node is considered healthy.
+	MinHealthyPeers = 2
+
+	// The delay before the first reconnection to a peer.
+	// The delay doubles on every failed attempt, up to
+	// the maximum delay.
+	reconnectMinDelay = 1 * time.Second
+	reconnectMaxDelay = 5 * time.Minute
+
+	// The number of failed reconnections after
+	// which a disconnected peer is forgotten.
+	maxReconnectAttempts = 16
+
+	// The interval in which the dht discovery is run
+	// again, while the node has less peers than the target.
+	discoveryInterval = 1 * time.Minute
+
+	// The interval in which the connection manager
+	// reconnects peers and checks the connectivity.
+	connManagerInterval = 1 * time.Second
+)
+
+// The connectivity states of a node.
+const (
+	// The node has no bound peers.
+	ConnectivityIsolated = "isolated"
+
+	// The node has less than `MinHealthyPeers` bound peers.
+	ConnectivityDegraded = "degraded"
+
+	// The node has at least `MinHealthyPeers` bound peers.
+	ConnectivityHealthy = "healthy"
+)
+
+// The connectivity health of a node.
+type ConnectivityHealth struct {
+	// The connectivity state, either "isolated",
+	// "degraded" or "healthy".
+	State string `json:"state"`
+
+	// The number of bound peers.
+	Peers int `json:"peers"`
+
+	// The number of bound peers that the node keeps.
+	TargetPeers int `json:"targetPeers"`
+
+	// The number of disconnected peers which are reconnected.
+	Reconnecting int `json:"reconnecting"`
+
+	// The time of the last dht discovery, if it was run.
+	LastDiscovery *time.Time `json:"lastDiscovery"`
+}
+
+// Get the connectivity state for the given number of bound peers.
+func connectivityState(peers int) string {
+	switch {
+	case peers == 0:
+		return ConnectivityIsolated
+	case peers < MinHealthyPeers:
+		return ConnectivityDegraded
+	}
+	return ConnectivityHealthy
+}
+
+// Get the delay before the next reconnection to a peer,
+// after the given number of failed reconnections.
+func reconnectDelay(failures int) time.Duration {
+	delay := reconnectMinDelay
+	for i := 0; i < failures; i++ {
+		delay *= 2
+		if delay >= reconnectMaxDelay {
+			return reconnectMaxDelay
+		}
+	}
+	return delay
+}
+
+// A disconnected peer which is reconnected.
+type disconnectedPeer struct {
+	// The addresses of the peer when it disconnected. They are kept
+	// here, since the peerstore forgets them after a few minutes.
+	addrs []multiaddr.Multiaddr
+
+	// The number of failed reconnections.
+	failures int
+
+	// The time of the next reconnection.
+	retryAt time.Time
+
+	// Whether the peer is currently dialed.
+	dialing bool
+}
+
+// The state of the connection manager, which tracks
+// the disconnected peers that should be reconnected.
+type connManager struct {
+	// The number of bound peers to keep.
+	target int
+
+	// The disconnected peers by id.
+	disconnected map[peer.ID]*disconnectedPeer
+
+	// The peers that should not be reconnected when they
+	// disconnect next, e.g. because they were banned.
+	dropped map[peer.ID]bool
+
+	// The time of the last dht discovery.
+	lastDiscovery time.Time
+
+	mutex sync.Mutex
+}
+
+func newConnManager(target int) *connManager {
+	return &connManager{
+		target:       target,
+		disconnected: map[peer.ID]*disconnectedPeer{},
+		dropped:      map[peer.ID]bool{},
+	}
+}
+
+// Track a disconnected peer, so that it is reconnected
+// using the given addresses.
+func (m *connManager) disconnect(info peer.AddrInfo, now time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.dropped[info.ID] {
+		delete(m.dropped, info.ID)
+		return
+	}
+	if _, ok := m.disconnected[info.ID]; ok {
+		return
+	}
+	m.disconnected[info.ID] = &disconnectedPeer{
+		addrs:   info.Addrs,
+		retryAt: now.Add(reconnectDelay(0)),
+	}
+}
+
+// Stop reconnecting a peer which was bound again.
+func (m *connManager) connect(id peer.ID) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.disconnected, id)
+}
+
+// Don't reconnect a peer when it disconnects next.
+func (m *connManager) drop(id peer.ID) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.dropped[id] = true
+	delete(m.disconnected, id)
+}
+
+// Get the disconnected peers which should be reconnected
+// at the given time. The peers are marked as dialing.
+func (m *connManager) due(now time.Time) []peer.AddrInfo {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	infos := []peer.AddrInfo{}
+	for id, p := range m.disconnected {
+		if p.dialing || now.Before(p.retryAt) {
+			continue
+		}
+		p.dialing = true
+		infos = append(infos, peer.AddrInfo{ID: id, Addrs: p.addrs})
+	}
+	return infos
+}
+
+// Report the result of a reconnection. Peers which were
+// reconnected, or failed too often, are no longer tracked.
+// Returns true if the peer is tracked further.
+func (m *connManager) dialed(id peer.ID, err error, now time.Time) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	p, ok := m.disconnected[id]
+	if !ok {
+		return false
+	}
+	if err == nil {
+		delete(m.disconnected, id)
+		return false
+	}
+	p.dialing = false
+	p.failures++
+	if p.failures >= maxReconnectAttempts {
+		delete(m.disconnected, id)
+		return false
+	}
+	p.retryAt = now.Add(reconnectDelay(p.failures))
+	return true
+}
+
+// Set the number of bound peers to keep.
+func (m *connManager) setTarget(target int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.target = target
+}
+
+// Check if more peers should be connected, given the number of bound peers.
+func (m *connManager) needsPeers(peers int) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return peers < m.target
+}
+
+// Check if the dht discovery should be run again at the given time.
+// If so, the time is recorded as the time of the last discovery.
+func (m *connManager) shouldDiscover(peers int, now time.Time) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if peers >= m.target || now.Sub(m.lastDiscovery) < discoveryInterval {
+		return false
+	}
+	m.lastDiscovery = now
+	return true
+}
+
+// Get the connectivity health for the given number of bound peers.
+func (m *connManager) health(peers int) ConnectivityHealth {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	health := ConnectivityHealth{
+		State:        connectivityState(peers),
+		Peers:        peers,
+		TargetPeers:  m.target,
+		Reconnecting: len(m.disconnected),
+	}
+	if !m.lastDiscovery.IsZero() {
+		lastDiscovery := m.lastDiscovery
+		health.LastDiscovery = &lastDiscovery
+	}
+	return health
+}
+
+// Get the number of distinct bound peers.
+func (service *P2PService) boundPeers() int {
+	service.mutex.RLock()
+	defer service.mutex.RUnlock()
+	remotes := map[peer.ID]bool{}
+	for _, binding := range service.bindings {
+		remotes[binding.remote] = true
+	}
+	return len(remotes)
+}
+
+// Get the connectivity health of this node.
+func (service *P2PService) Health() ConnectivityHealth {
+	return service.connections.health(service.boundPeers())
+}
+
+// Keep the target number of peers until the given context is done.
+// Discovered peers are connected while the node has less peers than
+// the target, disconnected peers are reconnected with a backoff and
+// the dht discovery is run again periodically. If `mdns` is set,
+// peers in the local network are discovered as well.
+func (service *P2PService) manageConnections(ctx context.Context, host host.Host, hashtable *dht.IpfsDHT, mdns bool) {
+	d := discovery.NewRoutingDiscovery(hashtable)
+	discovery.Advertise(ctx, d, discoveryIdentifier)
+
+	var localPeers <-chan peer.AddrInfo
+	if mdns {
+		var err error
+		localPeers, err = service.findLocalPeers(host)
+		if err != nil {
+			log.Println(color.Sprintf(fmt.Sprintf("mDNS discovery could not be started: %s", err), color.Error))
+		}
+	}
+
+	// The peers of the running dht discovery
+	var peers <-chan peer.AddrInfo
+	state := ""
+	ticker := time.NewTicker(connManagerInterval)
+	defer ticker.Stop()
+	for {
+		if peers == nil && service.connections.shouldDiscover(service.boundPeers(), time.Now()) {
+			peers = service.findPeers(d)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case info, ok := <-peers:
+			if !ok {
+				peers = nil
+				continue
+			}
+			service.connectDiscovered(host, info)
+		case info := <-localPeers:
+			service.connectDiscovered(host, info)
+		case <-ticker.C:
+			service.reconnect()
+			health := service.Health()
+			if health.State != state {
+				state = health.State
+				log.Printf(
+					"Connectivity is %s (%s peer(s))\n",
+					color.Sprintf(state, color.Info),
+					color.Sprintf(fmt.Sprintf("%d", health.Peers), color.Info),
+				)
+			}
+		}
+	}
+}
+
+// Find new peers using the routing discovery.
+// Returns nil if the discovery could not be run.
+func (service *P2PService) findPeers(d *discovery.RoutingDiscovery) <-chan peer.AddrInfo {
+	peers, err := d.FindPeers(service.ctx, discoveryIdentifier)
+	if err != nil {
+		log.Println(color.Sprintf(fmt.Sprintf("Peer discovery failed: %s", err), color.Warning))
+		return nil
+	}
+	return peers
+}
+
+// Connect to a discovered peer, if the node has less peers than the target.
+func (service *P2PService) connectDiscovered(host host.Host, info peer.AddrInfo) {
+	if info.ID == host.ID() || service.isBound(info.ID) || service.IsBanned(info.ID) {
+		return
+	}
+	if !service.connections.needsPeers(service.boundPeers()) {
+		return
+	}
+	go func() {
+		if err := service.connect(info); err != nil {
+			log.Printf(
+				"Offline: %s\n",
+				color.Sprintf(fmt.Sprintf("%s", info.ID), color.Warning),
+			)
+		}
+	}()
+}
+
+// Reconnect the disconnected peers which are due, using the
+// addresses which they had when they disconnected.
+func (service *P2PService) reconnect() {
+	for _, info := range service.connections.due(time.Now()) {
+		if service.IsBanned(info.ID) {
+			service.connections.drop(info.ID)
+			continue
+		}
+		go func(info peer.AddrInfo) {
+			err := service.connect(info)
+			if !service.connections.dialed(info.ID, err, time.Now()) && err == nil {
+				log.Printf(
+					"Reconnected: %s\n",
+					color.Sprintf(fmt.Sprintf("%s", info.ID), color.Success),
+				)
+			}
+		}(info)
+	}
+}
+
+// Get the addresses of a bound peer, under which it can be
+// reconnected. These are the addresses in the peerstore, which
+// include the listen addresses the peer announced, and the
+// address of the connection of the binding.
+func (service *P2PService) addrInfo(binding *Binding) peer.AddrInfo {
+	remote := binding.stream.Conn().RemoteMultiaddr()
+	addrs := service.host.Peerstore().Addrs(binding.remote)
+	for _, addr := range addrs {
+		if addr.Equal(remote) {
+			return peer.AddrInfo{ID: binding.remote, Addrs: addrs}
+		}
+	}
+	return peer.AddrInfo{ID: binding.remote, Addrs: append(addrs, remote)}
+}
@@ -0,0 +1,138 @@
+package peer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	if d := reconnectDelay(0); d != reconnectMinDelay {
+		t.Errorf("Expected the first delay to be %s, got %s", reconnectMinDelay, d)
+	}
+	if d := reconnectDelay(3); d != 8*reconnectMinDelay {
+		t.Errorf("Expected the delay to double on every failure, got %s", d)
+	}
+	if d := reconnectDelay(100); d != reconnectMaxDelay {
+		t.Errorf("Expected the delay to be capped at %s, got %s", reconnectMaxDelay, d)
+	}
+}
+
+func TestConnManagerReconnectsWithBackoff(t *testing.T) {
+	m := newConnManager(DefaultTargetPeers)
+	id := peer.ID("remote")
+	now := time.Now()
+	offline := errors.New("offline")
+
+	m.disconnect(peer.AddrInfo{ID: id}, now)
+	if len(m.due(now)) != 0 {
+		t.Error("Expected the peer to be reconnected after a delay")
+	}
+	if due := m.due(now.Add(reconnectMinDelay)); len(due) != 1 || due[0].ID != id {
+		t.Fatalf("Expected the peer to be due, got %v", due)
+	}
+	if len(m.due(now.Add(reconnectMinDelay))) != 0 {
+		t.Error("Expected a peer that is dialed not to be due again")
+	}
+
+	// Failed reconnections are retried later
+	now = now.Add(reconnectMinDelay)
+	if !m.dialed(id, offline, now) {
+		t.Fatal("Expected the peer to be tracked further")
+	}
+	if len(m.due(now.Add(reconnectDelay(1)-time.Millisecond))) != 0 {
+		t.Error("Expected the delay to increase after a failure")
+	}
+	if len(m.due(now.Add(reconnectDelay(1)))) != 1 {
+		t.Error("Expected the peer to be due after the increased delay")
+	}
+
+	// Successful reconnections are no longer tracked
+	if m.dialed(id, nil, now) {
+		t.Error("Expected a reconnected peer not to be tracked")
+	}
+	if h := m.health(1); h.Reconnecting != 0 {
+		t.Errorf("Expected no reconnecting peers, got %d", h.Reconnecting)
+	}
+
+	// Peers which fail too often are forgotten
+	m.disconnect(peer.AddrInfo{ID: id}, now)
+	for i := 0; i < maxReconnectAttempts; i++ {
+		now = now.Add(reconnectMaxDelay)
+		m.due(now)
+		m.dialed(id, offline, now)
+	}
+	if h := m.health(0); h.Reconnecting != 0 {
+		t.Errorf("Expected the peer to be forgotten, got %d reconnecting peers", h.Reconnecting)
+	}
+}
+
+func TestConnManagerKeepsAddresses(t *testing.T) {
+	m := newConnManager(DefaultTargetPeers)
+	addr := multiaddr.StringCast("/ip4/10.0.0.1/tcp/1234")
+	now := time.Now()
+
+	m.disconnect(peer.AddrInfo{ID: peer.ID("remote"), Addrs: []multiaddr.Multiaddr{addr}}, now)
+	due := m.due(now.Add(reconnectMinDelay))
+	if len(due) != 1 || len(due[0].Addrs) != 1 || !due[0].Addrs[0].Equal(addr) {
+		t.Fatalf("Expected the peer to be dialed with its address, got %v", due)
+	}
+
+	// The addresses are kept for later reconnections
+	now = now.Add(reconnectMinDelay)
+	m.dialed(due[0].ID, errors.New("offline"), now)
+	due = m.due(now.Add(reconnectDelay(1)))
+	if len(due) != 1 || len(due[0].Addrs) != 1 {
+		t.Errorf("Expected the address to be kept after a failure, got %v", due)
+	}
+}
+
+func TestConnManagerDropsPeers(t *testing.T) {
+	m := newConnManager(DefaultTargetPeers)
+	id := peer.ID("remote")
+	now := time.Now()
+
+	// Banned or manually disconnected peers are not reconnected
+	m.drop(id)
+	m.disconnect(peer.AddrInfo{ID: id}, now)
+	if h := m.health(0); h.Reconnecting != 0 {
+		t.Error("Expected a dropped peer not to be reconnected")
+	}
+
+	// Only the next disconnection is ignored
+	m.disconnect(peer.AddrInfo{ID: id}, now)
+	if h := m.health(0); h.Reconnecting != 1 {
+		t.Error("Expected the peer to be reconnected after a later disconnection")
+	}
+}
+
+func TestConnManagerDiscovery(t *testing.T) {
+	m := newConnManager(2)
+	now := time.Now()
+
+	if !m.shouldDiscover(0, now) {
+		t.Error("Expected a discovery without peers")
+	}
+	if m.shouldDiscover(0, now.Add(discoveryInterval/2)) {
+		t.Error("Expected no discovery before the interval passed")
+	}
+	if m.shouldDiscover(2, now.Add(discoveryInterval)) {
+		t.Error("Expected no discovery when the target is reached")
+	}
+	if !m.shouldDiscover(1, now.Add(discoveryInterval)) {
+		t.Error("Expected a discovery below the target after the interval")
+	}
+
+	for peers, state := range map[int]string{
+		0: ConnectivityIsolated,
+		1: ConnectivityDegraded,
+		2: ConnectivityHealthy,
+	} {
+		if h := m.health(peers); h.State != state {
+			t.Errorf("Expected state %s for %d peers, got %s", state, peers, h.State)
+		}
+	}
+}
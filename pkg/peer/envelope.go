@@ -0,0 +1,125 @@
+package peer
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// The version of the peer message protocol.
+// Messages with a newer version are rejected.
+const ProtocolVersion = 1
+
+var (
+	ErrMissingEnvelope     = errors.New("Message has no envelope!")
+	ErrMissingPayload      = errors.New("Message has no payload!")
+	ErrUnsupportedVersion  = errors.New("Message has an unsupported protocol version!")
+	ErrUnknownMessageType  = errors.New("Message has an unknown type!")
+	ErrSenderMismatch      = errors.New("Message sender does not match the connected peer!")
+	ErrMessageNotDecodable = errors.New("Message could not be decoded!")
+	ErrMalformedPayload    = errors.New("Message payload is malformed!")
+)
+
+// The type of a peer message, e.g. "blockchain/newBlock".
+type MessageType string
+
+// An envelope around a peer message, which describes
+// how the payload of the message has to be handled.
+type Envelope struct {
+	// The type of the message.
+	Type MessageType `json:"type"`
+
+	// The protocol version of the message.
+	Version int `json:"version"`
+
+	// The id of the peer that sent the message.
+	Sender string `json:"sender"`
+
+	// The encoded payload of the message.
+	Payload json.RawMessage `json:"payload"`
+
+	// The id of the request to which the message belongs,
+	// if the message is a request or a response.
+	RequestID string `json:"requestID,omitempty"`
+
+	// If the message was received on a topic. The sender of
+	// such a message forwarded it, but did not necessarily
+	// create it.
+	Forwarded bool `json:"-"`
+
+	// The codec with which the payload is encoded.
+	// The payload is encoded as JSON if this is nil.
+	codec codec
+}
+
+// A function that handles the messages of a type.
+type MessageHandler func(envelope *Envelope) error
+
+// A function that determines the type of a message which was
+// sent without an envelope, by older versions of the protocol.
+// Returns false if the type could not be determined.
+type LegacyDecoder func(bytes []byte) (MessageType, bool)
+
+// Check if the error concerns a single message, after
+// which the following messages can still be read.
+func isMessageError(err error) bool {
+	switch err {
+	case ErrMissingEnvelope, ErrMissingPayload, ErrUnsupportedVersion,
+		ErrUnknownMessageType, ErrSenderMismatch, ErrMessageNotDecodable,
+		ErrMalformedPayload:
+		return true
+	}
+	return false
+}
+
+// Create a new envelope of the given type around the given payload.
+func NewEnvelope(t MessageType, sender string, payload interface{}) (*Envelope, error) {
+	bytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{
+		Type:    t,
+		Version: ProtocolVersion,
+		Sender:  sender,
+		Payload: bytes,
+	}, nil
+}
+
+// Decode the envelope of a message. Returns `ErrMissingEnvelope`
+// if the message is valid JSON but not wrapped in an envelope.
+func DecodeEnvelope(bytes []byte) (*Envelope, error) {
+	var envelope Envelope
+	if err := json.Unmarshal(bytes, &envelope); err != nil {
+		return nil, ErrMessageNotDecodable
+	}
+	if envelope.Type == "" {
+		return nil, ErrMissingEnvelope
+	}
+	if envelope.Version < 1 || envelope.Version > ProtocolVersion {
+		return nil, ErrUnsupportedVersion
+	}
+	if len(envelope.Payload) == 0 || string(envelope.Payload) == "null" {
+		return nil, ErrMissingPayload
+	}
+	return &envelope, nil
+}
+
+// Decode the payload of the envelope into the given value.
+func (envelope *Envelope) Decode(v interface{}) error {
+	if envelope.codec == nil {
+		return json.Unmarshal(envelope.Payload, v)
+	}
+	return envelope.codec.Unmarshal(envelope.Payload, v)
+}
+
+// Get the payload of the envelope encoded as JSON.
+func (envelope *Envelope) JSONPayload() ([]byte, error) {
+	if envelope.codec == nil {
+		return envelope.Payload, nil
+	}
+	var v interface{}
+	if err := envelope.codec.Unmarshal(envelope.Payload, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(toJSONCompatible(v))
+}
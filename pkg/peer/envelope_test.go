@@ -0,0 +1,35 @@
+package peer
+
+import "testing"
+
+func TestDecodeEnvelope(t *testing.T) {
+	envelope, err := NewEnvelope("test/message", "sender", map[string]int{"value": 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bytes := []byte(`{"type":"test/message","version":1,"sender":"sender","payload":{"value":42}}`)
+	decoded, err := DecodeEnvelope(bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Type != envelope.Type || decoded.Sender != envelope.Sender {
+		t.Errorf("Expected %v, got %v", envelope, decoded)
+	}
+	var payload map[string]int
+	if err := decoded.Decode(&payload); err != nil || payload["value"] != 42 {
+		t.Errorf("Expected the payload to be decoded, got %v (%v)", payload, err)
+	}
+
+	if _, err := DecodeEnvelope([]byte(`{"newBlock":{}}`)); err != ErrMissingEnvelope {
+		t.Errorf("Expected %s, got %v", ErrMissingEnvelope, err)
+	}
+	if _, err := DecodeEnvelope([]byte(`{"type":"test/message","version":2,"payload":{}}`)); err != ErrUnsupportedVersion {
+		t.Errorf("Expected %s, got %v", ErrUnsupportedVersion, err)
+	}
+	if _, err := DecodeEnvelope([]byte(`{"type":"test/message","version":1}`)); err != ErrMissingPayload {
+		t.Errorf("Expected %s, got %v", ErrMissingPayload, err)
+	}
+	if _, err := DecodeEnvelope([]byte(`not json`)); err != ErrMessageNotDecodable {
+		t.Errorf("Expected %s, got %v", ErrMessageNotDecodable, err)
+	}
+}
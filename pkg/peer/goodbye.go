@@ -0,0 +1,57 @@
+package peer
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/peerbridge/peerbridge/pkg/color"
+)
+
+// The type of the message with which a peer
+// announces that it is shutting down.
+const GoodbyeMessageType MessageType = "peer/goodbye"
+
+// The payload of a goodbye message.
+type Goodbye struct {
+	// The reason why the peer disconnects.
+	Reason string `json:"reason"`
+}
+
+// Close the connection to a peer which said goodbye,
+// without waiting for its streams to be reset.
+func (service *P2PService) handleGoodbye(envelope *Envelope) error {
+	var goodbye Goodbye
+	if err := envelope.Decode(&goodbye); err != nil {
+		return ErrMalformedPayload
+	}
+	id, err := peer.Decode(envelope.Sender)
+	if err != nil {
+		return ErrMalformedPayload
+	}
+	log.Printf(
+		"Peer %s said goodbye (reason: %s)\n",
+		color.Sprintf(fmt.Sprintf("%s", id), color.Warning), goodbye.Reason,
+	)
+	return service.closePeer(id)
+}
+
+// Say goodbye to all peers which completed the handshake,
+// so that they don't wait for our streams to time out.
+// Older peers don't know the message and are skipped.
+func (service *P2PService) sayGoodbye(reason string) {
+	message, err := newOutgoingMessage(GoodbyeMessageType, service.ID(), Goodbye{reason})
+	if err != nil {
+		return
+	}
+	service.mutex.RLock()
+	defer service.mutex.RUnlock()
+	for _, binding := range service.bindings {
+		if binding.handshake == nil {
+			continue
+		}
+		if err := binding.write(message); err != nil {
+			log.Printf("Error writing to peer %s: %s\n", binding.remote, err)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package peer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// The maximum number of message hashes that are
+	// remembered to detect duplicated gossip messages.
+	MaxSeenMessages = 16384
+
+	// The duration after which a seen message is forgotten.
+	SeenMessageExpiry = 10 * time.Minute
+)
+
+var (
+	ErrDuplicateMessage = errors.New("Message was already seen!")
+	ErrPeerNotConnected = errors.New("Peer is not connected!")
+)
+
+// A bounded cache of the hashes of seen messages.
+// Hashes are forgotten after an expiry or when the
+// cache is full, starting with the oldest hash.
+type seenCache struct {
+	max    int
+	expiry time.Duration
+
+	// The time at which a hash was seen, by hash.
+	seen map[string]time.Time
+
+	// The hashes in the order in which they were seen.
+	order []string
+
+	mutex sync.Mutex
+}
+
+func newSeenCache(max int, expiry time.Duration) *seenCache {
+	return &seenCache{
+		max:    max,
+		expiry: expiry,
+		seen:   map[string]time.Time{},
+	}
+}
+
+// Add a hash to the cache. Returns false
+// if the hash was already seen before.
+func (c *seenCache) add(hash string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// Forget expired hashes
+	now := time.Now()
+	for len(c.order) > 0 && now.Sub(c.seen[c.order[0]]) >= c.expiry {
+		delete(c.seen, c.order[0])
+		c.order = c.order[1:]
+	}
+
+	if _, ok := c.seen[hash]; ok {
+		return false
+	}
+
+	// Make room for the new hash
+	for len(c.order) >= c.max {
+		delete(c.seen, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.seen[hash] = now
+	c.order = append(c.order, hash)
+	return true
+}
+
+// Get the hash of an envelope, which identifies the message
+// independently of its sender and the codec of the stream.
+func (envelope *Envelope) Hash() (string, error) {
+	payload, err := envelope.JSONPayload()
+	if err != nil {
+		return "", err
+	}
+	// Bring the payload into a canonical form, as the
+	// key order differs between the codecs
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return "", err
+	}
+	canonical, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(append([]byte(envelope.Type+"|"), canonical...))
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// Register a handler for incoming gossip messages of the
+// given type. Gossip messages are relayed through the network,
+// which is why duplicates of them are dropped before they
+// reach the handler.
+func (service *P2PService) HandleGossip(t MessageType, handler MessageHandler) {
+	service.Handle(t, handler)
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	service.gossipTypes[t] = true
+}
+
+// Check if a gossip message was already seen and remember it.
+// Returns `ErrDuplicateMessage` if the message was seen before.
+func (service *P2PService) markSeen(envelope *Envelope) error {
+	service.mutex.RLock()
+	isGossip := service.gossipTypes[envelope.Type]
+	service.mutex.RUnlock()
+	if !isGossip {
+		return nil
+	}
+	hash, err := envelope.Hash()
+	if err != nil {
+		return ErrMessageNotDecodable
+	}
+	if !service.seen.add(hash) {
+		return ErrDuplicateMessage
+	}
+	return nil
+}
+
+// Send a message of the given type directly to a bound peer.
+func (service *P2PService) Send(remote string, t MessageType, payload interface{}) error {
+	message, err := newOutgoingMessage(t, service.id, payload)
+	if err != nil {
+		return err
+	}
+
+	service.mutex.RLock()
+	defer service.mutex.RUnlock()
+	for _, binding := range service.bindings {
+		if binding.remote.Pretty() == remote {
+			return binding.write(message)
+		}
+	}
+	return ErrPeerNotConnected
+}
+
+// Relay a message of the given type to all bound peers except
+// its origin, which is the peer from which the message was
+// received. The origin is empty for messages of this node.
+func (service *P2PService) Relay(t MessageType, payload interface{}, origin string) {
+	message, err := newOutgoingMessage(t, service.id, payload)
+	if err != nil {
+		panic(err)
+	}
+	// Don't handle the message again, if it comes back
+	service.markSeen(message.envelope)
+
+	service.outgoingSubscribers.publish(message.envelope.Payload)
+
+	service.mutex.RLock()
+	defer service.mutex.RUnlock()
+	for _, binding := range service.bindings {
+		if binding.remote.Pretty() == origin {
+			continue
+		}
+		if err := binding.write(message); err != nil {
+			log.Printf("Error writing to peer %s: %s\n", binding.remote, err)
+		}
+	}
+}
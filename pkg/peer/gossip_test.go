@@ -0,0 +1,64 @@
+package peer
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSeenCache(t *testing.T) {
+	cache := newSeenCache(2, time.Minute)
+	if !cache.add("a") || !cache.add("b") {
+		t.Fatal("Expected new hashes to be added")
+	}
+	if cache.add("a") {
+		t.Error("Expected a seen hash to be rejected")
+	}
+
+	// The oldest hash is forgotten when the cache is full
+	cache.add("c")
+	if !cache.add("a") {
+		t.Error("Expected the oldest hash to be forgotten")
+	}
+
+	expiring := newSeenCache(10, time.Millisecond)
+	expiring.add("a")
+	time.Sleep(2 * time.Millisecond)
+	if !expiring.add("a") {
+		t.Error("Expected an expired hash to be forgotten")
+	}
+}
+
+func TestEnvelopeHashIsCodecIndependent(t *testing.T) {
+	message, err := newOutgoingMessage("test/message", "sender", testPayload{Text: "gossip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := message.encodeFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	framed, err := readFrame(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	framed.Sender = "relay"
+
+	lineHash, err := message.envelope.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	frameHash, err := framed.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lineHash != frameHash {
+		t.Errorf("Expected equal hashes, got %s and %s", lineHash, frameHash)
+	}
+
+	other, _ := newOutgoingMessage("test/other", "sender", testPayload{Text: "gossip"})
+	otherHash, _ := other.envelope.Hash()
+	if otherHash == lineHash {
+		t.Error("Expected the message type to be part of the hash")
+	}
+}
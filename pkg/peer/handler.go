@@ -0,0 +1,180 @@
+package peer
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/peerbridge/peerbridge/pkg/color"
+)
+
+// An incoming message which is queued for its handler.
+type queuedEnvelope struct {
+	envelope *Envelope
+	remote   peer.ID
+}
+
+// A queue of incoming messages of one type, which are passed to
+// the handler of the type by a worker. Handlers may be slow, e.g.
+// when they wait for the chain lock, so that they are not run in
+// the goroutine which reads from the network. The queue is bounded
+// and handles overflows like the queue of a subscription.
+type handlerQueue struct {
+	// The counters of the queue, which are updated atomically.
+	// They are kept first for the 64-bit alignment on 32-bit platforms.
+	delivered uint64
+	dropped   uint64
+
+	options  SubscriptionOptions
+	handler  MessageHandler
+	messages chan queuedEnvelope
+
+	// Closed when the handler is replaced, to stop the
+	// worker and release senders that wait for it.
+	closed chan struct{}
+	once   sync.Once
+}
+
+// Create a queue for the given handler and start its worker.
+// Errors of the handler are reported to the given function.
+func newHandlerQueue(
+	options SubscriptionOptions, handler MessageHandler, report func(remote peer.ID, err error),
+) *handlerQueue {
+	if options.QueueSize <= 0 {
+		options.QueueSize = DefaultQueueSize
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultBackpressureTimeout
+	}
+	q := &handlerQueue{
+		options:  options,
+		handler:  handler,
+		messages: make(chan queuedEnvelope, options.QueueSize),
+		closed:   make(chan struct{}),
+	}
+	go q.work(report)
+	return q
+}
+
+// Pass the queued messages to the handler until the queue is closed.
+func (q *handlerQueue) work(report func(remote peer.ID, err error)) {
+	for {
+		select {
+		case <-q.closed:
+			return
+		case m := <-q.messages:
+			if err := q.handler(m.envelope); err != nil {
+				report(m.remote, err)
+			}
+		}
+	}
+}
+
+// Stop the worker. Messages which are still queued are dropped.
+func (q *handlerQueue) close() {
+	q.once.Do(func() { close(q.closed) })
+}
+
+// Get the stats of the queue.
+func (q *handlerQueue) stats() SubscriptionStats {
+	return SubscriptionStats{
+		Name:      q.options.Name,
+		Queued:    len(q.messages),
+		Capacity:  cap(q.messages),
+		Delivered: atomic.LoadUint64(&q.delivered),
+		Dropped:   atomic.LoadUint64(&q.dropped),
+	}
+}
+
+// Queue a message for the handler according to the policy of the queue.
+func (q *handlerQueue) deliver(m queuedEnvelope) {
+	select {
+	case <-q.closed:
+		return
+	case q.messages <- m:
+		atomic.AddUint64(&q.delivered, 1)
+		return
+	default:
+	}
+
+	switch q.options.Policy {
+	case DropOldest:
+		// The worker reads concurrently, so that the
+		// queue can have room again without dropping
+		select {
+		case <-q.messages:
+			atomic.AddUint64(&q.dropped, 1)
+		default:
+		}
+		select {
+		case q.messages <- m:
+			atomic.AddUint64(&q.delivered, 1)
+		default:
+			atomic.AddUint64(&q.dropped, 1)
+		}
+	case Backpressure:
+		timer := time.NewTimer(q.options.Timeout)
+		defer timer.Stop()
+		select {
+		case q.messages <- m:
+			atomic.AddUint64(&q.delivered, 1)
+		case <-timer.C:
+			atomic.AddUint64(&q.dropped, 1)
+		case <-q.closed:
+		}
+	default:
+		atomic.AddUint64(&q.dropped, 1)
+	}
+}
+
+// The default options of the handler queues. The network reads wait
+// for slow handlers for a while, since peers expect their messages to
+// be handled, but a stuck handler doesn't block them indefinitely.
+func defaultHandlerOptions(t MessageType) SubscriptionOptions {
+	return SubscriptionOptions{Name: string(t), Policy: Backpressure}
+}
+
+// Register a handler for incoming messages of the given type, whose
+// messages are queued with the given options. The name of the options
+// defaults to the message type. A previous handler of the type is
+// replaced and its queued messages are dropped.
+func (service *P2PService) HandleWithOptions(t MessageType, options SubscriptionOptions, handler MessageHandler) {
+	if options.Name == "" {
+		options.Name = string(t)
+	}
+	q := newHandlerQueue(options, handler, service.reportDropped)
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	if previous, ok := service.handlers[t]; ok {
+		previous.close()
+	}
+	service.handlers[t] = q
+}
+
+// Get the stats of the handler queues of all message types.
+func (service *P2PService) HandlerQueues() []SubscriptionStats {
+	service.mutex.RLock()
+	defer service.mutex.RUnlock()
+	stats := []SubscriptionStats{}
+	for _, q := range service.handlers {
+		stats = append(stats, q.stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// Log and penalize a message from the given peer that was dropped.
+func (service *P2PService) reportDropped(remote peer.ID, err error) {
+	if err == ErrDuplicateMessage {
+		return
+	}
+	log.Printf(
+		"Dropped message from %s (reason: %s)\n",
+		color.Sprintf(fmt.Sprintf("%s", remote), color.Warning), err,
+	)
+	service.Penalize(remote.Pretty(), penaltyFor(err), err)
+}
@@ -0,0 +1,76 @@
+package peer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestDispatchDoesNotWaitForHandler(t *testing.T) {
+	service := NewService()
+	release := make(chan struct{})
+	handled := make(chan string, 3)
+	service.HandleWithOptions("test/slow", SubscriptionOptions{QueueSize: 1, Policy: DropNewest}, func(e *Envelope) error {
+		<-release
+		handled <- string(e.Payload)
+		return nil
+	})
+
+	remote := peer.ID("remote")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, m := range []string{"a", "b", "c"} {
+			if err := service.dispatch(&Envelope{Type: "test/slow", Payload: []byte(m)}, remote); err != nil {
+				t.Error(err)
+			}
+			// Let the worker take the first message
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the dispatch not to wait for the handler")
+	}
+
+	// The worker holds the first message, the second one is queued
+	// and the third one is dropped, since the queue is full
+	stats := service.HandlerQueues()
+	if len(stats) != 1 || stats[0].Name != "test/slow" || stats[0].Delivered != 2 || stats[0].Dropped != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	close(release)
+	for _, want := range []string{"a", "b"} {
+		if m := <-handled; m != want {
+			t.Errorf("Expected message %s to be handled, got %s", want, m)
+		}
+	}
+}
+
+func TestHandlerIsReplaced(t *testing.T) {
+	service := NewService()
+	handled := make(chan string, 1)
+	service.Handle("test/replaced", func(e *Envelope) error {
+		handled <- "old"
+		return nil
+	})
+	previous := service.handlers["test/replaced"]
+	service.Handle("test/replaced", func(e *Envelope) error {
+		handled <- "new"
+		return nil
+	})
+
+	select {
+	case <-previous.closed:
+	default:
+		t.Error("Expected the queue of the replaced handler to be closed")
+	}
+	if err := service.dispatch(&Envelope{Type: "test/replaced", Payload: []byte("{}")}, peer.ID("remote")); err != nil {
+		t.Fatal(err)
+	}
+	if m := <-handled; m != "new" {
+		t.Errorf("Expected the new handler to be called, got the %s one", m)
+	}
+}
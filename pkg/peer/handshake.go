@@ -0,0 +1,199 @@
+package peer
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/peerbridge/peerbridge/pkg/encryption/secp256k1"
+)
+
+const (
+	// The type of the handshake message, which is the
+	// first message that is sent on every stream.
+	HandshakeMessageType MessageType = "peer/handshake"
+
+	// The time in which the handshake of a peer must arrive.
+	handshakeTimeout = 10 * time.Second
+)
+
+var (
+	ErrHandshakeUnavailable = errors.New("Handshake is not available yet!")
+	ErrHandshakeMissing     = errors.New("Peer did not send a handshake!")
+	ErrIncompatibleVersion  = errors.New("Peer speaks an incompatible protocol version!")
+	ErrGenesisMismatch      = errors.New("Peer has a different genesis block!")
+	ErrNetworkMismatch      = errors.New("Peer belongs to a different network!")
+	ErrPeerIDMismatch       = errors.New("Handshake was created for a different peer id!")
+)
+
+// The handshake which is exchanged when a stream is opened.
+// It is signed with the staking key of the node, so that
+// the key is bound to the peer id of the node.
+type Handshake struct {
+	// The protocol version of the node.
+	ProtocolVersion int `json:"protocolVersion"`
+
+	// The id of the genesis block of the node.
+	GenesisID string `json:"genesisID"`
+
+	// The id of the network of the node.
+	NetworkID string `json:"networkID"`
+
+	// The height of the main chain head of the node.
+	Height uint64 `json:"height"`
+
+	// The cumulative difficulty of the main chain head of the node.
+	CumulativeDifficulty uint64 `json:"cumulativeDifficulty"`
+
+	// The peer id of the node.
+	PeerID string `json:"peerID"`
+
+	// The staking public key of the node.
+	PublicKey secp256k1.PublicKeyHexString `json:"publicKey"`
+
+	// The time at which the handshake was created.
+	TimeUnixNano int64 `json:"timeUnixNano"`
+
+	// The signature of the handshake, created with the staking key.
+	Signature *secp256k1.SignatureHexString `json:"signature"`
+}
+
+func (h *Handshake) GetSender() secp256k1.PublicKeyHexString {
+	return h.PublicKey
+}
+
+func (h *Handshake) GetSignString() string {
+	return fmt.Sprintf(
+		"protocolVersion:%d|genesisID:%s|networkID:%s|height:%d|cumulativeDifficulty:%d|peerID:%s|publicKey:%s|timeUnixNano:%d",
+		h.ProtocolVersion,
+		h.GenesisID,
+		h.NetworkID,
+		h.Height,
+		h.CumulativeDifficulty,
+		h.PeerID,
+		h.PublicKey,
+		h.TimeUnixNano,
+	)
+}
+
+// Check if the chain of the remote node is preferred over
+// the chain of the local node, by comparing the heights and
+// cumulative difficulties of their main chain heads.
+func (h *Handshake) IsAheadOf(local *Handshake) bool {
+	if h.Height != local.Height {
+		return h.Height > local.Height
+	}
+	return h.CumulativeDifficulty > local.CumulativeDifficulty
+}
+
+// Validate the handshake of the given remote peer
+// against the handshake of the local node.
+func (h *Handshake) Validate(local *Handshake, remote peer.ID) error {
+	if h.ProtocolVersion != local.ProtocolVersion {
+		return ErrIncompatibleVersion
+	}
+	if h.NetworkID != local.NetworkID {
+		return ErrNetworkMismatch
+	}
+	if h.GenesisID != local.GenesisID {
+		return ErrGenesisMismatch
+	}
+	if h.PeerID != remote.Pretty() {
+		return ErrPeerIDMismatch
+	}
+	if h.Signature == nil {
+		return ErrHandshakeMissing
+	}
+	return secp256k1.VerifySignature(h, *h.Signature)
+}
+
+// A function that creates the signed handshake of the
+// local node, which has the given peer id.
+type HandshakeProvider func(peerID string) (*Handshake, error)
+
+// Set the provider of the local handshake. Streams using the
+// newer protocol are only accepted once a provider is set.
+func (service *P2PService) SetHandshakeProvider(provider HandshakeProvider) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	service.handshakeProvider = provider
+}
+
+// Register a function that is called after the
+// handshake with a peer was completed.
+func (service *P2PService) OnHandshake(callback func(h *Handshake)) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	service.handshakeCallbacks = append(service.handshakeCallbacks, callback)
+}
+
+// Get the handshakes of all connected peers that completed
+// the handshake, ordered from the best to the worst chain.
+// These peers are the preferred targets for syncing.
+func (service *P2PService) SyncCandidates() []Handshake {
+	service.mutex.RLock()
+	defer service.mutex.RUnlock()
+	candidates := []Handshake{}
+	for _, binding := range service.bindings {
+		if binding.handshake != nil {
+			candidates = append(candidates, *binding.handshake)
+		}
+	}
+	for i := 1; i < len(candidates); i++ {
+		for j := i; j > 0 && candidates[j].IsAheadOf(&candidates[j-1]); j-- {
+			candidates[j], candidates[j-1] = candidates[j-1], candidates[j]
+		}
+	}
+	return candidates
+}
+
+// Exchange handshakes with the peer of the binding.
+// Returns an error if the peer is incompatible.
+func (service *P2PService) handshake(binding *Binding, setDeadline func(time.Time) error) error {
+	service.mutex.RLock()
+	provider := service.handshakeProvider
+	service.mutex.RUnlock()
+	if provider == nil {
+		return ErrHandshakeUnavailable
+	}
+
+	local, err := provider(service.id)
+	if err != nil {
+		return err
+	}
+	message, err := newOutgoingMessage(HandshakeMessageType, service.id, local)
+	if err != nil {
+		return err
+	}
+	if err = binding.write(message); err != nil {
+		return err
+	}
+
+	setDeadline(time.Now().Add(handshakeTimeout))
+	envelope, err := binding.read(service)
+	setDeadline(time.Time{})
+	if err != nil {
+		return err
+	}
+	if envelope.Type != HandshakeMessageType {
+		return ErrHandshakeMissing
+	}
+
+	var remote Handshake
+	if err = envelope.Decode(&remote); err != nil {
+		return ErrMessageNotDecodable
+	}
+	if err = remote.Validate(local, binding.remote); err != nil {
+		return err
+	}
+	binding.handshake = &remote
+
+	service.mutex.RLock()
+	callbacks := service.handshakeCallbacks
+	service.mutex.RUnlock()
+	for _, callback := range callbacks {
+		go callback(&remote)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package peer
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/peerbridge/peerbridge/pkg/encryption/secp256k1"
+)
+
+func signedTestHandshake(t *testing.T, remote peer.ID) *Handshake {
+	keyPair, err := secp256k1.LoadKeyPairFromPrivateKeyString(
+		"60f8700baf057e6131b912b97f2e36f54a67544a5f4659de348e988306ab1a3f",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &Handshake{
+		ProtocolVersion: ProtocolVersion,
+		GenesisID:       "genesis",
+		NetworkID:       "network",
+		Height:          10,
+		PeerID:          remote.Pretty(),
+		PublicKey:       keyPair.PublicKey,
+	}
+	h.Signature, err = secp256k1.ComputeSignature(h, keyPair.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestValidateHandshake(t *testing.T) {
+	remote := peer.ID("remote")
+	local := &Handshake{ProtocolVersion: ProtocolVersion, GenesisID: "genesis", NetworkID: "network"}
+
+	h := signedTestHandshake(t, remote)
+	if err := h.Validate(local, remote); err != nil {
+		t.Fatalf("Expected a valid handshake, got %s", err)
+	}
+	if !h.IsAheadOf(local) {
+		t.Errorf("Expected the remote to be ahead")
+	}
+
+	if err := h.Validate(local, peer.ID("other")); err != ErrPeerIDMismatch {
+		t.Errorf("Expected %s, got %v", ErrPeerIDMismatch, err)
+	}
+
+	otherNetwork := *local
+	otherNetwork.NetworkID = "other"
+	if err := h.Validate(&otherNetwork, remote); err != ErrNetworkMismatch {
+		t.Errorf("Expected %s, got %v", ErrNetworkMismatch, err)
+	}
+
+	otherGenesis := *local
+	otherGenesis.GenesisID = "other"
+	if err := h.Validate(&otherGenesis, remote); err != ErrGenesisMismatch {
+		t.Errorf("Expected %s, got %v", ErrGenesisMismatch, err)
+	}
+
+	tampered := *h
+	tampered.Height = 1000
+	if err := tampered.Validate(local, remote); err == nil {
+		t.Errorf("Expected a tampered handshake to be rejected")
+	}
+}
@@ -0,0 +1,130 @@
+package peer
+
+import (
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+const (
+	// The name of the directory in the home directory of the
+	// user, in which the node data is stored by default.
+	defaultDataDirName = ".peerbridge"
+
+	// The name of the file in the data directory,
+	// in which the libp2p private key is stored.
+	identityFileName = "p2p.key"
+)
+
+var (
+	ErrInvalidIdentity  = errors.New("The p2p key file does not contain a valid private key!")
+	ErrIdentityNotFound = errors.New("The p2p key file does not exist!")
+)
+
+// Get the directory in which the node data is stored.
+// The directory can be set with the `DATA_DIR` environment
+// variable and defaults to `$HOME/.peerbridge`.
+func GetDataDir() string {
+	dir := os.Getenv("DATA_DIR")
+	if dir != "" {
+		return dir
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return defaultDataDirName
+	}
+	return filepath.Join(home, defaultDataDirName)
+}
+
+// Get the default path of the libp2p key file in the data directory.
+func GetIdentityPath() string {
+	return filepath.Join(GetDataDir(), identityFileName)
+}
+
+// Load the libp2p private key from the given key file.
+// If the file does not exist yet, a new key is generated
+// and stored, so that the peer id stays the same on restarts.
+func LoadIdentity(path string) (crypto.PrivKey, error) {
+	key, err := ReadIdentity(path)
+	if err == ErrIdentityNotFound {
+		return RotateIdentity(path)
+	}
+	return key, err
+}
+
+// Read the libp2p private key from the given key file, without
+// creating it. Returns `ErrIdentityNotFound` if the file does
+// not exist yet.
+func ReadIdentity(path string) (crypto.PrivKey, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrIdentityNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := crypto.UnmarshalPrivateKey(bytes)
+	if err != nil {
+		return nil, ErrInvalidIdentity
+	}
+	return key, nil
+}
+
+// Generate a new libp2p private key and store it in the given
+// key file, replacing the previous key. The file is replaced
+// atomically, so that the previous key is kept if writing fails.
+func RotateIdentity(path string) (crypto.PrivKey, error) {
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := crypto.MarshalPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, bytes, 0600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return key, nil
+}
+
+// Get the peer id that belongs to a libp2p private key.
+func IdentityID(key crypto.PrivKey) (string, error) {
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return id.Pretty(), nil
+}
+
+// Set the libp2p private key with which the host is created.
+// If no key is set, a new peer id is generated on every start.
+func (service *P2PService) SetIdentity(key crypto.PrivKey) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	service.identity = key
+}
+
+// Get the peer id of this node. Returns an empty
+// string if the host was not created yet.
+func (service *P2PService) ID() string {
+	service.mutex.RLock()
+	defer service.mutex.RUnlock()
+	return service.id
+}
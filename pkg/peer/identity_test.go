@@ -0,0 +1,64 @@
+package peer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIdentityIsPersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peerbridge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data", identityFileName)
+
+	if _, err := ReadIdentity(path); err != ErrIdentityNotFound {
+		t.Fatalf("Expected %v, got %v", ErrIdentityNotFound, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("Expected reading the identity not to create a key file")
+	}
+
+	key, err := LoadIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := IdentityID(key)
+
+	loaded, err := LoadIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loadedID, _ := IdentityID(loaded); loadedID != id {
+		t.Errorf("Expected the peer id %s to be kept, got %s", id, loadedID)
+	}
+
+	rotated, err := RotateIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotatedID, _ := IdentityID(rotated)
+	if rotatedID == id {
+		t.Error("Expected a new peer id after the rotation")
+	}
+	loaded, err = LoadIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loadedID, _ := IdentityID(loaded); loadedID != rotatedID {
+		t.Errorf("Expected the rotated peer id %s, got %s", rotatedID, loadedID)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIdentity(path); err != ErrInvalidIdentity {
+		t.Errorf("Expected %v, got %v", ErrInvalidIdentity, err)
+	}
+	if _, err := ReadIdentity(path); err != ErrInvalidIdentity {
+		t.Errorf("Expected %v, got %v", ErrInvalidIdentity, err)
+	}
+}
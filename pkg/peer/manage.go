@@ -0,0 +1,204 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/peerbridge/peerbridge/pkg/color"
+)
+
+// The time after which connecting to a peer fails.
+const connectTimeout = 30 * time.Second
+
+var (
+	ErrServiceNotRunning  = errors.New("The p2p service is not running!")
+	ErrInvalidPeerAddress = errors.New("The peer address must be a multiaddress with a peer id!")
+)
+
+// Information about a connected peer.
+type PeerInfo struct {
+	// The id of the peer.
+	ID string `json:"id"`
+
+	// The multiaddresses of the connections to the peer.
+	Addrs []string `json:"addrs"`
+
+	// The direction of the first connection to the peer,
+	// either "inbound" or "outbound".
+	Direction string `json:"direction"`
+
+	// The average latency to the peer in milliseconds,
+	// or 0 if the latency was not measured yet.
+	LatencyMilliseconds float64 `json:"latencyMilliseconds"`
+
+	// The protocol of the message stream to the peer,
+	// or an empty string if the peer is not bound.
+	Protocol string `json:"protocol"`
+
+	// The handshake of the peer, if it completed the handshake.
+	Handshake *Handshake `json:"handshake"`
+
+	// The total number of bytes sent to and received from the peer.
+	BytesSent     int64 `json:"bytesSent"`
+	BytesReceived int64 `json:"bytesReceived"`
+}
+
+// Get information about all connected peers, ordered by their id.
+func (service *P2PService) Peers() []PeerInfo {
+	service.mutex.RLock()
+	host := service.host
+	bindings := map[peer.ID]*Binding{}
+	for _, binding := range service.bindings {
+		bindings[binding.remote] = binding
+	}
+	service.mutex.RUnlock()
+
+	peers := []PeerInfo{}
+	if host == nil {
+		return peers
+	}
+	for _, id := range host.Network().Peers() {
+		conns := host.Network().ConnsToPeer(id)
+		if len(conns) == 0 {
+			continue
+		}
+		info := PeerInfo{
+			ID:                  id.Pretty(),
+			Addrs:               []string{},
+			Direction:           directionString(conns[0].Stat().Direction),
+			LatencyMilliseconds: float64(host.Peerstore().LatencyEWMA(id)) / float64(time.Millisecond),
+		}
+		for _, conn := range conns {
+			info.Addrs = append(info.Addrs, conn.RemoteMultiaddr().String())
+		}
+		if binding, ok := bindings[id]; ok {
+			info.Protocol = string(binding.protocol)
+			info.Handshake = binding.handshake
+		}
+		if service.bandwidth != nil {
+			stats := service.bandwidth.GetBandwidthForPeer(id)
+			info.BytesSent = stats.TotalOut
+			info.BytesReceived = stats.TotalIn
+		}
+		peers = append(peers, info)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].ID < peers[j].ID
+	})
+	return peers
+}
+
+func directionString(direction network.Direction) string {
+	switch direction {
+	case network.DirInbound:
+		return "inbound"
+	case network.DirOutbound:
+		return "outbound"
+	}
+	return "unknown"
+}
+
+// Connect to a peer under the given multiaddress, which has to
+// contain the peer id, e.g. /ip4/1.2.3.4/tcp/9080/p2p/Qm...
+// Returns the id of the connected peer.
+func (service *P2PService) Connect(address string) (string, error) {
+	service.mutex.RLock()
+	host := service.host
+	service.mutex.RUnlock()
+	if host == nil {
+		return "", ErrServiceNotRunning
+	}
+
+	addr, err := multiaddr.NewMultiaddr(address)
+	if err != nil {
+		return "", ErrInvalidPeerAddress
+	}
+	info, err := peer.AddrInfoFromP2pAddr(addr)
+	if err != nil {
+		return "", ErrInvalidPeerAddress
+	}
+	if err := service.connect(*info); err != nil {
+		return "", err
+	}
+	return info.ID.Pretty(), nil
+}
+
+// Connect to a peer and bind to it via a message stream.
+func (service *P2PService) connect(info peer.AddrInfo) error {
+	ctx, cancel := context.WithTimeout(service.ctx, connectTimeout)
+	defer cancel()
+	if err := service.host.Connect(ctx, info); err != nil {
+		return err
+	}
+	if service.isBound(info.ID) {
+		return nil
+	}
+	// Prefer the newer protocol, if the peer supports it
+	stream, err := service.host.NewStream(ctx, info.ID, streamProtocolV2, streamProtocol)
+	if err != nil {
+		return err
+	}
+	if err := service.bind(stream); err != nil {
+		return err
+	}
+	log.Printf(
+		"Connected: %s\n",
+		color.Sprintf(fmt.Sprintf("%s", info.ID), color.Success),
+	)
+	return nil
+}
+
+// Check if a message stream to the given peer is bound.
+func (service *P2PService) isBound(id peer.ID) bool {
+	service.mutex.RLock()
+	defer service.mutex.RUnlock()
+	for _, binding := range service.bindings {
+		if binding.remote == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Disconnect from the peer with the given id. The peer is not
+// reconnected, but it may connect again later, e.g. when it is
+// discovered.
+func (service *P2PService) Disconnect(remote string) error {
+	service.mutex.RLock()
+	host := service.host
+	service.mutex.RUnlock()
+	if host == nil {
+		return ErrServiceNotRunning
+	}
+
+	id, err := peer.Decode(remote)
+	if err != nil || host.Network().Connectedness(id) != network.Connected {
+		return ErrPeerNotConnected
+	}
+
+	service.connections.drop(id)
+	return service.closePeer(id)
+}
+
+// Close the streams and connections to a peer.
+func (service *P2PService) closePeer(id peer.ID) error {
+	service.mutex.RLock()
+	host := service.host
+	for _, binding := range service.bindings {
+		if binding.remote == id {
+			binding.stream.Reset()
+		}
+	}
+	service.mutex.RUnlock()
+	if host == nil {
+		return nil
+	}
+	return host.Network().ClosePeer(id)
+}
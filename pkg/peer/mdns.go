@@ -0,0 +1,43 @@
+package peer
+
+import (
+	"time"
+
+	host "github.com/libp2p/go-libp2p-host"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p/p2p/discovery"
+)
+
+const (
+	// The interval in which the local network is queried for peers.
+	mdnsInterval = 10 * time.Second
+
+	// The service tag under which peers announce themselves via mDNS.
+	mdnsServiceTag = "peerbridge.mdns"
+)
+
+// A notifee that passes peers found via mDNS into a channel.
+type mdnsNotifee struct {
+	service *P2PService
+	peers   chan peer.AddrInfo
+}
+
+func (n *mdnsNotifee) HandlePeerFound(info peer.AddrInfo) {
+	select {
+	case n.peers <- info:
+	case <-n.service.ctx.Done():
+	}
+}
+
+// Find peers in the local network via mDNS, e.g. other
+// nodes of a development cluster on the same machine.
+func (service *P2PService) findLocalPeers(host host.Host) (<-chan peer.AddrInfo, error) {
+	mdns, err := discovery.NewMdnsService(service.ctx, host, mdnsInterval, mdnsServiceTag)
+	if err != nil {
+		return nil, err
+	}
+	peers := make(chan peer.AddrInfo)
+	mdns.RegisterNotifee(&mdnsNotifee{service, peers})
+	return peers, nil
+}
@@ -17,27 +17,97 @@
 
 	ipfslog "github.com/ipfs/go-log/v2"
 	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/metrics"
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/protocol"
-	discovery "github.com/libp2p/go-libp2p-discovery"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
-	"github.com/multiformats/go-multiaddr"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
 )
 
 const (
 	// A stream protocol that will be used to
 	// identify streams belonging to our application.
+	// Messages are sent as newline-delimited JSON.
 	streamProtocol protocol.ID = "/peerbridge/p2p/1.0.0"
 
+	// The stream protocol which sends messages as
+	// length-prefixed and optionally compressed CBOR
+	// frames. This protocol is preferred, the older
+	// protocol is still spoken with older peers.
+	streamProtocolV2 protocol.ID = "/peerbridge/p2p/2.0.0"
+
 	// A discovery identifier string that is sent to other peers.
 	discoveryIdentifier = "dht.routing.peerbridge"
 
 	DefaultP2PPort = "9080"
 )
 
-// A binding to another peer, represented by a rw buffer.
-type Binding = bufio.ReadWriter
+// A binding to another peer via a stream.
+type Binding struct {
+	// The negotiated protocol of the stream.
+	protocol protocol.ID
+
+	// The id of the bound peer.
+	remote peer.ID
+
+	// The stream to the bound peer.
+	stream network.Stream
+
+	reader *bufio.Reader
+	writer *bufio.Writer
+
+	// The handshake of the bound peer, if the
+	// negotiated protocol has a handshake.
+	handshake *Handshake
+
+	// A mutex to avoid interleaved messages when
+	// writing from different goroutines.
+	writeMutex sync.Mutex
+}
+
+// Read the next message from the bound peer.
+func (binding *Binding) read(service *P2PService) (*Envelope, error) {
+	if binding.protocol == streamProtocolV2 {
+		return readFrame(binding.reader)
+	}
+
+	for {
+		line, err := readLine(binding.reader)
+		if err != nil {
+			return nil, err
+		}
+		if string(line) == "\n" {
+			continue
+		}
+		envelope, err := DecodeEnvelope(line)
+		if err == ErrMissingEnvelope {
+			return service.decodeLegacy(line)
+		}
+		return envelope, err
+	}
+}
+
+// Write a message to the bound peer, encoded for the negotiated protocol.
+func (binding *Binding) write(message *outgoingMessage) error {
+	var bytes []byte
+	var err error
+	if binding.protocol == streamProtocolV2 {
+		bytes, err = message.encodeFrame()
+	} else {
+		bytes, err = message.encodeLine()
+	}
+	if err != nil {
+		return err
+	}
+	binding.writeMutex.Lock()
+	defer binding.writeMutex.Unlock()
+	if _, err = binding.writer.Write(bytes); err != nil {
+		return err
+	}
+	return binding.writer.Flush()
+}
 
 type P2PService struct {
 	// The urls under which the peer can be accessed.
@@ -45,10 +115,51 @@ type P2PService struct {
 	URLs []url.URL
 
 	// The subscribers to new incoming messages of the peer.
-	incomingSubscribers []chan []byte
+	incomingSubscribers subscriptionList
 
 	// The subscribers to new outgoing messages of the peer.
-	outgoingSubscribers []chan []byte
+	outgoingSubscribers subscriptionList
+
+	// The id of this peer, which is sent along with messages.
+	// This variable is set when `Run` is called.
+	id string
+
+	// The queues of the handlers for incoming messages by message type.
+	handlers map[MessageType]*handlerQueue
+
+	// The handlers for incoming requests by message type.
+	requestHandlers map[MessageType]RequestHandler
+
+	// The message types which are gossiped through the network.
+	gossipTypes map[MessageType]bool
+
+	// The hashes of the gossip messages that were already seen.
+	seen *seenCache
+
+	// The id of the network in which messages are published.
+	networkID string
+
+	// The validators of the topics by message type.
+	topicValidators map[MessageType]TopicValidator
+
+	// The scores of the peers.
+	reputation *reputation
+
+	// The connection manager, which reconnects peers.
+	connections *connManager
+
+	// The joined topics by message type.
+	// The topics are joined when `Run` is called.
+	topics map[MessageType]*pubsub.Topic
+
+	// The decoder for incoming messages without envelope.
+	legacyDecoder LegacyDecoder
+
+	// The provider of the handshake of this node.
+	handshakeProvider HandshakeProvider
+
+	// The functions to call after a handshake was completed.
+	handshakeCallbacks []func(h *Handshake)
 
 	// All currently open bindings for peer streams.
 	bindings []*Binding
@@ -57,12 +168,35 @@ type P2PService struct {
 	// from different goroutines.
 	mutex sync.RWMutex
 
+	// The libp2p private key of this peer, which
+	// determines the peer id of the host.
+	identity crypto.PrivKey
+
+	// The p2p host, which is set when `Run` is called.
+	host host.Host
+
+	// The counter of the bytes sent to and received from peers.
+	bandwidth *metrics.BandwidthCounter
+
 	// A background context in which p2p networking is done.
 	ctx context.Context
 }
 
-var Service = &P2PService{
-	ctx: context.Background(),
+var Service = NewService()
+
+// Create a new p2p service, which is not running yet.
+func NewService() *P2PService {
+	return &P2PService{
+		ctx:             context.Background(),
+		handlers:        map[MessageType]*handlerQueue{},
+		requestHandlers: map[MessageType]RequestHandler{},
+		gossipTypes:     map[MessageType]bool{},
+		seen:            newSeenCache(MaxSeenMessages, SeenMessageExpiry),
+		topicValidators: map[MessageType]TopicValidator{},
+		topics:          map[MessageType]*pubsub.Topic{},
+		reputation:      newReputation(),
+		connections:     newConnManager(DefaultTargetPeers),
+	}
 }
 
 func GetP2PPort() string {
@@ -75,44 +209,66 @@ func GetP2PPort() string {
 }
 
 // Initialize the blockchain peer.
-// Use the parameter `bootstrapTarget` to add a
-// target url for the bootstrapping service.
-// Note that this method will never return.
-func (service *P2PService) Run(bootstrapHost string) {
+// Use the parameter `config` to set the peers from which
+// the dht is bootstrapped and the static peers. If the
+// bootstrap peers are offline, the connection is retried
+// in the background. The service runs until the given
+// context is done. Afterwards, the peers are told goodbye
+// and the host is closed, before this method returns.
+func (service *P2PService) Run(ctx context.Context, config BootstrapConfig) {
 	// Configure the ipfs loggers
 	ipfslog.SetAllLoggers(ipfslog.LevelError)
 	ipfslog.SetLogLevel("rendezvous", "info")
 
+	service.mutex.Lock()
+	service.ctx = ctx
+	service.mutex.Unlock()
+	if config.TargetPeers > 0 {
+		service.connections.setTarget(config.TargetPeers)
+	}
+	service.Handle(GoodbyeMessageType, service.handleGoodbye)
+
 	// Create the p2p host
 	host := service.newHost(GetP2PPort())
-	dht := service.newDHT(host, bootstrapHost)
+	dht := service.newDHT(host)
 
-	// Set a default stream handler for incoming p2p connections
-	host.SetStreamHandler(streamProtocol, service.bind)
+	// Publish and receive blocks and transactions via topics
+	if err := service.joinTopics(host); err != nil {
+		log.Println(color.Sprintf(fmt.Sprintf("Topics could not be joined: %s", err), color.Error))
+	}
 
-	// Announce ourselves using a routing discovery
-	peers := service.findPeers(dht)
+	// Set the stream handlers for incoming p2p connections
+	host.SetStreamHandler(streamProtocolV2, service.handleStream)
+	host.SetStreamHandler(streamProtocol, service.handleStream)
+	host.SetStreamHandler(requestProtocol, service.handleRequestStream)
 
-	for peer := range peers {
-		if peer.ID == host.ID() {
-			continue
-		}
-		stream, err := host.NewStream(service.ctx, peer.ID, streamProtocol)
-		if err != nil {
-			log.Printf(
-				"Offline: %s\n",
-				color.Sprintf(fmt.Sprintf("%s", peer.ID), color.Warning),
-			)
-			continue
-		}
-		service.bind(stream)
-		log.Printf(
-			"Connected: %s\n",
-			color.Sprintf(fmt.Sprintf("%s", peer.ID), color.Success),
-		)
+	go service.keepStaticPeers(config.StaticPeers)
+
+	defer service.stop(host, dht)
+
+	// Wait until a bootstrap peer was reached, so that
+	// the routing discovery can find other peers
+	select {
+	case <-service.bootstrap(config):
+	case <-ctx.Done():
+		return
 	}
 
-	select {}
+	// Announce ourselves using a routing discovery,
+	// and keep the target number of peers
+	service.manageConnections(ctx, host, dht, config.MDNS)
+}
+
+// Say goodbye to the peers and close the dht and the host.
+func (service *P2PService) stop(host host.Host, dht *dht.IpfsDHT) {
+	service.sayGoodbye("shutdown")
+	if err := dht.Close(); err != nil {
+		log.Printf("Error closing the dht: %s\n", err)
+	}
+	if err := host.Close(); err != nil {
+		log.Printf("Error closing the p2p host: %s\n", err)
+	}
+	log.Println(color.Sprintf("Stopped the p2p service.", color.Info))
 }
 
 // Make a host that listens on the given multiaddress
@@ -120,11 +276,28 @@ func (service *P2PService) newHost(port string) host.Host {
 	// 0.0.0.0 will listen on any interface device.
 	addr := fmt.Sprintf("/ip4/0.0.0.0/tcp/%s", port)
 
-	host, err := libp2p.New(context.Background(), libp2p.ListenAddrStrings(addr))
+	service.bandwidth = metrics.NewBandwidthCounter()
+	options := []libp2p.Option{
+		libp2p.ListenAddrStrings(addr),
+		libp2p.BandwidthReporter(service.bandwidth),
+		// Refuse connections to banned peers
+		libp2p.ConnectionGater(&banGater{service}),
+	}
+	service.mutex.RLock()
+	if service.identity != nil {
+		options = append(options, libp2p.Identity(service.identity))
+	}
+	service.mutex.RUnlock()
+	host, err := libp2p.New(context.Background(), options...)
 	if err != nil {
 		panic(err)
 	}
 
+	service.mutex.Lock()
+	service.id = host.ID().Pretty()
+	service.host = host
+	service.mutex.Unlock()
+
 	log.Printf("Created a new p2p service which is reachable under:\n")
 
 	for _, addr := range host.Addrs() {
@@ -154,10 +327,12 @@ func (service *P2PService) requestPeerURLs(bootstrapHost string) (urls []string,
 }
 
 // Make a dht that is used to discover and track new peers.
-func (service *P2PService) newDHT(host host.Host, bootstrapHost string) *dht.IpfsDHT {
+func (service *P2PService) newDHT(host host.Host) *dht.IpfsDHT {
 	// Specify DHT options, in this case we want the service
-	// to serve as a bootstrap server
-	dht, err := dht.New(service.ctx, host, dht.Mode(dht.ModeServer))
+	// to serve as a bootstrap server. The dht is closed when the
+	// service stops, so it doesn't use the service context, which
+	// may already be done and would make the dht creation fail
+	dht, err := dht.New(context.Background(), host, dht.Mode(dht.ModeServer))
 	if err != nil {
 		panic(err)
 	}
@@ -165,138 +340,171 @@ func (service *P2PService) newDHT(host host.Host, bootstrapHost string) *dht.Ipf
 	// Bootstrap the dht. In the default configuration, this spawns
 	// a background thread that will refresh the peer table every
 	// five minutes
-	if err = dht.Bootstrap(service.ctx); err != nil {
+	if err = dht.Bootstrap(context.Background()); err != nil {
 		panic(err)
 	}
 
-	// If no bootstrap node was given, return the created dht
-	if bootstrapHost == "" {
-		return dht
-	}
-
-	// Poll until the bootstrap node is alive
-	var urls []string
-	for {
-		urls, _ := service.requestPeerURLs(bootstrapHost)
-		if urls != nil && len(urls) > 0 {
-			break
-		}
-		log.Println(color.Sprintf("Waiting until the bootstrap node is online...", color.Warning))
-		time.Sleep(time.Second * 1)
-	}
-
-	for _, url := range urls {
-		addr, err := multiaddr.NewMultiaddr(url)
-		if err != nil {
-			continue
-		}
-		peerInfo, _ := peer.AddrInfoFromP2pAddr(addr)
-		err = host.Connect(service.ctx, *peerInfo)
-		if err != nil {
-			continue
-		}
-		log.Printf(
-			"Connected to the bootstrap node via %s\n",
-			color.Sprintf(fmt.Sprintf("%s", addr), color.Notice),
-		)
-		return dht
-	}
-
-	panic("The bootstrap node could not be reached!")
+	return dht
 }
 
-// Find new peers using the dht and a routing discovery.
-func (service *P2PService) findPeers(hashtable *dht.IpfsDHT) <-chan peer.AddrInfo {
-	d := discovery.NewRoutingDiscovery(hashtable)
-	discovery.Advertise(context.Background(), d, discoveryIdentifier)
-
-	peers, err := d.FindPeers(service.ctx, discoveryIdentifier)
-	if err != nil {
-		panic(err)
-	}
-	return peers
+// Handle a stream that was opened by another peer.
+func (service *P2PService) handleStream(stream network.Stream) {
+	service.bind(stream)
 }
 
 // Bind to another peer via an obtained stream.
-func (service *P2PService) bind(stream network.Stream) {
+// Returns an error if the peer is incompatible.
+func (service *P2PService) bind(stream network.Stream) error {
 	// Create a new stream binding
-	reader := bufio.NewReader(stream)
-	writer := bufio.NewWriter(stream)
-	binding := bufio.NewReadWriter(reader, writer)
+	binding := &Binding{
+		protocol: stream.Protocol(),
+		remote:   stream.Conn().RemotePeer(),
+		stream:   stream,
+		reader:   bufio.NewReader(stream),
+		writer:   bufio.NewWriter(stream),
+	}
+
+	// Peers of the older protocol cannot send a handshake,
+	// they are accepted during the transition period
+	if binding.protocol == streamProtocolV2 {
+		if err := service.handshake(binding, stream.SetReadDeadline); err != nil {
+			log.Printf(
+				"Disconnected incompatible peer %s (reason: %s)\n",
+				color.Sprintf(fmt.Sprintf("%s", binding.remote), color.Warning), err,
+			)
+			stream.Reset()
+			return err
+		}
+	}
 
 	service.mutex.Lock()
 	service.bindings = append(service.bindings, binding)
 	service.mutex.Unlock()
+	service.connections.connect(binding.remote)
 
 	// Continuously read incoming data
 	go service.listen(binding, func() {
-		log.Println(color.Sprintf("A node disconnected.", color.Warning))
-		// Remove the binding from the bindings list
-		service.mutex.Lock()
-		n := 0
-		for _, bi := range service.bindings {
-			if bi != binding {
-				service.bindings = append(service.bindings, bi)
-				n++
-			}
+		log.Printf(
+			"Disconnected: %s\n",
+			color.Sprintf(fmt.Sprintf("%s", binding.remote), color.Warning),
+		)
+		info := service.addrInfo(binding)
+		stream.Reset()
+		service.unbind(binding)
+		// Reconnect the peer, unless it is still bound via another stream
+		if !service.isBound(binding.remote) {
+			service.connections.disconnect(info, time.Now())
 		}
-		service.bindings = service.bindings[:n]
-		service.mutex.Unlock()
 	})
+	return nil
+}
+
+// Remove a binding from the bindings list.
+func (service *P2PService) unbind(binding *Binding) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	n := 0
+	for _, b := range service.bindings {
+		if b != binding {
+			service.bindings[n] = b
+			n++
+		}
+	}
+	// Release the removed bindings for the garbage collector
+	for i := n; i < len(service.bindings); i++ {
+		service.bindings[i] = nil
+	}
+	service.bindings = service.bindings[:n]
 }
 
 // Continously listen on a binding.
 func (service *P2PService) listen(binding *Binding, onDisconnect func()) {
 	for {
-		str, err := binding.ReadString('\n')
-		bytes := []byte(str)
-		if err != nil {
-			// If an error occured, stop listening
+		envelope, err := binding.read(service)
+		if err != nil && !isMessageError(err) {
+			// Stop listening on closed streams and streams
+			// that cannot be read any further
+			service.Penalize(binding.remote.Pretty(), penaltyFor(err), err)
 			break
 		}
-		if str == "\n" {
-			continue
+		if err == nil {
+			err = service.dispatch(envelope, binding.remote)
 		}
-
-		for _, subscriber := range service.incomingSubscribers {
-			subscriber <- bytes
+		if err != nil {
+			service.reportDropped(binding.remote, err)
 		}
 	}
 	onDisconnect()
 }
 
-func (service *P2PService) SubscribeIncoming(channel chan []byte) {
-	service.incomingSubscribers = append(service.incomingSubscribers, channel)
+// Register a handler for incoming messages of the given type.
+// The messages are queued for the handler with the default options.
+func (service *P2PService) Handle(t MessageType, handler MessageHandler) {
+	service.HandleWithOptions(t, defaultHandlerOptions(t), handler)
 }
 
-func (service *P2PService) SubscribeOutgoing(channel chan []byte) {
-	service.outgoingSubscribers = append(service.outgoingSubscribers, channel)
+// Set the decoder for incoming messages without envelope.
+// Such messages are accepted during the transition to the
+// enveloped message format.
+func (service *P2PService) SetLegacyDecoder(decoder LegacyDecoder) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	service.legacyDecoder = decoder
 }
 
-// Broadcast an object to all bound peers and the dashboard.
-// The object will be JSON serialized for transfer.
-func (service *P2PService) Broadcast(object interface{}) {
-	bytes, err := json.Marshal(object)
-	if err != nil {
-		panic(err)
+// Pass an incoming message from the given remote peer to the
+// subscribers and queue it for the registered handler. Errors of
+// the handler are reported when the message was handled.
+func (service *P2PService) dispatch(envelope *Envelope, remote peer.ID) error {
+	// The sender is only trusted if it is the connected peer
+	if envelope.Sender == "" {
+		envelope.Sender = remote.Pretty()
+	} else if envelope.Sender != remote.Pretty() {
+		return ErrSenderMismatch
 	}
 
-	for _, subscriber := range service.outgoingSubscribers {
-		subscriber <- bytes
+	service.mutex.RLock()
+	queue, ok := service.handlers[envelope.Type]
+	service.mutex.RUnlock()
+	if !ok {
+		return ErrUnknownMessageType
+	}
+	if err := service.markSeen(envelope); err != nil {
+		return err
 	}
 
-	service.mutex.RLock()
-	for _, binding := range service.bindings {
-		_, err := binding.WriteString(fmt.Sprintf("%s\n", string(bytes)))
-		if err != nil {
-			log.Println("Error writing to buffer")
-			panic(err)
-		}
-		err = binding.Flush()
+	if service.incomingSubscribers.count() > 0 {
+		payload, err := envelope.JSONPayload()
 		if err != nil {
-			log.Println("Error flushing buffer")
-			continue
+			return ErrMessageNotDecodable
 		}
+		service.incomingSubscribers.publish(payload)
 	}
+
+	queue.deliver(queuedEnvelope{envelope, remote})
+	return nil
+}
+
+// Wrap a message without envelope into an envelope,
+// using the legacy decoder to determine its type.
+func (service *P2PService) decodeLegacy(bytes []byte) (*Envelope, error) {
+	service.mutex.RLock()
+	decoder := service.legacyDecoder
 	service.mutex.RUnlock()
+	if decoder == nil {
+		return nil, ErrMissingEnvelope
+	}
+
+	t, ok := decoder(bytes)
+	if !ok {
+		return nil, ErrUnknownMessageType
+	}
+	return &Envelope{Type: t, Payload: bytes}, nil
+}
+
+// Broadcast a message of the given type to all bound peers
+// and the dashboard. The payload is serialized for the protocol
+// of each peer and wrapped in an envelope for transfer.
+func (service *P2PService) Broadcast(t MessageType, payload interface{}) {
+	service.Relay(t, payload, "")
 }
@@ -0,0 +1,36 @@
+package peer
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRunStopsWhenContextIsDone(t *testing.T) {
+	os.Setenv("P2P_PORT", "0")
+	defer os.Unsetenv("P2P_PORT")
+
+	service := NewService()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		service.Run(ctx, BootstrapConfig{})
+		close(stopped)
+	}()
+
+	// Wait until the host was created
+	for service.ID() == "" {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected the service to stop after the context is done")
+	}
+	if addrs := service.host.Network().ListenAddresses(); len(addrs) != 0 {
+		t.Errorf("Expected the host to be closed, but it listens on %v", addrs)
+	}
+}
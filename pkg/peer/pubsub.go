@@ -0,0 +1,213 @@
+package peer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+
+	host "github.com/libp2p/go-libp2p-host"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+)
+
+var (
+	ErrIgnoreMessage = errors.New("Message is ignored!")
+)
+
+// An error of a message that the forwarding peer could have
+// detected on its own, e.g. a malformed payload or an invalid
+// signature. Only these errors penalize the forwarding peer.
+type InvalidMessageError struct {
+	Reason error
+}
+
+func (e InvalidMessageError) Error() string {
+	return e.Reason.Error()
+}
+
+// A function that validates a message which was received on a topic,
+// before the message is handled and forwarded to other peers.
+// Invalid messages are rejected. They only penalize the forwarding
+// peer if they are malformed or if the validator returns an
+// `InvalidMessageError`, since other errors may depend on the
+// chain of this node.
+// Messages which are valid, but should not be forwarded, e.g.
+// because they are already known, are ignored by returning
+// `ErrIgnoreMessage`. Ignored messages are not passed to the
+// handler. The sender of the envelope is the forwarding peer.
+type TopicValidator func(envelope *Envelope) error
+
+// Get the name of the topic on which messages of the given
+// type are published. Topics are namespaced by the network id,
+// so that nodes of different networks don't share messages.
+func TopicName(networkID string, t MessageType) string {
+	return fmt.Sprintf("/peerbridge/%s/%s", networkID, t)
+}
+
+// Identify pubsub messages by the hash of their data, so that the
+// same message is only forwarded once, even when it is published
+// by multiple peers.
+func messageID(message *pb.Message) string {
+	hash := sha256.Sum256(message.Data)
+	return hex.EncodeToString(hash[:])
+}
+
+// Set the id of the network in which messages are published.
+func (service *P2PService) SetNetworkID(networkID string) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	service.networkID = networkID
+}
+
+// Register a topic on which messages of the given type are published.
+// Incoming messages are validated with the given validator before they
+// are passed to the handler. Copies of the messages which are sent by
+// older peers via streams are handled as gossip messages.
+// The topic is joined when `Run` is called.
+func (service *P2PService) HandleTopic(t MessageType, validator TopicValidator, handler MessageHandler) {
+	service.HandleGossip(t, handler)
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	service.topicValidators[t] = validator
+}
+
+// Join the registered topics using GossipSub.
+func (service *P2PService) joinTopics(host host.Host) error {
+	ps, err := pubsub.NewGossipSub(
+		service.ctx, host,
+		pubsub.WithMessageIdFn(messageID),
+		pubsub.WithMaxMessageSize(MaxFrameSize),
+	)
+	if err != nil {
+		return err
+	}
+
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	for t, validator := range service.topicValidators {
+		name := TopicName(service.networkID, t)
+		err := ps.RegisterTopicValidator(name, service.topicValidator(host.ID(), t, validator))
+		if err != nil {
+			return err
+		}
+		topic, err := ps.Join(name)
+		if err != nil {
+			return err
+		}
+		subscription, err := topic.Subscribe()
+		if err != nil {
+			return err
+		}
+		service.topics[t] = topic
+		go service.readTopic(host.ID(), subscription)
+	}
+	return nil
+}
+
+// Wrap a topic validator for the pubsub router. Messages which
+// are published by this node are always accepted.
+func (service *P2PService) topicValidator(self peer.ID, t MessageType, validator TopicValidator) pubsub.ValidatorEx {
+	return func(ctx context.Context, from peer.ID, message *pubsub.Message) pubsub.ValidationResult {
+		if from == self {
+			return pubsub.ValidationAccept
+		}
+		envelope, err := readFrame(bytes.NewReader(message.Data))
+		if err == nil && envelope.Type != t {
+			err = ErrUnknownMessageType
+		}
+		if err != nil {
+			service.Penalize(from.Pretty(), PenaltyMalformedMessage, err)
+			return pubsub.ValidationReject
+		}
+		envelope.Sender = from.Pretty()
+		envelope.Forwarded = true
+		err = validator(envelope)
+		switch err.(type) {
+		case nil:
+			return pubsub.ValidationAccept
+		case InvalidMessageError:
+			service.Penalize(from.Pretty(), PenaltyMalformedMessage, err)
+			return pubsub.ValidationReject
+		}
+		if err == ErrIgnoreMessage {
+			return pubsub.ValidationIgnore
+		}
+		service.Penalize(from.Pretty(), penaltyFor(err), err)
+		return pubsub.ValidationReject
+	}
+}
+
+// Continuously read the validated messages of a topic subscription.
+func (service *P2PService) readTopic(self peer.ID, subscription *pubsub.Subscription) {
+	for {
+		message, err := subscription.Next(service.ctx)
+		if err != nil {
+			return
+		}
+		if message.ReceivedFrom == self {
+			continue
+		}
+		envelope, err := readFrame(bytes.NewReader(message.Data))
+		if err == nil {
+			// The forwarding peer is the origin of the message
+			// from our perspective, it knows the message data
+			envelope.Sender = ""
+			envelope.Forwarded = true
+			err = service.dispatch(envelope, message.ReceivedFrom)
+		}
+		if err != nil {
+			service.reportDropped(message.ReceivedFrom, err)
+		}
+	}
+}
+
+// Publish a message of the given type on its topic. Older peers
+// which cannot use topics receive the message via their stream,
+// unless they are the origin of the message.
+//
+// There are no inventory messages: GossipSub announces the ids
+// of recent messages to the peers outside of its mesh, which
+// request the messages they have not seen yet. Older peers
+// don't understand announcements and need the full message.
+func (service *P2PService) Publish(t MessageType, payload interface{}, origin string) {
+	// The message is signed by the pubsub router, the
+	// sender is omitted to make the message data unique
+	message, err := newOutgoingMessage(t, "", payload)
+	if err != nil {
+		panic(err)
+	}
+	// Don't handle the message again, if it comes back
+	service.markSeen(message.envelope)
+
+	service.outgoingSubscribers.publish(message.envelope.Payload)
+
+	service.mutex.RLock()
+	topic := service.topics[t]
+	service.mutex.RUnlock()
+	if topic != nil {
+		frame, err := message.encodeFrame()
+		if err == nil {
+			err = topic.Publish(service.ctx, frame)
+		}
+		if err != nil {
+			log.Printf("Error publishing to topic %s: %s\n", t, err)
+		}
+	}
+
+	service.mutex.RLock()
+	defer service.mutex.RUnlock()
+	for _, binding := range service.bindings {
+		if binding.handshake != nil || binding.remote.Pretty() == origin {
+			continue
+		}
+		if err := binding.write(message); err != nil {
+			log.Printf("Error writing to peer %s: %s\n", binding.remote, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+)
+
+func TestTopicName(t *testing.T) {
+	if name := TopicName("testnet", "blockchain/newBlock"); name != "/peerbridge/testnet/blockchain/newBlock" {
+		t.Errorf("Unexpected topic name %s", name)
+	}
+}
+
+func TestTopicValidator(t *testing.T) {
+	message, err := newOutgoingMessage("test/topic", "", testPayload{Text: "topic"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := message.encodeFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	self, remote := peer.ID("self"), peer.ID("remote")
+	service := &P2PService{}
+
+	cases := map[error]pubsub.ValidationResult{
+		nil:                      pubsub.ValidationAccept,
+		ErrIgnoreMessage:         pubsub.ValidationIgnore,
+		errors.New("Malformed!"): pubsub.ValidationReject,
+	}
+	for result, expected := range cases {
+		validator := service.topicValidator(self, "test/topic", func(*Envelope) error { return result })
+		got := validator(context.Background(), remote, &pubsub.Message{Message: &pb.Message{Data: frame}})
+		if got != expected {
+			t.Errorf("Expected %d for %v, got %d", expected, result, got)
+		}
+	}
+
+	rejecting := func(*Envelope) error { return errors.New("Malformed!") }
+	validator := service.topicValidator(self, "test/topic", rejecting)
+	if validator(context.Background(), self, &pubsub.Message{Message: &pb.Message{}}) != pubsub.ValidationAccept {
+		t.Error("Expected own messages to be accepted")
+	}
+	validator = service.topicValidator(self, "test/other", func(*Envelope) error { return nil })
+	if validator(context.Background(), remote, &pubsub.Message{Message: &pb.Message{Data: frame}}) != pubsub.ValidationReject {
+		t.Error("Expected messages of another type to be rejected")
+	}
+}
+
+func TestTopicValidatorPenalties(t *testing.T) {
+	message, err := newOutgoingMessage("test/topic", "", testPayload{Text: "topic"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := message.encodeFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	self := peer.ID("self")
+	forwarder, err := peer.Decode("QmRKEapfMSqsyKPdc4fBGsXR7ULrXCsJ1eKKScycQ1GkW8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		data    []byte
+		err     error
+		penalty int
+	}{
+		{name: "malformed frame", data: []byte{0}, penalty: PenaltyMalformedMessage},
+		{name: "invalid message", data: frame, err: InvalidMessageError{errors.New("Invalid!")}, penalty: PenaltyMalformedMessage},
+		{name: "depends on the chain", data: frame, err: errors.New("Unknown stake!"), penalty: 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			service := NewService()
+			validator := service.topicValidator(self, "test/topic", func(envelope *Envelope) error {
+				if !envelope.Forwarded || envelope.Sender != forwarder.Pretty() {
+					t.Errorf("Expected a forwarded envelope from %s", forwarder)
+				}
+				return c.err
+			})
+			got := validator(context.Background(), forwarder, &pubsub.Message{Message: &pb.Message{Data: c.data}})
+			if got != pubsub.ValidationReject {
+				t.Errorf("Expected the message to be rejected, got %d", got)
+			}
+			if score := service.reputation.get(forwarder, time.Now()); score != c.penalty {
+				t.Errorf("Expected score %d, got %d", c.penalty, score)
+			}
+		})
+	}
+}
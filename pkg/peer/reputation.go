@@ -0,0 +1,295 @@
+package peer
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/control"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/peerbridge/peerbridge/pkg/color"
+)
+
+const (
+	// The score at or below which a peer is banned.
+	BanThreshold = -100
+
+	// The maximum score of a peer. The score is capped,
+	// so that a peer cannot save up for misbehaviour.
+	MaxScore = 100
+
+	// The duration for which a peer is banned.
+	BanDuration = 1 * time.Hour
+
+	// The interval after which a score recovers by
+	// one point towards zero.
+	scoreDecayInterval = 1 * time.Minute
+
+	// The key under which bans are stored in the peerstore.
+	bannedUntilKey = "peerbridge/bannedUntil"
+)
+
+// The penalties and rewards that change the score of a peer.
+const (
+	PenaltyMalformedMessage = -10
+	PenaltyOversizedMessage = -50
+	PenaltyInvalidData      = -25
+	RewardUsefulData        = 1
+)
+
+// The reputation of a peer.
+type PeerScore struct {
+	// The id of the peer.
+	ID string `json:"id"`
+
+	// The current score of the peer.
+	Score int `json:"score"`
+
+	// The time until which the peer is banned, if it is banned.
+	BannedUntil *time.Time `json:"bannedUntil"`
+}
+
+// The score of a peer at the time of its last update.
+type scoreState struct {
+	score   int
+	updated time.Time
+}
+
+// The scores of peers, which recover over time.
+type reputation struct {
+	scores map[peer.ID]*scoreState
+	mutex  sync.Mutex
+}
+
+func newReputation() *reputation {
+	return &reputation{scores: map[peer.ID]*scoreState{}}
+}
+
+// Get the score of a state at the given time, after the decay.
+func (s *scoreState) at(now time.Time) int {
+	decay := int(now.Sub(s.updated) / scoreDecayInterval)
+	switch {
+	case s.score > decay:
+		return s.score - decay
+	case s.score < -decay:
+		return s.score + decay
+	}
+	return 0
+}
+
+// Change the score of a peer by the given delta.
+// Returns the new score of the peer.
+func (r *reputation) adjust(id peer.ID, delta int, now time.Time) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	score := 0
+	if state, ok := r.scores[id]; ok {
+		score = state.at(now)
+	}
+	score += delta
+	if score > MaxScore {
+		score = MaxScore
+	}
+	r.scores[id] = &scoreState{score: score, updated: now}
+	return score
+}
+
+// Get the score of a peer at the given time.
+func (r *reputation) get(id peer.ID, now time.Time) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if state, ok := r.scores[id]; ok {
+		return state.at(now)
+	}
+	return 0
+}
+
+// Forget the score of a peer, e.g. after it was banned.
+func (r *reputation) reset(id peer.ID) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.scores, id)
+}
+
+// Get the ids of all peers that have a score at the given time.
+// Peers whose score recovered to zero are forgotten.
+func (r *reputation) peers(now time.Time) []peer.ID {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ids := []peer.ID{}
+	for id, state := range r.scores {
+		if state.at(now) == 0 {
+			delete(r.scores, id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Get the penalty for an error that occurred while
+// reading or handling a message from a peer.
+func penaltyFor(err error) int {
+	switch err {
+	case ErrFrameTooLarge:
+		return PenaltyOversizedMessage
+	case ErrUnknownMessageType, ErrDuplicateMessage:
+		// Newer peers may send types that we don't know yet
+		return 0
+	case ErrUnknownFlags:
+		return PenaltyMalformedMessage
+	}
+	if isMessageError(err) {
+		return PenaltyMalformedMessage
+	}
+	return 0
+}
+
+// Lower the score of a peer which sent invalid data.
+// The peer is banned if its score drops too low.
+func (service *P2PService) Penalize(remote string, penalty int, reason error) {
+	id, err := peer.Decode(remote)
+	if err != nil || penalty >= 0 {
+		return
+	}
+	service.adjustScore(id, penalty, reason)
+}
+
+// Raise the score of a peer which sent useful data.
+func (service *P2PService) Reward(remote string, reward int) {
+	id, err := peer.Decode(remote)
+	if err != nil || reward <= 0 {
+		return
+	}
+	service.adjustScore(id, reward, nil)
+}
+
+func (service *P2PService) adjustScore(id peer.ID, delta int, reason error) {
+	score := service.reputation.adjust(id, delta, time.Now())
+	if reason == nil {
+		return
+	}
+	log.Printf(
+		"Penalized peer %s by %s (score: %s, reason: %s)\n",
+		color.Sprintf(id.Pretty(), color.Warning),
+		color.Sprintf(fmt.Sprintf("%d", -delta), color.Warning),
+		color.Sprintf(fmt.Sprintf("%d", score), color.Info), reason,
+	)
+	if score <= BanThreshold {
+		service.ban(id, reason)
+	}
+}
+
+// Ban a peer temporarily and disconnect from it. The ban
+// is stored in the peerstore of the host.
+func (service *P2PService) ban(id peer.ID, reason error) {
+	until := time.Now().Add(BanDuration)
+	service.mutex.RLock()
+	host := service.host
+	service.mutex.RUnlock()
+	if host != nil {
+		host.Peerstore().Put(id, bannedUntilKey, until)
+	}
+	service.reputation.reset(id)
+	service.connections.drop(id)
+
+	log.Printf(
+		"Banned peer %s until %s (reason: %s)\n",
+		color.Sprintf(id.Pretty(), color.Error),
+		until.Format(time.RFC3339), reason,
+	)
+
+	service.closePeer(id)
+}
+
+// Get the time until which a peer is banned.
+// Returns false if the peer is not banned.
+func (service *P2PService) bannedUntil(id peer.ID) (time.Time, bool) {
+	service.mutex.RLock()
+	host := service.host
+	service.mutex.RUnlock()
+	if host == nil {
+		return time.Time{}, false
+	}
+	value, err := host.Peerstore().Get(id, bannedUntilKey)
+	if err != nil {
+		return time.Time{}, false
+	}
+	until, ok := value.(time.Time)
+	if !ok || time.Now().After(until) {
+		return time.Time{}, false
+	}
+	return until, true
+}
+
+// Check if a peer is currently banned.
+func (service *P2PService) IsBanned(id peer.ID) bool {
+	_, banned := service.bannedUntil(id)
+	return banned
+}
+
+// Get the scores of all peers that are scored or banned,
+// ordered from the best to the worst score.
+func (service *P2PService) Scores() []PeerScore {
+	now := time.Now()
+	ids := service.reputation.peers(now)
+	scored := map[peer.ID]bool{}
+	for _, id := range ids {
+		scored[id] = true
+	}
+	service.mutex.RLock()
+	host := service.host
+	service.mutex.RUnlock()
+	if host != nil {
+		ids = append(ids, host.Peerstore().Peers()...)
+	}
+
+	scores := []PeerScore{}
+	listed := map[peer.ID]bool{}
+	for _, id := range ids {
+		if listed[id] {
+			continue
+		}
+		listed[id] = true
+		score := PeerScore{ID: id.Pretty(), Score: service.reputation.get(id, now)}
+		if until, banned := service.bannedUntil(id); banned {
+			score.BannedUntil = &until
+		} else if !scored[id] {
+			continue
+		}
+		scores = append(scores, score)
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		return scores[i].Score > scores[j].Score
+	})
+	return scores
+}
+
+// A connection gater that refuses connections to banned peers.
+type banGater struct {
+	service *P2PService
+}
+
+func (g *banGater) InterceptPeerDial(id peer.ID) bool {
+	return !g.service.IsBanned(id)
+}
+
+func (g *banGater) InterceptAddrDial(id peer.ID, addr multiaddr.Multiaddr) bool {
+	return !g.service.IsBanned(id)
+}
+
+func (g *banGater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+	return true
+}
+
+func (g *banGater) InterceptSecured(direction network.Direction, id peer.ID, addrs network.ConnMultiaddrs) bool {
+	return !g.service.IsBanned(id)
+}
+
+func (g *banGater) InterceptUpgraded(conn network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
@@ -0,0 +1,55 @@
+package peer
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestReputation(t *testing.T) {
+	r := newReputation()
+	id := peer.ID("remote")
+	now := time.Now()
+
+	if score := r.adjust(id, PenaltyInvalidData, now); score != PenaltyInvalidData {
+		t.Errorf("Expected score %d, got %d", PenaltyInvalidData, score)
+	}
+	if score := r.adjust(id, PenaltyInvalidData, now); score != 2*PenaltyInvalidData {
+		t.Errorf("Expected penalties to add up, got %d", score)
+	}
+
+	// Scores recover towards zero over time
+	later := now.Add(10 * scoreDecayInterval)
+	if score := r.get(id, later); score != 2*PenaltyInvalidData+10 {
+		t.Errorf("Expected the score to recover, got %d", score)
+	}
+	if score := r.get(id, now.Add(time.Hour)); score != 0 {
+		t.Errorf("Expected the score to recover to zero, got %d", score)
+	}
+	if len(r.peers(now.Add(time.Hour))) != 0 {
+		t.Error("Expected recovered peers to be forgotten")
+	}
+
+	// Rewards are capped
+	if score := r.adjust(id, 2*MaxScore, now); score != MaxScore {
+		t.Errorf("Expected the score to be capped at %d, got %d", MaxScore, score)
+	}
+}
+
+func TestPenaltyFor(t *testing.T) {
+	cases := map[error]int{
+		ErrFrameTooLarge:       PenaltyOversizedMessage,
+		ErrMessageNotDecodable: PenaltyMalformedMessage,
+		ErrMalformedPayload:    PenaltyMalformedMessage,
+		ErrUnknownMessageType:  0,
+		ErrDuplicateMessage:    0,
+		io.EOF:                 0,
+	}
+	for err, expected := range cases {
+		if penalty := penaltyFor(err); penalty != expected {
+			t.Errorf("Expected penalty %d for %s, got %d", expected, err, penalty)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package peer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/peerbridge/peerbridge/pkg/color"
+)
+
+const (
+	// The stream protocol for directed requests. Every request
+	// is sent on its own stream, which is closed after the
+	// response was received. Messages are sent as frames.
+	requestProtocol protocol.ID = "/peerbridge/request/1.0.0"
+
+	// The time after which a request without response fails.
+	RequestTimeout = 30 * time.Second
+
+	// The type of a response to a request.
+	ResponseMessageType MessageType = "peer/response"
+
+	// The type of a response to a request that failed.
+	ErrorResponseMessageType MessageType = "peer/error"
+)
+
+var (
+	ErrRequestTimeout   = errors.New("Request timed out!")
+	ErrResponseMismatch = errors.New("Response does not match the request!")
+)
+
+// A function that handles the requests of a type
+// and returns the payload of the response.
+type RequestHandler func(envelope *Envelope) (interface{}, error)
+
+// The payload of a response to a failed request.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// An error with which the requested peer responded.
+type RemoteError struct {
+	Message string
+}
+
+func (e RemoteError) Error() string {
+	return e.Message
+}
+
+// Check if the remote error corresponds to the given error.
+func (e RemoteError) Is(err error) bool {
+	return err != nil && e.Message == err.Error()
+}
+
+// Create a new random request id.
+func newRequestID() string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(bytes)
+}
+
+// Register a handler for incoming requests of the given type.
+func (service *P2PService) HandleRequest(t MessageType, handler RequestHandler) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	service.requestHandlers[t] = handler
+}
+
+// Check if the given peer is bound and completed the handshake.
+// Requests are only exchanged with such peers.
+func (service *P2PService) isCompatible(remote peer.ID) bool {
+	service.mutex.RLock()
+	defer service.mutex.RUnlock()
+	for _, binding := range service.bindings {
+		if binding.remote == remote && binding.handshake != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Send a request of the given type to a single peer and decode
+// the payload of its response into the given value. Returns a
+// `RemoteError` if the peer could not handle the request.
+func (service *P2PService) Request(remote string, t MessageType, payload interface{}, response interface{}) error {
+	id, err := peer.Decode(remote)
+	if err != nil || service.host == nil || !service.isCompatible(id) {
+		return ErrPeerNotConnected
+	}
+
+	ctx, cancel := context.WithTimeout(service.ctx, RequestTimeout)
+	defer cancel()
+	stream, err := service.host.NewStream(ctx, id, requestProtocol)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	deadline, _ := ctx.Deadline()
+	stream.SetDeadline(deadline)
+
+	message, err := newOutgoingMessage(t, service.id, payload)
+	if err != nil {
+		stream.Reset()
+		return err
+	}
+	message.envelope.RequestID = newRequestID()
+	frame, err := message.encodeFrame()
+	if err != nil {
+		stream.Reset()
+		return err
+	}
+	if _, err = stream.Write(frame); err != nil {
+		stream.Reset()
+		return asRequestError(err)
+	}
+
+	envelope, err := readFrame(stream)
+	if err != nil {
+		stream.Reset()
+		return asRequestError(err)
+	}
+	if envelope.RequestID != message.envelope.RequestID {
+		return ErrResponseMismatch
+	}
+
+	switch envelope.Type {
+	case ResponseMessageType:
+		if err := envelope.Decode(response); err != nil {
+			return ErrMessageNotDecodable
+		}
+		return nil
+	case ErrorResponseMessageType:
+		var e errorResponse
+		if err := envelope.Decode(&e); err != nil {
+			return ErrMessageNotDecodable
+		}
+		return RemoteError{e.Error}
+	}
+	return ErrResponseMismatch
+}
+
+// Convert stream errors after an exceeded deadline to `ErrRequestTimeout`.
+func asRequestError(err error) error {
+	if timeout, ok := err.(interface{ Timeout() bool }); ok && timeout.Timeout() {
+		return ErrRequestTimeout
+	}
+	return err
+}
+
+// Handle a request stream that was opened by another peer.
+func (service *P2PService) handleRequestStream(stream network.Stream) {
+	remote := stream.Conn().RemotePeer()
+	if !service.isCompatible(remote) {
+		stream.Reset()
+		return
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(RequestTimeout))
+
+	envelope, err := readFrame(stream)
+	if err != nil {
+		stream.Reset()
+		return
+	}
+
+	responseType, responsePayload := ResponseMessageType, interface{}(nil)
+	result, err := service.handleRequest(envelope, remote)
+	if err != nil {
+		responseType, responsePayload = ErrorResponseMessageType, errorResponse{err.Error()}
+	} else {
+		responsePayload = result
+	}
+
+	message, err := newOutgoingMessage(responseType, service.id, responsePayload)
+	if err != nil {
+		stream.Reset()
+		return
+	}
+	message.envelope.RequestID = envelope.RequestID
+	frame, err := message.encodeFrame()
+	if err == nil {
+		_, err = stream.Write(frame)
+	}
+	if err != nil {
+		log.Printf(
+			"Error responding to peer %s: %s\n",
+			color.Sprintf(remote.Pretty(), color.Warning), err,
+		)
+		stream.Reset()
+	}
+}
+
+// Pass an incoming request from the given remote
+// peer to the registered request handler.
+func (service *P2PService) handleRequest(envelope *Envelope, remote peer.ID) (interface{}, error) {
+	if envelope.RequestID == "" {
+		return nil, ErrResponseMismatch
+	}
+	if envelope.Sender == "" {
+		envelope.Sender = remote.Pretty()
+	} else if envelope.Sender != remote.Pretty() {
+		return nil, ErrSenderMismatch
+	}
+
+	service.mutex.RLock()
+	handler, ok := service.requestHandlers[envelope.Type]
+	service.mutex.RUnlock()
+	if !ok {
+		return nil, ErrUnknownMessageType
+	}
+	return handler(envelope)
+}
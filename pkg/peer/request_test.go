@@ -0,0 +1,62 @@
+package peer
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestRequestIDIsFramed(t *testing.T) {
+	message, err := newOutgoingMessage("test/request", "sender", testPayload{Text: "request"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message.envelope.RequestID = newRequestID()
+	frame, err := message.encodeFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := readFrame(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelope.RequestID != message.envelope.RequestID {
+		t.Errorf("Expected request id %s, got %s", message.envelope.RequestID, envelope.RequestID)
+	}
+}
+
+func TestHandleRequest(t *testing.T) {
+	service := &P2PService{requestHandlers: map[MessageType]RequestHandler{}}
+	service.HandleRequest("test/request", func(envelope *Envelope) (interface{}, error) {
+		return envelope.Sender, nil
+	})
+	remote := peer.ID("remote")
+
+	result, err := service.handleRequest(&Envelope{Type: "test/request", RequestID: "1"}, remote)
+	if err != nil || result != remote.Pretty() {
+		t.Errorf("Expected the handler to receive the remote as sender, got %v (%v)", result, err)
+	}
+
+	cases := map[error]*Envelope{
+		ErrResponseMismatch:   {Type: "test/request"},
+		ErrSenderMismatch:     {Type: "test/request", RequestID: "1", Sender: "other"},
+		ErrUnknownMessageType: {Type: "test/unknown", RequestID: "1"},
+	}
+	for expected, envelope := range cases {
+		if _, err := service.handleRequest(envelope, remote); err != expected {
+			t.Errorf("Expected %s, got %v", expected, err)
+		}
+	}
+}
+
+func TestRemoteErrorIs(t *testing.T) {
+	err := error(RemoteError{ErrPeerNotConnected.Error()})
+	if !errors.Is(err, ErrPeerNotConnected) {
+		t.Error("Expected the remote error to match the error with the same message")
+	}
+	if errors.Is(err, ErrRequestTimeout) {
+		t.Error("Expected the remote error not to match another error")
+	}
+}
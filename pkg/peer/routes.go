@@ -1,6 +1,7 @@
 package peer
 
 import (
+	"errors"
 	"net/http"
 
 	. "github.com/peerbridge/peerbridge/pkg/http"
@@ -8,17 +9,173 @@
 
 // Get an url to the currently active peer.
 // This method can be used by other peers to bind to this
-// peer via the given multi addresses.
+// peer via the given multi addresses. The peer id is also
+// returned in the `X-Peer-ID` header, once the host is created.
 func getPeerURLs(w http.ResponseWriter, r *http.Request) {
 	var urls []string
 	for _, url := range Service.URLs {
 		urls = append(urls, url.String())
 	}
+	if id := Service.ID(); id != "" {
+		w.Header().Set("X-Peer-ID", id)
+	}
 	Json(w, r, http.StatusOK, urls)
 }
 
+// The response format for the `getPeerScores` method.
+type GetPeerScoresResponse struct {
+	// The scores of the peers, ordered from the best to the worst score.
+	Scores *[]PeerScore `json:"scores"`
+}
+
+// Get the scores of all scored and banned peers via http.
+//
+// This http route returns:
+// - 200 OK together with the peer scores
+func getPeerScores(w http.ResponseWriter, r *http.Request) {
+	scores := Service.Scores()
+	Json(w, r, http.StatusOK, GetPeerScoresResponse{&scores})
+}
+
+// The response format for the `getPeerList` method.
+type GetPeerListResponse struct {
+	// The connected peers, ordered by their id.
+	Peers *[]PeerInfo `json:"peers"`
+}
+
+// Get information about all connected peers via http.
+//
+// This http route returns:
+// - 200 OK together with the connected peers
+func getPeerList(w http.ResponseWriter, r *http.Request) {
+	peers := Service.Peers()
+	Json(w, r, http.StatusOK, GetPeerListResponse{&peers})
+}
+
+// Get the connectivity health of the node via http.
+//
+// This http route returns:
+// - 200 OK together with the connectivity health
+func getPeerHealth(w http.ResponseWriter, r *http.Request) {
+	Json(w, r, http.StatusOK, Service.Health())
+}
+
+// The response format for the `getPeerSubscriptions` method.
+type GetPeerSubscriptionsResponse struct {
+	// The stats of the subscribers to incoming messages.
+	Incoming *[]SubscriptionStats `json:"incoming"`
+
+	// The stats of the subscribers to outgoing messages.
+	Outgoing *[]SubscriptionStats `json:"outgoing"`
+
+	// The stats of the queues of the message handlers.
+	Handlers *[]SubscriptionStats `json:"handlers"`
+}
+
+// Get the queue stats of all message subscribers and handlers via http.
+//
+// This http route returns:
+// - 200 OK together with the subscription stats
+func getPeerSubscriptions(w http.ResponseWriter, r *http.Request) {
+	incoming, outgoing := Service.Subscriptions()
+	handlers := Service.HandlerQueues()
+	Json(w, r, http.StatusOK, GetPeerSubscriptionsResponse{&incoming, &outgoing, &handlers})
+}
+
+// The request format for the `connectPeer` method.
+type ConnectPeerRequest struct {
+	// The multiaddress of the peer, including the peer id.
+	Address *string `json:"address"`
+}
+
+// The request format for the `disconnectPeer` method.
+type DisconnectPeerRequest struct {
+	// The id of the peer.
+	ID *string `json:"id"`
+}
+
+// The response format for the `connectPeer`
+// and `disconnectPeer` methods.
+type ManagePeerResponse struct {
+	// The id of the connected or disconnected peer.
+	ID string `json:"id"`
+}
+
+// Connect to a peer via http. This is an admin route.
+//
+// This http route returns:
+// - 400 BadRequest if the request or the address was malformed
+// - 500 InternalServerError if the peer could not be connected
+// - 200 OK together with the id of the connected peer
+func connectPeer(w http.ResponseWriter, r *http.Request) {
+	var request ConnectPeerRequest
+
+	err := DecodeJSONBody(w, r, &request)
+	if err != nil {
+		BadRequest(w, err)
+		return
+	}
+
+	if request.Address == nil {
+		BadRequest(w, errors.New("The address must be supplied!"))
+		return
+	}
+
+	id, err := Service.Connect(*request.Address)
+	if err == ErrInvalidPeerAddress {
+		BadRequest(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	Json(w, r, http.StatusOK, ManagePeerResponse{id})
+}
+
+// Disconnect from a peer via http. This is an admin route.
+//
+// This http route returns:
+// - 400 BadRequest if the request was malformed
+// - 404 NotFound if the peer is not connected
+// - 500 InternalServerError if the peer could not be disconnected
+// - 200 OK together with the id of the disconnected peer
+func disconnectPeer(w http.ResponseWriter, r *http.Request) {
+	var request DisconnectPeerRequest
+
+	err := DecodeJSONBody(w, r, &request)
+	if err != nil {
+		BadRequest(w, err)
+		return
+	}
+
+	if request.ID == nil {
+		BadRequest(w, errors.New("The peer id must be supplied!"))
+		return
+	}
+
+	err = Service.Disconnect(*request.ID)
+	if err == ErrPeerNotConnected {
+		NotFound(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	Json(w, r, http.StatusOK, ManagePeerResponse{*request.ID})
+}
+
 func Routes() (router *Router) {
 	router = NewRouter()
 	router.Get("/urls", getPeerURLs)
+	router.Get("/scores", getPeerScores)
+	router.Get("/list", getPeerList)
+	router.Get("/health", getPeerHealth)
+	router.Get("/subscriptions", getPeerSubscriptions)
+	router.Post("/connect", Authenticated(connectPeer))
+	router.Post("/disconnect", Authenticated(disconnectPeer))
 	return
 }
@@ -0,0 +1,83 @@
+package peer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+)
+
+// Send a request with the given body and admin token to the peer routes.
+func serveRoute(path, body, token string) int {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	Routes().ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestManagePeerRoutesRequireAdminToken(t *testing.T) {
+	os.Unsetenv("ADMIN_TOKEN")
+	for _, path := range []string{"/connect", "/disconnect"} {
+		if code := serveRoute(path, `{}`, "secret"); code != http.StatusForbidden {
+			t.Errorf("Expected %d for %s without a configured token, got %d", http.StatusForbidden, path, code)
+		}
+	}
+
+	os.Setenv("ADMIN_TOKEN", "secret")
+	defer os.Unsetenv("ADMIN_TOKEN")
+	for _, path := range []string{"/connect", "/disconnect"} {
+		if code := serveRoute(path, `{}`, ""); code != http.StatusUnauthorized {
+			t.Errorf("Expected %d for %s without a token, got %d", http.StatusUnauthorized, path, code)
+		}
+		if code := serveRoute(path, `{}`, "wrong"); code != http.StatusUnauthorized {
+			t.Errorf("Expected %d for %s with a wrong token, got %d", http.StatusUnauthorized, path, code)
+		}
+	}
+}
+
+func TestManagePeerRoutes(t *testing.T) {
+	os.Setenv("ADMIN_TOKEN", "secret")
+	defer os.Unsetenv("ADMIN_TOKEN")
+	defer func(service *P2PService) { Service = service }(Service)
+	Service = NewService()
+
+	// The routes fail while the service is not running
+	address := `{"address": "/ip4/127.0.0.1/tcp/9080/p2p/QmRKEapfMSqsyKPdc4fBGsXR7ULrXCsJ1eKKScycQ1GkW8"}`
+	if code := serveRoute("/connect", address, "secret"); code != http.StatusInternalServerError {
+		t.Errorf("Expected %d while the service is not running, got %d", http.StatusInternalServerError, code)
+	}
+
+	host, err := libp2p.New(context.Background(), libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer host.Close()
+	Service.host = host
+
+	cases := []struct {
+		path string
+		body string
+		code int
+	}{
+		{"/connect", `{`, http.StatusBadRequest},
+		{"/connect", `{}`, http.StatusBadRequest},
+		{"/connect", `{"address": "not-an-address"}`, http.StatusBadRequest},
+		{"/connect", `{"address": "/ip4/127.0.0.1/tcp/9080"}`, http.StatusBadRequest},
+		{"/disconnect", `{}`, http.StatusBadRequest},
+		{"/disconnect", `{"id": "QmRKEapfMSqsyKPdc4fBGsXR7ULrXCsJ1eKKScycQ1GkW8"}`, http.StatusNotFound},
+		{"/disconnect", `{"id": "not-a-peer-id"}`, http.StatusNotFound},
+	}
+	for _, c := range cases {
+		if code := serveRoute(c.path, c.body, "secret"); code != c.code {
+			t.Errorf("Expected %d for %s with %s, got %d", c.code, c.path, c.body, code)
+		}
+	}
+}
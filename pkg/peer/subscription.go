@@ -0,0 +1,255 @@
+package peer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// The default number of messages that are
+	// queued for a subscriber.
+	DefaultQueueSize = 256
+
+	// The default time that a sender waits for a subscriber
+	// with the `Backpressure` policy, before the message is dropped.
+	DefaultBackpressureTimeout = 1 * time.Second
+)
+
+// The policy for messages to a subscriber whose queue is full.
+type OverflowPolicy int
+
+const (
+	// Drop the new message.
+	DropNewest OverflowPolicy = iota
+
+	// Drop the oldest queued message to make room for the new message.
+	DropOldest
+
+	// Wait until the queue has room, but drop the new
+	// message if the subscriber is too slow. Note that
+	// this slows down the network reads of the node.
+	Backpressure
+)
+
+// The options of a subscription.
+type SubscriptionOptions struct {
+	// The name of the subscriber, which is shown in the stats.
+	Name string
+
+	// The number of messages that are queued, or 0 for `DefaultQueueSize`.
+	QueueSize int
+
+	// The policy for new messages when the queue is full.
+	Policy OverflowPolicy
+
+	// The time that a sender waits with the `Backpressure` policy,
+	// or 0 for `DefaultBackpressureTimeout`.
+	Timeout time.Duration
+}
+
+// The stats of a subscription.
+type SubscriptionStats struct {
+	// The name of the subscriber.
+	Name string `json:"name"`
+
+	// The number of queued messages and the size of the queue.
+	Queued   int `json:"queued"`
+	Capacity int `json:"capacity"`
+
+	// The number of messages which were queued for the subscriber.
+	Delivered uint64 `json:"delivered"`
+
+	// The number of messages which were dropped, because
+	// the queue of the subscriber was full.
+	Dropped uint64 `json:"dropped"`
+}
+
+// A subscription to the messages of the p2p service. Messages
+// are queued for each subscriber, so that a slow subscriber
+// doesn't block the network reads of the node.
+type Subscription struct {
+	// The counters of the subscription, which are updated atomically.
+	// They are kept first for the 64-bit alignment on 32-bit platforms.
+	delivered uint64
+	dropped   uint64
+
+	options  SubscriptionOptions
+	messages chan []byte
+
+	// Closed when the subscriber unsubscribed, to
+	// release senders that wait for the subscriber.
+	closed chan struct{}
+	once   sync.Once
+
+	// Held by senders while they send to the messages channel,
+	// so that the channel isn't closed in the meantime.
+	mutex  sync.RWMutex
+	isDone bool
+
+	list *subscriptionList
+}
+
+// Get the channel on which the messages are received. The
+// channel is closed after the subscriber unsubscribed.
+func (s *Subscription) Messages() <-chan []byte {
+	return s.messages
+}
+
+// Get the number of messages which were dropped for the subscriber.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Get the stats of the subscription.
+func (s *Subscription) Stats() SubscriptionStats {
+	return SubscriptionStats{
+		Name:      s.options.Name,
+		Queued:    len(s.messages),
+		Capacity:  cap(s.messages),
+		Delivered: atomic.LoadUint64(&s.delivered),
+		Dropped:   atomic.LoadUint64(&s.dropped),
+	}
+}
+
+// Stop receiving messages. The messages channel is closed.
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() {
+		s.list.remove(s)
+		close(s.closed)
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.isDone = true
+		close(s.messages)
+	})
+}
+
+// Queue a message for the subscriber according to its policy.
+func (s *Subscription) deliver(message []byte) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.isDone {
+		return
+	}
+
+	select {
+	case s.messages <- message:
+		atomic.AddUint64(&s.delivered, 1)
+		return
+	default:
+	}
+
+	switch s.options.Policy {
+	case DropOldest:
+		// The subscriber may read concurrently, so that
+		// the queue can have room again without dropping
+		select {
+		case <-s.messages:
+			atomic.AddUint64(&s.dropped, 1)
+		default:
+		}
+		select {
+		case s.messages <- message:
+			atomic.AddUint64(&s.delivered, 1)
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	case Backpressure:
+		timer := time.NewTimer(s.options.Timeout)
+		defer timer.Stop()
+		select {
+		case s.messages <- message:
+			atomic.AddUint64(&s.delivered, 1)
+		case <-timer.C:
+			atomic.AddUint64(&s.dropped, 1)
+		case <-s.closed:
+		}
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// A thread safe list of subscriptions.
+type subscriptionList struct {
+	subscriptions []*Subscription
+	mutex         sync.RWMutex
+}
+
+// Add a new subscription with the given options.
+func (l *subscriptionList) subscribe(options SubscriptionOptions) *Subscription {
+	if options.QueueSize <= 0 {
+		options.QueueSize = DefaultQueueSize
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultBackpressureTimeout
+	}
+	s := &Subscription{
+		options:  options,
+		messages: make(chan []byte, options.QueueSize),
+		closed:   make(chan struct{}),
+		list:     l,
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.subscriptions = append(l.subscriptions, s)
+	return s
+}
+
+func (l *subscriptionList) remove(s *Subscription) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	n := 0
+	for _, other := range l.subscriptions {
+		if other != s {
+			l.subscriptions[n] = other
+			n++
+		}
+	}
+	for i := n; i < len(l.subscriptions); i++ {
+		l.subscriptions[i] = nil
+	}
+	l.subscriptions = l.subscriptions[:n]
+}
+
+// Get a copy of the current subscriptions.
+func (l *subscriptionList) all() []*Subscription {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return append([]*Subscription{}, l.subscriptions...)
+}
+
+// Get the number of subscriptions.
+func (l *subscriptionList) count() int {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return len(l.subscriptions)
+}
+
+// Queue a message for all subscribers.
+func (l *subscriptionList) publish(message []byte) {
+	for _, s := range l.all() {
+		s.deliver(message)
+	}
+}
+
+// Subscribe to the messages which are received from other peers.
+func (service *P2PService) SubscribeIncoming(options SubscriptionOptions) *Subscription {
+	return service.incomingSubscribers.subscribe(options)
+}
+
+// Subscribe to the messages which are sent to other peers.
+func (service *P2PService) SubscribeOutgoing(options SubscriptionOptions) *Subscription {
+	return service.outgoingSubscribers.subscribe(options)
+}
+
+// Get the stats of all incoming and outgoing subscriptions.
+func (service *P2PService) Subscriptions() (incoming []SubscriptionStats, outgoing []SubscriptionStats) {
+	incoming, outgoing = []SubscriptionStats{}, []SubscriptionStats{}
+	for _, s := range service.incomingSubscribers.all() {
+		incoming = append(incoming, s.Stats())
+	}
+	for _, s := range service.outgoingSubscribers.all() {
+		outgoing = append(outgoing, s.Stats())
+	}
+	return
+}
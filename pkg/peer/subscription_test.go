@@ -0,0 +1,95 @@
+package peer
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDropNewestPolicy(t *testing.T) {
+	var l subscriptionList
+	s := l.subscribe(SubscriptionOptions{QueueSize: 2, Policy: DropNewest})
+
+	for _, m := range []string{"a", "b", "c"} {
+		l.publish([]byte(m))
+	}
+	if s.Dropped() != 1 {
+		t.Errorf("Expected 1 dropped message, got %d", s.Dropped())
+	}
+	if m := string(<-s.Messages()); m != "a" {
+		t.Errorf("Expected the oldest message to be kept, got %s", m)
+	}
+}
+
+func TestDropOldestPolicy(t *testing.T) {
+	var l subscriptionList
+	s := l.subscribe(SubscriptionOptions{QueueSize: 2, Policy: DropOldest})
+
+	for _, m := range []string{"a", "b", "c"} {
+		l.publish([]byte(m))
+	}
+	if s.Dropped() != 1 {
+		t.Errorf("Expected 1 dropped message, got %d", s.Dropped())
+	}
+	if m := string(<-s.Messages()); m != "b" {
+		t.Errorf("Expected the oldest message to be dropped, got %s", m)
+	}
+	if stats := s.Stats(); stats.Delivered != 3 || stats.Queued != 1 || stats.Capacity != 2 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestBackpressurePolicy(t *testing.T) {
+	var l subscriptionList
+	s := l.subscribe(SubscriptionOptions{QueueSize: 1, Policy: Backpressure, Timeout: time.Minute})
+
+	l.publish([]byte("a"))
+	// The sender waits until the subscriber reads
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		<-s.Messages()
+	}()
+	l.publish([]byte("b"))
+	if s.Dropped() != 0 {
+		t.Errorf("Expected the sender to wait for the subscriber, got %d dropped messages", s.Dropped())
+	}
+
+	// Messages are dropped if the subscriber is too slow
+	slow := l.subscribe(SubscriptionOptions{QueueSize: 1, Policy: Backpressure, Timeout: time.Millisecond})
+	slow.deliver([]byte("a"))
+	slow.deliver([]byte("b"))
+	if slow.Dropped() != 1 {
+		t.Errorf("Expected 1 dropped message, got %d", slow.Dropped())
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	var l subscriptionList
+	s := l.subscribe(SubscriptionOptions{QueueSize: 1, Policy: Backpressure, Timeout: time.Hour})
+	other := l.subscribe(SubscriptionOptions{})
+
+	l.publish([]byte("a"))
+	// A waiting sender is released on unsubscribe
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		l.publish([]byte("b"))
+	}()
+	time.Sleep(5 * time.Millisecond)
+	s.Unsubscribe()
+	wg.Wait()
+	s.Unsubscribe()
+
+	if l.count() != 1 || l.all()[0] != other {
+		t.Error("Expected only the other subscription to be left")
+	}
+	<-s.Messages()
+	if _, ok := <-s.Messages(); ok {
+		t.Error("Expected the messages channel to be closed")
+	}
+	l.publish([]byte("c"))
+	if stats := other.Stats(); stats.Delivered != 3 {
+		t.Errorf("Expected the other subscriber to receive all messages, got %d", stats.Delivered)
+	}
+}
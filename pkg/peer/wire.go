@@ -0,0 +1,247 @@
+package peer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+const (
+	// The maximum size of a single message on the wire,
+	// after decompression. Larger messages are rejected
+	// and the sending peer is disconnected. This fits a block
+	// of 512 transactions with 16 KiB of data each, which
+	// is encoded as hex.
+	MaxFrameSize = 16 * 1024 * 1024
+
+	// The minimum size of a frame body from which on
+	// the body is compressed.
+	compressionThreshold = 1024
+
+	// The flag that marks a compressed frame body.
+	frameFlagCompressed byte = 1 << 0
+)
+
+var (
+	ErrFrameTooLarge = errors.New("Frame exceeds the maximum frame size!")
+	ErrUnknownFlags  = errors.New("Frame has unknown flags!")
+)
+
+// A codec to decode message payloads.
+type codec interface {
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// The codec of the frame based protocol version 2.0.0.
+type cborCodec struct{}
+
+func (cborCodec) Unmarshal(data []byte, v interface{}) error { return cbor.Unmarshal(data, v) }
+
+// The envelope of a message in a frame. The payload is embedded
+// as raw CBOR, so that it is only decoded by its handler.
+type frameEnvelope struct {
+	Type    MessageType     `cbor:"type"`
+	Version int             `cbor:"version"`
+	Sender  string          `cbor:"sender"`
+	Payload cbor.RawMessage `cbor:"payload"`
+
+	RequestID string `cbor:"requestID,omitempty"`
+}
+
+// A message that is sent to peers. The message is
+// encoded at most once per protocol version.
+type outgoingMessage struct {
+	envelope *Envelope
+	payload  interface{}
+	line     []byte
+	frame    []byte
+}
+
+// Create a new outgoing message of the given type.
+func newOutgoingMessage(t MessageType, sender string, payload interface{}) (*outgoingMessage, error) {
+	envelope, err := NewEnvelope(t, sender, payload)
+	if err != nil {
+		return nil, err
+	}
+	return &outgoingMessage{envelope: envelope, payload: payload}, nil
+}
+
+// Get the message as a JSON line for the protocol version 1.0.0.
+func (m *outgoingMessage) encodeLine() ([]byte, error) {
+	if m.line == nil {
+		bytes, err := json.Marshal(m.envelope)
+		if err != nil {
+			return nil, err
+		}
+		m.line = append(bytes, '\n')
+	}
+	return m.line, nil
+}
+
+// Get the message as a length-prefixed CBOR frame
+// for the protocol version 2.0.0.
+func (m *outgoingMessage) encodeFrame() ([]byte, error) {
+	if m.frame != nil {
+		return m.frame, nil
+	}
+	payload, err := cbor.Marshal(m.payload)
+	if err != nil {
+		return nil, err
+	}
+	body, err := cbor.Marshal(frameEnvelope{
+		Type:      m.envelope.Type,
+		Version:   m.envelope.Version,
+		Sender:    m.envelope.Sender,
+		Payload:   payload,
+		RequestID: m.envelope.RequestID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > MaxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+
+	var flags byte
+	if len(body) >= compressionThreshold {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		if _, err := writer.Write(body); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		// Only use the compressed body if it is actually smaller
+		if compressed.Len() < len(body) {
+			body = compressed.Bytes()
+			flags |= frameFlagCompressed
+		}
+	}
+
+	// Frame = 4 byte body length + 1 byte flags + body
+	frame := make([]byte, 5+len(body))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(body)))
+	frame[4] = flags
+	copy(frame[5:], body)
+	m.frame = frame
+	return frame, nil
+}
+
+// Read a single newline-terminated line of at most `MaxFrameSize`
+// bytes. Longer lines are rejected instead of being buffered.
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	line := []byte{}
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > MaxFrameSize {
+			return nil, ErrFrameTooLarge
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return line, nil
+	}
+}
+
+// Read a single length-prefixed frame and decode its envelope.
+func readFrame(reader io.Reader) (*Envelope, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	flags := header[4]
+	if length > MaxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+	if flags&^frameFlagCompressed != 0 {
+		return nil, ErrUnknownFlags
+	}
+
+	// The length is untrusted, so the buffer only grows
+	// with the bytes that are actually received
+	var buffer bytes.Buffer
+	n, err := buffer.ReadFrom(io.LimitReader(reader, int64(length)))
+	if err != nil {
+		return nil, err
+	}
+	if n < int64(length) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	body := buffer.Bytes()
+
+	if flags&frameFlagCompressed != 0 {
+		gzipReader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, ErrMessageNotDecodable
+		}
+		// Guard against compression bombs
+		body, err = ioutil.ReadAll(io.LimitReader(gzipReader, MaxFrameSize+1))
+		if err != nil {
+			return nil, ErrMessageNotDecodable
+		}
+		if len(body) > MaxFrameSize {
+			return nil, ErrFrameTooLarge
+		}
+	}
+
+	return decodeFrameBody(body)
+}
+
+// Decode the envelope of an uncompressed frame body.
+func decodeFrameBody(body []byte) (*Envelope, error) {
+	var envelope frameEnvelope
+	if err := cbor.Unmarshal(body, &envelope); err != nil {
+		return nil, ErrMessageNotDecodable
+	}
+	if envelope.Type == "" {
+		return nil, ErrMissingEnvelope
+	}
+	if envelope.Version < 1 || envelope.Version > ProtocolVersion {
+		return nil, ErrUnsupportedVersion
+	}
+	if len(envelope.Payload) == 0 {
+		return nil, ErrMissingPayload
+	}
+	return &Envelope{
+		Type:      envelope.Type,
+		Version:   envelope.Version,
+		Sender:    envelope.Sender,
+		Payload:   json.RawMessage(envelope.Payload),
+		RequestID: envelope.RequestID,
+		codec:     cborCodec{},
+	}, nil
+}
+
+// Convert a value decoded from CBOR into a value that
+// can be encoded as JSON, i.e. with string map keys.
+func toJSONCompatible(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for key, element := range value {
+			m[fmt.Sprintf("%v", key)] = toJSONCompatible(element)
+		}
+		return m
+	case []interface{}:
+		for i, element := range value {
+			value[i] = toJSONCompatible(element)
+		}
+		return value
+	default:
+		return value
+	}
+}
@@ -0,0 +1,85 @@
+package peer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+type testPayload struct {
+	Text string  `json:"text"`
+	Data *[]byte `json:"data"`
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	data := []byte(strings.Repeat("peerbridge", 1000))
+	for _, payload := range []testPayload{{Text: "small"}, {Text: "large", Data: &data}} {
+		message, err := newOutgoingMessage("test/message", "sender", payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame, err := message.encodeFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressed := frame[4]&frameFlagCompressed != 0
+		if compressed != (payload.Data != nil) {
+			t.Errorf("Expected only the large frame to be compressed")
+		}
+
+		envelope, err := readFrame(bytes.NewReader(frame))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if envelope.Type != "test/message" || envelope.Sender != "sender" {
+			t.Errorf("Expected the envelope to be preserved, got %v", envelope)
+		}
+		var decoded testPayload
+		if err := envelope.Decode(&decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Text != payload.Text {
+			t.Errorf("Expected text %s, got %s", payload.Text, decoded.Text)
+		}
+
+		// The dashboard expects the same JSON as for the line protocol
+		jsonPayload, err := envelope.JSONPayload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, _ := json.Marshal(payload)
+		var got, want interface{}
+		json.Unmarshal(jsonPayload, &got)
+		json.Unmarshal(expected, &want)
+		gotBytes, _ := json.Marshal(got)
+		wantBytes, _ := json.Marshal(want)
+		if !bytes.Equal(gotBytes, wantBytes) {
+			t.Errorf("Expected JSON payload %s, got %s", wantBytes, gotBytes)
+		}
+	}
+}
+
+func TestFrameLimits(t *testing.T) {
+	var header [5]byte
+	binary.BigEndian.PutUint32(header[0:4], MaxFrameSize+1)
+	if _, err := readFrame(bytes.NewReader(header[:])); err != ErrFrameTooLarge {
+		t.Errorf("Expected %s, got %v", ErrFrameTooLarge, err)
+	}
+
+	binary.BigEndian.PutUint32(header[0:4], 0)
+	header[4] = 0x80
+	if _, err := readFrame(bytes.NewReader(header[:])); err != ErrUnknownFlags {
+		t.Errorf("Expected %s, got %v", ErrUnknownFlags, err)
+	}
+
+	// A truncated frame is rejected, even if it announces the maximum size
+	binary.BigEndian.PutUint32(header[0:4], MaxFrameSize)
+	header[4] = 0
+	truncated := append(header[:], make([]byte, 10)...)
+	if _, err := readFrame(bytes.NewReader(truncated)); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected %s, got %v", io.ErrUnexpectedEOF, err)
+	}
+}